@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/llm"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manages the cache of LLM responses kept under .vyb/cache.",
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Removes every cached LLM response of the current project.",
+	Run:   CacheClear,
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheClearCmd)
+}
+
+// CacheClear is the cobra handler for `vyb cache clear`.
+func CacheClear(_ *cobra.Command, _ []string) {
+	distToRoot, err := project.FindDistanceToRoot(".")
+	if err != nil {
+		fmt.Printf("Error locating project root: %v\n", err)
+		os.Exit(1)
+	}
+	if err := llm.NewCache(distToRoot, nil).Clear(); err != nil {
+		fmt.Printf("Error clearing cache: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Cache cleared.")
+}
@@ -0,0 +1,351 @@
+// Package cmdtest runs vyb commands end to end, against a fake LLM server
+// and a temporary workspace.
+//
+// NewServer starts an httptest.Server emulating the OpenAI chat-completions
+// and Gemini generateContent endpoints, and points the built-in providers to
+// it through the OPENAI_BASE_URL and GEMINI_BASE_URL environment variables.
+// Responses are scripted per structured-output schema:
+//
+//	srv := cmdtest.NewServer(t)
+//	srv.On(cmdtest.WorkspaceChangeProposal, `{"summary":"s","description":"d","proposals":[...]}`)
+//	dir := cmdtest.NewWorkspace(t, map[string]string{"main.go": "package main"})
+//	err := cmd.Run(ctx, "code", "main.go")
+//
+// Annotation requests (module contexts) are answered with generic defaults
+// unless scripted, so `vyb init` and `vyb update` work out of the box.
+// Streaming requests are answered with server-sent events, the response
+// split into a few chunks.
+package cmdtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Schemas of the structured outputs requested by vyb, as named in
+// OpenAI requests.
+const (
+	WorkspaceChangeProposal = "workspace_change_proposal"
+	ModuleContext           = "module_context_schema"
+	ModuleExternalContext   = "module_external_context"
+	ChatReply               = "chat_reply"
+)
+
+// Failure is a scripted response that fails with the given HTTP status.
+// Statuses vyb retries (429, 5xx) make the command wait for its backoff.
+type Failure struct {
+	Status  int
+	Message string
+}
+
+// Request is a request received by the server.
+type Request struct {
+	// Provider is "openai" or "gemini".
+	Provider string
+	Model    string
+	Schema   string
+	// Messages holds the text of every message, system prompt included.
+	Messages []string
+	// Stream reports whether the streaming variant of the API was called.
+	Stream bool
+}
+
+// Prompt returns every message of the request, joined.
+func (r Request) Prompt() string {
+	return strings.Join(r.Messages, "\n\n")
+}
+
+// Server is a fake LLM server. It is safe for concurrent use, as vyb
+// annotates modules in parallel.
+type Server struct {
+	URL string
+
+	t        testing.TB
+	mu       sync.Mutex
+	scripts  map[string][]any
+	funcs    map[string]func(Request) any
+	requests []Request
+}
+
+// NewServer starts a fake LLM server, closed when the test ends, and points
+// the OpenAI and Gemini providers to it. It sets environment variables, so
+// tests using it cannot run in parallel.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	s := &Server{
+		t:       t,
+		scripts: map[string][]any{},
+		funcs:   map[string]func(Request) any{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.serveOpenAI)
+	mux.HandleFunc("POST /v1beta/models/{method}", s.serveGemini)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	s.URL = srv.URL
+
+	t.Setenv("OPENAI_BASE_URL", srv.URL+"/v1")
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+	t.Setenv("GEMINI_BASE_URL", srv.URL+"/v1beta")
+	t.Setenv("GEMINI_API_KEY", "test-gemini-key")
+	return s
+}
+
+// On queues responses for the given schema, served in order, one per
+// request. A response is either a JSON document, as a string or any value
+// encoded with encoding/json, or a Failure.
+func (s *Server) On(schema string, responses ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[schema] = append(s.scripts[schema], responses...)
+}
+
+// OnFunc answers requests for the given schema with f, once the responses
+// queued with On are exhausted.
+func (s *Server) OnFunc(schema string, f func(Request) any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.funcs[schema] = f
+}
+
+// Requests returns the requests received for the given schema, or every
+// request when schema is empty.
+func (s *Server) Requests(schema string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Request
+	for _, r := range s.requests {
+		if schema == "" || r.Schema == schema {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// respond records r and returns the response scripted for it.
+func (s *Server) respond(r Request) any {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	if queue := s.scripts[r.Schema]; len(queue) > 0 {
+		s.scripts[r.Schema] = queue[1:]
+		s.mu.Unlock()
+		return queue[0]
+	}
+	f := s.funcs[r.Schema]
+	s.mu.Unlock()
+
+	if f != nil {
+		return f(r)
+	}
+	switch r.Schema {
+	case ModuleContext:
+		return map[string]string{
+			"internal_context": "Internal context of the module.",
+			"public_context":   "Public context of the module.",
+		}
+	case ModuleExternalContext:
+		return externalContexts(r)
+	}
+	s.t.Errorf("cmdtest: no response scripted for a %s request", r.Schema)
+	return Failure{Status: http.StatusBadRequest, Message: "no response scripted for " + r.Schema}
+}
+
+var moduleHeading = regexp.MustCompile(`(?m)^## Module: (.+)$`)
+
+// externalContexts answers an external-context request with a generic
+// context for every module listed in it.
+func externalContexts(r Request) any {
+	type module struct {
+		Name            string `json:"name"`
+		ExternalContext string `json:"external_context"`
+	}
+	modules := []module{}
+	for _, m := range moduleHeading.FindAllStringSubmatch(r.Prompt(), -1) {
+		modules = append(modules, module{Name: m[1], ExternalContext: "External context of " + m[1] + "."})
+	}
+	return map[string]any{"modules": modules}
+}
+
+// encode returns the JSON text of a scripted response.
+func encode(response any) (string, error) {
+	if s, ok := response.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(response)
+	return string(b), err
+}
+
+func (s *Server) serveOpenAI(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Model    string `json:"model"`
+		Stream   bool   `json:"stream"`
+		Messages []struct {
+			Content string `json:"content"`
+		} `json:"messages"`
+		ResponseFormat struct {
+			JSONSchema struct {
+				Name string `json:"name"`
+			} `json:"json_schema"`
+		} `json:"response_format"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r := Request{Provider: "openai", Model: body.Model, Schema: body.ResponseFormat.JSONSchema.Name, Stream: body.Stream}
+	for _, m := range body.Messages {
+		r.Messages = append(r.Messages, m.Content)
+	}
+
+	response := s.respond(r)
+	if f, ok := response.(Failure); ok {
+		writeJSON(w, f.Status, map[string]any{"error": map[string]string{"message": f.Message, "type": "test_failure"}})
+		return
+	}
+	content, err := encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	usage := map[string]int{"prompt_tokens": len(r.Prompt()) / 4, "completion_tokens": len(content) / 4}
+	if r.Stream {
+		var events []any
+		for _, c := range chunks(content) {
+			events = append(events, map[string]any{
+				"model":   body.Model,
+				"choices": []any{map[string]any{"delta": map[string]string{"content": c}}},
+			})
+		}
+		events = append(events, map[string]any{"model": body.Model, "choices": []any{}, "usage": usage})
+		writeEvents(w, events, "[DONE]")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"model": body.Model,
+		"choices": []any{
+			map[string]any{"message": map[string]string{"role": "assistant", "content": content}},
+		},
+		"usage": usage,
+	})
+}
+
+func (s *Server) serveGemini(w http.ResponseWriter, req *http.Request) {
+	method := req.PathValue("method")
+	model, ok := strings.CutSuffix(method, ":generateContent")
+	stream := false
+	if !ok {
+		model, stream = strings.CutSuffix(method, ":streamGenerateContent")
+	}
+	if !ok && !stream {
+		http.NotFound(w, req)
+		return
+	}
+	var body struct {
+		Contents []struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"contents"`
+		GenerationConfig struct {
+			ResponseSchema struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"responseSchema"`
+		} `json:"generationConfig"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r := Request{Provider: "gemini", Model: model, Schema: geminiSchema(body.GenerationConfig.ResponseSchema.Properties), Stream: stream}
+	for _, c := range body.Contents {
+		for _, p := range c.Parts {
+			r.Messages = append(r.Messages, p.Text)
+		}
+	}
+
+	response := s.respond(r)
+	if f, ok := response.(Failure); ok {
+		writeJSON(w, f.Status, map[string]any{"error": map[string]any{"code": f.Status, "message": f.Message, "status": "TEST_FAILURE"}})
+		return
+	}
+	content, err := encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	candidate := func(text string) []any {
+		return []any{map[string]any{"content": map[string]any{"role": "model", "parts": []any{map[string]string{"text": text}}}}}
+	}
+	usage := map[string]int{"promptTokenCount": len(r.Prompt()) / 4, "candidatesTokenCount": len(content) / 4}
+	if r.Stream {
+		var events []any
+		for _, c := range chunks(content) {
+			events = append(events, map[string]any{"candidates": candidate(c)})
+		}
+		events = append(events, map[string]any{"usageMetadata": usage})
+		writeEvents(w, events, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"candidates":    candidate(content),
+		"usageMetadata": usage,
+	})
+}
+
+// geminiSchema names the schema of a Gemini request, which only carries
+// the schema itself, from its top-level properties.
+func geminiSchema(properties map[string]json.RawMessage) string {
+	has := func(name string) bool {
+		_, ok := properties[name]
+		return ok
+	}
+	switch {
+	case has("answer"):
+		return ChatReply
+	case has("modules"):
+		return ModuleExternalContext
+	case has("internal_context"):
+		return ModuleContext
+	case has("proposals"):
+		return WorkspaceChangeProposal
+	}
+	return ""
+}
+
+// chunks splits content into at most four chunks, as a streamed response
+// would be received.
+func chunks(content string) []string {
+	size := len(content)/4 + 1
+	var out []string
+	for len(content) > size {
+		out = append(out, content[:size])
+		content = content[size:]
+	}
+	return append(out, content)
+}
+
+// writeEvents answers with a server-sent event per value, followed by the
+// done sentinel, if any.
+func writeEvents(w http.ResponseWriter, events []any, done string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range events {
+		b, _ := json.Marshal(e)
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", b)
+	}
+	if done != "" {
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", done)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
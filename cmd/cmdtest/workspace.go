@@ -0,0 +1,41 @@
+package cmdtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// NewWorkspace writes files, keyed by slash-separated paths, to a new
+// temporary directory and makes it the working directory until the test
+// ends. It returns the directory.
+func NewWorkspace(t testing.TB, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		WriteFile(t, filepath.Join(dir, filepath.FromSlash(name)), content)
+	}
+	t.Chdir(dir)
+	return dir
+}
+
+// WriteFile writes content to path, creating its parent directories.
+func WriteFile(t testing.TB, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ReadFile returns the content of path, or fails the test.
+func ReadFile(t testing.TB, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,287 @@
+package cmd_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/cmd"
+	"github.com/vybdev/vyb/cmd/cmdtest"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+var workspaceFiles = map[string]string{
+	"main.go":         "package main\n\n// TODO(vyb): print a greeting\nfunc main() {}\n",
+	"go.sum":          "example.com/dep v1.0.0 h1:checksum\n",
+	"docs/README.md":  "# Greeter\n",
+	"greet/greet.go":  "package greet\n",
+	"greet/helper.go": "package greet\n\nfunc helper() {}\n",
+}
+
+func proposal(files ...payload.FileChangeProposal) payload.WorkspaceChangeProposal {
+	return payload.WorkspaceChangeProposal{Summary: "feat: greet", Description: "Prints a greeting.", Proposals: files}
+}
+
+// initProject runs `vyb init` in a new workspace, with the given provider.
+func initProject(t *testing.T, srv *cmdtest.Server, provider string) string {
+	t.Helper()
+	dir := cmdtest.NewWorkspace(t, workspaceFiles)
+	if err := cmd.Run(context.Background(), "init", "--provider", provider); err != nil {
+		t.Fatalf("vyb init: %v", err)
+	}
+	if len(srv.Requests(cmdtest.ModuleContext)) == 0 {
+		t.Fatalf("vyb init did not annotate any module")
+	}
+	return dir
+}
+
+func TestEndToEnd_OpenAI(t *testing.T) {
+	srv := cmdtest.NewServer(t)
+	dir := initProject(t, srv, "openai")
+
+	meta := cmdtest.ReadFile(t, filepath.Join(dir, ".vyb", "metadata.yaml"))
+	if !strings.Contains(meta, "Public context of the module.") || !strings.Contains(meta, "External context of greet.") {
+		t.Errorf("expected the annotations to be stored, got:\n%s", meta)
+	}
+	for _, r := range srv.Requests("") {
+		if r.Provider != "openai" {
+			t.Errorf("unexpected %s request after configuring openai", r.Provider)
+		}
+	}
+
+	// update picks up new files.
+	cmdtest.WriteFile(t, filepath.Join(dir, "greet", "format.go"), "package greet\n")
+	if err := cmd.Run(context.Background(), "update"); err != nil {
+		t.Fatalf("vyb update: %v", err)
+	}
+	if meta := cmdtest.ReadFile(t, filepath.Join(dir, ".vyb", "metadata.yaml")); !strings.Contains(meta, "greet/format.go") {
+		t.Errorf("expected vyb update to record greet/format.go, got:\n%s", meta)
+	}
+
+	srv.On(cmdtest.WorkspaceChangeProposal, proposal(
+		payload.FileChangeProposal{FileName: "main.go", Content: "package main\n\nfunc main() { println(\"hello\") }\n"},
+		payload.FileChangeProposal{FileName: "greeting.go", Content: "package main\n"},
+	))
+	if err := cmd.Run(context.Background(), "code", "main.go"); err != nil {
+		t.Fatalf("vyb code: %v", err)
+	}
+
+	reqs := srv.Requests(cmdtest.WorkspaceChangeProposal)
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 change request, got %d", len(reqs))
+	}
+	if !reqs[0].Stream {
+		t.Errorf("expected the change request to be streamed")
+	}
+	for _, r := range srv.Requests(cmdtest.ModuleContext) {
+		if r.Stream {
+			t.Errorf("expected annotation requests not to be streamed")
+		}
+	}
+	prompt := reqs[0].Prompt()
+	if !strings.Contains(prompt, "TODO(vyb): print a greeting") || !strings.Contains(prompt, "# Module: `greet`") {
+		t.Errorf("expected the files of the root module, and the context of its submodules, in the request:\n%s", prompt)
+	}
+	// go.sum is excluded, and submodule files are summarized, not sent.
+	for _, excluded := range []string{"h1:checksum", "func helper()", "# Greeter"} {
+		if strings.Contains(prompt, excluded) {
+			t.Errorf("the request includes %q, which should not be selected", excluded)
+		}
+	}
+
+	if got := cmdtest.ReadFile(t, filepath.Join(dir, "main.go")); !strings.Contains(got, "hello") {
+		t.Errorf("main.go was not updated: %q", got)
+	}
+	if got := cmdtest.ReadFile(t, filepath.Join(dir, "greeting.go")); got != "package main\n" {
+		t.Errorf("greeting.go was not created: %q", got)
+	}
+}
+
+func TestEndToEnd_Gemini(t *testing.T) {
+	srv := cmdtest.NewServer(t)
+	dir := initProject(t, srv, "gemini")
+
+	srv.On(cmdtest.WorkspaceChangeProposal, proposal(
+		payload.FileChangeProposal{FileName: "greet/helper.go", Delete: true},
+	))
+	// --all brings descendant modules in scope.
+	if err := cmd.Run(context.Background(), "code", "--all"); err != nil {
+		t.Fatalf("vyb code: %v", err)
+	}
+
+	for _, r := range srv.Requests("") {
+		if r.Provider != "gemini" {
+			t.Errorf("unexpected %s request after configuring gemini", r.Provider)
+		}
+	}
+	reqs := srv.Requests(cmdtest.WorkspaceChangeProposal)
+	if len(reqs) != 1 || !strings.Contains(reqs[0].Prompt(), "func helper()") || !reqs[0].Stream {
+		t.Fatalf("expected one streamed change request including descendant modules, got %+v", reqs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "greet", "helper.go")); !os.IsNotExist(err) {
+		t.Errorf("expected greet/helper.go to be deleted, got %v", err)
+	}
+}
+
+func TestEndToEnd_Tools(t *testing.T) {
+	srv := cmdtest.NewServer(t)
+	dir := initProject(t, srv, "openai")
+
+	srv.On(cmdtest.ChatReply,
+		payload.ChatReply{Proposals: []payload.FileChangeProposal{}, ToolCalls: []payload.ToolCall{{Name: "grep", Path: "greet", Pattern: "func "}, {Name: "read_file", Path: "go.sum"}}},
+		payload.ChatReply{Summary: "feat: greet", Description: "Calls helper.", Proposals: []payload.FileChangeProposal{{FileName: "main.go", Content: "package main\n"}}},
+	)
+	if err := cmd.Run(context.Background(), "code", "--tools", "main.go"); err != nil {
+		t.Fatalf("vyb code --tools: %v", err)
+	}
+
+	if reqs := srv.Requests(cmdtest.WorkspaceChangeProposal); len(reqs) != 0 {
+		t.Errorf("expected the proposal to come from the tool loop, got %d change requests", len(reqs))
+	}
+	reqs := srv.Requests(cmdtest.ChatReply)
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(reqs))
+	}
+	if !strings.Contains(reqs[0].Prompt(), "`read_file`") {
+		t.Errorf("expected the tools to be described in the system prompt")
+	}
+	results := reqs[1].Messages[len(reqs[1].Messages)-1]
+	if !strings.Contains(results, "greet/helper.go:3: func helper() {}") || !strings.Contains(results, "go.sum does not exist or is excluded") {
+		t.Errorf("unexpected tool results:\n%s", results)
+	}
+	if got := cmdtest.ReadFile(t, filepath.Join(dir, "main.go")); got != "package main\n" {
+		t.Errorf("main.go was not updated: %q", got)
+	}
+}
+
+func TestEndToEnd_Edits(t *testing.T) {
+	srv := cmdtest.NewServer(t)
+	dir := initProject(t, srv, "openai")
+
+	srv.On(cmdtest.WorkspaceChangeProposal, proposal(
+		payload.FileChangeProposal{FileName: "main.go", Edits: []payload.Edit{{Search: "// TODO(vyb): print a greeting\nfunc main() {}", Replace: "func main() { println(\"hello\") }"}}},
+		payload.FileChangeProposal{FileName: "greet/helper.go", Diff: "--- a/greet/helper.go\n+++ b/greet/helper.go\n@@ -3 +3 @@\n-func helper() {}\n+func helper() string { return \"hello\" }\n"},
+		payload.FileChangeProposal{FileName: "greeting.go", Content: "package main\n"},
+	))
+	if err := cmd.Run(context.Background(), "code", "--all"); err != nil {
+		t.Fatalf("vyb code: %v", err)
+	}
+	if got := cmdtest.ReadFile(t, filepath.Join(dir, "main.go")); got != "package main\n\nfunc main() { println(\"hello\") }\n" {
+		t.Errorf("main.go was not edited: %q", got)
+	}
+	if got := cmdtest.ReadFile(t, filepath.Join(dir, "greet", "helper.go")); got != "package greet\n\nfunc helper() string { return \"hello\" }\n" {
+		t.Errorf("greet/helper.go was not patched: %q", got)
+	}
+	if got := cmdtest.ReadFile(t, filepath.Join(dir, "greeting.go")); got != "package main\n" {
+		t.Errorf("greeting.go was not created: %q", got)
+	}
+
+	// a hunk that does not apply fails the whole proposal.
+	srv.On(cmdtest.WorkspaceChangeProposal, proposal(
+		payload.FileChangeProposal{FileName: "greeting.go", Content: "package main\n\nconst greeting = \"hello\"\n"},
+		payload.FileChangeProposal{FileName: "main.go", Edits: []payload.Edit{{Search: "func main() {}", Replace: ""}}},
+	))
+	err := cmd.Run(context.Background(), "code", "--no-cache", "--all")
+	if err == nil || !strings.Contains(err.Error(), "main.go: edit 1: the search text was not found") {
+		t.Fatalf("expected the edit to be rejected, got %v", err)
+	}
+	if got := cmdtest.ReadFile(t, filepath.Join(dir, "greeting.go")); got != "package main\n" {
+		t.Errorf("greeting.go was modified: %q", got)
+	}
+}
+
+func TestEndToEnd_Budget(t *testing.T) {
+	srv := cmdtest.NewServer(t)
+	dir := initProject(t, srv, "openai")
+	cmdtest.WriteFile(t, filepath.Join(dir, "greet", "greet.go"), "package greet\n\n"+strings.Repeat("// Greetings in every language.\n", 200))
+	if err := cmd.Run(context.Background(), "update"); err != nil {
+		t.Fatalf("vyb update: %v", err)
+	}
+
+	// the request does not fit: nothing is sent.
+	cfg := "provider: openai\nbudget:\n  commands:\n    code: 2000\n"
+	cmdtest.WriteFile(t, filepath.Join(dir, ".vyb", "config.yaml"), cfg)
+	err := cmd.Run(context.Background(), "code", "--all")
+	if err == nil || !strings.Contains(err.Error(), "over the 2000 allowed by budget.commands.code") || !strings.Contains(err.Error(), "greet/greet.go") {
+		t.Fatalf("expected the request to be rejected with its largest files, got %v", err)
+	}
+	if reqs := srv.Requests(cmdtest.WorkspaceChangeProposal); len(reqs) != 0 {
+		t.Fatalf("expected no change request, got %d", len(reqs))
+	}
+
+	// with annotate, the files of greet are replaced by its annotations.
+	cmdtest.WriteFile(t, filepath.Join(dir, ".vyb", "config.yaml"), cfg+"  onOverflow: annotate\n")
+	srv.On(cmdtest.WorkspaceChangeProposal, proposal(payload.FileChangeProposal{FileName: "main.go", Content: "package main\n"}))
+	if err := cmd.Run(context.Background(), "code", "--all"); err != nil {
+		t.Fatalf("vyb code: %v", err)
+	}
+	reqs := srv.Requests(cmdtest.WorkspaceChangeProposal)
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 change request, got %d", len(reqs))
+	}
+	prompt := reqs[0].Prompt()
+	if strings.Contains(prompt, "Greetings in every language") || !strings.Contains(prompt, "# Module: `greet`") || !strings.Contains(prompt, "TODO(vyb)") {
+		t.Errorf("expected the files of greet to be replaced by its annotations:\n%s", prompt)
+	}
+}
+
+func TestEndToEnd_RejectsUnallowedChanges(t *testing.T) {
+	srv := cmdtest.NewServer(t)
+	dir := initProject(t, srv, "openai")
+
+	tests := []struct {
+		name  string
+		dir   string
+		files []payload.FileChangeProposal
+		want  string
+	}{
+		{
+			name:  "excluded file",
+			dir:   dir,
+			files: []payload.FileChangeProposal{{FileName: "main.go", Content: "changed"}, {FileName: "go.sum", Content: "changed"}},
+			want:  "[go.sum]",
+		},
+		{
+			name:  "project configuration",
+			dir:   dir,
+			files: []payload.FileChangeProposal{{FileName: ".vyb/config.yaml", Content: "changed"}},
+			want:  "[.vyb/config.yaml]",
+		},
+		{
+			name:  "outside the working directory",
+			dir:   filepath.Join(dir, "greet"),
+			files: []payload.FileChangeProposal{{FileName: "main.go", Content: "changed"}},
+			want:  "[main.go (outside working_dir)]",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Chdir(tc.dir)
+			srv.On(cmdtest.WorkspaceChangeProposal, proposal(tc.files...))
+			err := cmd.Run(context.Background(), "code", "--no-cache")
+			if err == nil || !strings.Contains(err.Error(), "unallowed files: "+tc.want) {
+				t.Fatalf("expected the proposal to be rejected, got %v", err)
+			}
+			if got := cmdtest.ReadFile(t, filepath.Join(dir, "main.go")); got != workspaceFiles["main.go"] {
+				t.Errorf("main.go was modified: %q", got)
+			}
+		})
+	}
+}
+
+func TestEndToEnd_ProviderFailure(t *testing.T) {
+	srv := cmdtest.NewServer(t)
+	dir := initProject(t, srv, "openai")
+
+	srv.On(cmdtest.WorkspaceChangeProposal, cmdtest.Failure{Status: http.StatusBadRequest, Message: "context length exceeded"})
+	err := cmd.Run(context.Background(), "code", "--no-cache")
+	if err == nil || !strings.Contains(err.Error(), "context length exceeded") {
+		t.Fatalf("expected the provider error, got %v", err)
+	}
+	if got := cmdtest.ReadFile(t, filepath.Join(dir, "main.go")); got != workspaceFiles["main.go"] {
+		t.Errorf("main.go was modified: %q", got)
+	}
+}
@@ -2,10 +2,10 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/internal/llmctx"
 	"github.com/vybdev/vyb/config"
 	"github.com/vybdev/vyb/llm"
 	"github.com/vybdev/vyb/workspace/project"
@@ -14,25 +14,32 @@ import (
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initializes a vyb project. Must be executed from the project's root directory.",
-	Run:   Init,
+	RunE:  Init,
+}
+
+func init() {
+	initCmd.Flags().String("provider", "", "LLM provider to configure, skipping the interactive prompt")
 }
 
 // Init is the cobra handler for `vyb init`.
-func Init(_ *cobra.Command, _ []string) {
+func Init(cmd *cobra.Command, _ []string) error {
 	// ---------------------------------------------------------------------
-	// 1. Ask the user which provider should be configured.
+	// 1. Ask the user which provider should be configured, unless given.
 	// ---------------------------------------------------------------------
-	provider := chooseProvider()
+	provider, _ := cmd.Flags().GetString("provider")
+	if provider == "" {
+		provider = chooseProvider()
+	}
 
 	// ---------------------------------------------------------------------
 	// 2. Generate project configuration and update annotations
 	// ---------------------------------------------------------------------
-	if err := project.Create(".", provider); err != nil {
-		fmt.Printf("Error initializing project: %v\n", err)
-		os.Exit(1)
+	if err := project.Create(llmctx.For(cmd, "."), ".", provider); err != nil {
+		return fmt.Errorf("failed to initialize project: %w", err)
 	}
 
 	fmt.Println("Project initialized successfully.")
+	return nil
 }
 
 // chooseProvider interacts with the user to pick a provider.  When the
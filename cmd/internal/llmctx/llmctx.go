@@ -0,0 +1,36 @@
+// Package llmctx sets up the context of the LLM calls made by the vyb
+// commands, so the built-in and the template-based commands share it.
+package llmctx
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm"
+	"github.com/vybdev/vyb/llm/transcript"
+	"github.com/vybdev/vyb/llm/usage"
+)
+
+// For returns the command's context, set up so every LLM call made on its
+// behalf is recorded in the usage ledger of the project at projectRoot,
+// logged when transcripts are enabled and, unless disabled, served from its
+// response cache.
+func For(cmd *cobra.Command, projectRoot string) context.Context {
+	cfg, err := config.Load(projectRoot)
+	if err != nil {
+		cfg = config.Default()
+	}
+	absRoot, err := filepath.Abs(projectRoot)
+	if err != nil {
+		absRoot = projectRoot
+	}
+	ctx := usage.WithLedger(cmd.Context(), usage.NewLedger(absRoot, cfg.Pricing))
+	ctx = usage.WithCommand(ctx, cmd.Name())
+	ctx = transcript.WithLogger(ctx, transcript.ForProject(absRoot, cfg.Logs))
+	if noCache, _ := cmd.Flags().GetBool("no-cache"); !noCache && !cfg.Cache.WithDefaults().Disabled {
+		ctx = llm.WithCache(ctx, llm.NewCache(absRoot, cfg.Cache))
+	}
+	return ctx
+}
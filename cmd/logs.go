@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/transcript"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var logsLimit int
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Inspects the transcripts of the LLM calls made in this project.",
+	Long: `Inspects the transcripts of the LLM calls made in this project.
+
+Transcripts are only written when enabled in .vyb/config.yaml:
+
+  logs:
+    enabled: true
+    dir: .vyb/logs   # default
+    maxFiles: 50     # older transcripts are deleted
+
+API keys are redacted, but transcripts contain the full prompts sent to the
+provider, including project source code.`,
+}
+
+var logsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the most recent transcripts, newest first.",
+	Run:   LogsList,
+}
+
+var logsShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Prints the transcript with the given id.",
+	Args:  cobra.ExactArgs(1),
+	Run:   LogsShow,
+}
+
+func init() {
+	logsListCmd.Flags().IntVarP(&logsLimit, "limit", "n", 20, "number of transcripts to list (0 lists all)")
+	logsCmd.AddCommand(logsListCmd)
+	logsCmd.AddCommand(logsShowCmd)
+}
+
+// logsDir returns the transcript directory of the current project.
+func logsDir() string {
+	distToRoot, err := project.FindDistanceToRoot(".")
+	if err != nil {
+		fmt.Printf("Error locating project root: %v\n", err)
+		os.Exit(1)
+	}
+	cfg, err := config.Load(distToRoot)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	return transcript.Dir(distToRoot, cfg.Logs)
+}
+
+// LogsList is the cobra handler for `vyb logs list`.
+func LogsList(_ *cobra.Command, _ []string) {
+	infos, err := transcript.List(logsDir())
+	if err != nil {
+		fmt.Printf("Error listing transcripts: %v\n", err)
+		os.Exit(1)
+	}
+	if len(infos) == 0 {
+		fmt.Println("No transcripts recorded. Enable them with `logs: {enabled: true}` in .vyb/config.yaml.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tPROVIDER\tSIZE")
+	for i, n := len(infos)-1, 0; i >= 0 && (logsLimit <= 0 || n < logsLimit); i, n = i-1, n+1 {
+		info := infos[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", info.ID, info.Time.Local().Format("2006-01-02 15:04:05"), info.Provider, info.Size)
+	}
+	_ = w.Flush()
+}
+
+// LogsShow is the cobra handler for `vyb logs show`.
+func LogsShow(_ *cobra.Command, args []string) {
+	data, err := transcript.Read(logsDir(), args[0])
+	if err != nil {
+		fmt.Printf("Error reading transcript: %v\n", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(data)
+	fmt.Println()
+}
@@ -1,29 +1,74 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/vybdev/vyb/cmd/template"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "vyb",
 	Short: "vyb is a CLI tool that uses AI to help you iteratively develop applications faster",
+	// Execute reports errors, once, without the usage: most of them are
+	// not about how the command was invoked.
+	SilenceUsage:  true,
+	SilenceErrors: true,
 	Run: func(cmd *cobra.Command, args []string) {
 		// If no subcommand is provided, print usage.
 		fmt.Println(cmd.UsageString())
 	},
 }
 
-// Execute executes the root command.
+// Execute executes the root command. Ctrl-C (or SIGTERM) cancels the
+// command's context, aborting any in-flight LLM request.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := Run(ctx, os.Args[1:]...); err != nil {
+		stop()
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(1)
 	}
 }
 
+// Run executes the root command with the given arguments, as if they were
+// passed on the command line. Flags are reset to their defaults first, so
+// tests can run several commands in the same process.
+func Run(ctx context.Context, args ...string) error {
+	resetFlags(rootCmd)
+	rootCmd.SetArgs(args)
+	return rootCmd.ExecuteContext(ctx)
+}
+
+// resetFlags restores the default value of every flag of c and of its
+// subcommands.
+func resetFlags(c *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if s, ok := f.Value.(pflag.SliceValue); ok {
+			// slices print their default as "[a,b]", and Set appends.
+			var def []string
+			if v := strings.Trim(f.DefValue, "[]"); v != "" {
+				def = strings.Split(v, ",")
+			}
+			_ = s.Replace(def)
+		} else {
+			_ = f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	c.Flags().VisitAll(reset)
+	c.PersistentFlags().VisitAll(reset)
+	for _, sub := range c.Commands() {
+		resetFlags(sub)
+	}
+}
+
 func init() {
 	err := template.Register(rootCmd)
 	if err != nil {
@@ -35,4 +80,9 @@ func init() {
 	rootCmd.AddCommand(updateCmd)
 	rootCmd.AddCommand(removeCmd)
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(usageCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(logsCmd)
+
+	rootCmd.PersistentFlags().Bool("no-cache", false, "send every LLM request, ignoring (and not updating) the response cache")
 }
@@ -0,0 +1,55 @@
+package template
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+// agent lets the model call read-only tools, over as many turns as its
+// budget allows, before replying.
+type agent struct {
+	out   io.Writer
+	tools *toolbox
+	// send asks the provider to continue the conversation in history.
+	send func(history []payload.Message) (*payload.ChatReply, error)
+}
+
+// run sends userMsg and returns the changes the model proposes once done
+// calling tools. When it only answers in prose, the answer is printed and
+// the proposal is empty.
+func (a *agent) run(userMsg string) (*payload.WorkspaceChangeProposal, error) {
+	reply, err := a.reply([]payload.Message{{Role: payload.RoleUser, Content: userMsg}})
+	if err != nil {
+		return nil, err
+	}
+	if reply.Answer != "" {
+		fmt.Fprintf(a.out, "%s\n\n", reply.Answer)
+	}
+	return &payload.WorkspaceChangeProposal{Summary: reply.Summary, Description: reply.Description, Proposals: reply.Proposals}, nil
+}
+
+// reply continues the conversation in history, answering the tool calls of
+// the model until it replies without any. A model that keeps calling tools
+// once told the budget is used up is an error. history is not modified:
+// tool calls and their results are not kept once the model replied.
+func (a *agent) reply(history []payload.Message) (*payload.ChatReply, error) {
+	history = history[:len(history):len(history)]
+	told := false
+	for {
+		reply, err := a.send(history)
+		if err != nil {
+			return nil, err
+		}
+		if len(reply.ToolCalls) == 0 {
+			return reply, nil
+		}
+		if told {
+			return nil, fmt.Errorf("the model kept calling tools after using up its budget of %d calls and %d tokens", a.tools.limits.MaxCalls, a.tools.limits.MaxTokens)
+		}
+		history = append(history, reply.AsMessage(), payload.Message{Role: payload.RoleUser, Content: a.tools.run(reply.ToolCalls)})
+		// the results end with a notice once the budget is used up.
+		told = a.tools.exhausted()
+	}
+}
@@ -0,0 +1,67 @@
+package template
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func TestAgent(t *testing.T) {
+	var sent [][]payload.Message
+	replies := []*payload.ChatReply{
+		{ToolCalls: []payload.ToolCall{{Name: "grep", Path: ".", Pattern: "func Hello"}}},
+		{ToolCalls: []payload.ToolCall{{Name: "read_file", Path: "greet/greet.go"}}},
+		{Answer: "Calls Hello.", Summary: "feat: greet", Description: "Greets.", Proposals: []payload.FileChangeProposal{{FileName: "main.go", Content: "package main"}}},
+	}
+	var out bytes.Buffer
+	a := &agent{
+		out:   &out,
+		tools: newTestToolbox(t, (*config.ToolsConfig)(nil).WithDefaults()),
+		send: func(history []payload.Message) (*payload.ChatReply, error) {
+			sent = append(sent, history)
+			return replies[len(sent)-1], nil
+		},
+	}
+	got, err := a.run("# Request\ncall greet")
+	if err != nil {
+		t.Fatalf("run() = %v", err)
+	}
+	if got.Summary != "feat: greet" || len(got.Proposals) != 1 {
+		t.Errorf("unexpected proposal: %+v", got)
+	}
+	if len(sent) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(sent))
+	}
+	last := sent[2]
+	if len(last) != 5 || last[0].Content != "# Request\ncall greet" || last[1].Role != payload.RoleAssistant || last[3].Role != payload.RoleAssistant {
+		t.Fatalf("unexpected history: %+v", last)
+	}
+	if !strings.Contains(last[2].Content, "greet/greet.go:3: func Hello()") || !strings.Contains(last[4].Content, "### greet/greet.go") {
+		t.Errorf("expected the tool results in the history: %+v", last)
+	}
+	if !strings.Contains(out.String(), "Calls Hello.") {
+		t.Errorf("expected the answer to be printed, got %q", out.String())
+	}
+}
+
+func TestAgent_BudgetUsedUp(t *testing.T) {
+	calls := 0
+	a := &agent{
+		out:   &bytes.Buffer{},
+		tools: newTestToolbox(t, config.ToolsConfig{MaxCalls: 1, MaxTokens: 1000}),
+		send: func(history []payload.Message) (*payload.ChatReply, error) {
+			calls++
+			return &payload.ChatReply{ToolCalls: []payload.ToolCall{{Name: "list_dir", Path: "."}}}, nil
+		},
+	}
+	_, err := a.run("# Request")
+	if err == nil || !strings.Contains(err.Error(), "kept calling tools") {
+		t.Fatalf("expected the loop to end with an error, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the model to be told once that the budget is used up, got %d requests", calls)
+	}
+}
@@ -0,0 +1,240 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+// largestFiles is the number of files listed when a request is over budget.
+const largestFiles = 10
+
+// requestBudget is the number of input tokens a request may use.
+type requestBudget struct {
+	// limit is 0 when neither the model nor the configuration sets one.
+	limit int
+	// reason tells where limit comes from, for error messages.
+	reason     string
+	onOverflow config.BudgetOverflow
+}
+
+// budgetFor returns the budget of the requests of def: the context window
+// of its model, minus its output tokens, capped by the budget configured
+// for the command.
+func budgetFor(cfg *config.Config, def *Definition) requestBudget {
+	b := requestBudget{onOverflow: cfg.Budget.Overflow()}
+	if w := llm.ContextWindow(cfg, def.Model.Family, def.Model.Size); w > 0 {
+		b.limit = w - def.Model.MaxOutputTokens
+		b.reason = fmt.Sprintf("the %d-token context window of the %s/%s model", w, def.Model.Family, def.Model.Size)
+		if def.Model.MaxOutputTokens > 0 {
+			b.reason += fmt.Sprintf(", minus its %d output tokens", def.Model.MaxOutputTokens)
+		}
+	}
+	if n := cfg.Budget.Command(def.Name); n > 0 && (b.limit <= 0 || n < b.limit) {
+		b.limit = n
+		b.reason = fmt.Sprintf("budget.commands.%s", def.Name)
+	}
+	return b
+}
+
+// moduleFiles are the files of a single module, and their size.
+type moduleFiles struct {
+	module *project.Module
+	files  []string
+	tokens int
+}
+
+// fit builds the user message from files with build, and checks that it
+// fits in the budget along with sysMsg. Over budget, it fails with a
+// breakdown of the request, or, with onOverflow: annotate, leaves out the
+// files below the direct submodules of target, largest submodule first,
+// until the request fits: the model then only sees their public context,
+// which is sent for the direct submodules only. It returns the user message
+// and the files it includes.
+func (b requestBudget) fit(rootFS fs.FS, target *project.Module, sysMsg string, files []string, build func([]string) (string, error)) (string, []string, error) {
+	userMsg, err := build(files)
+	if err != nil || b.limit <= 0 {
+		return userMsg, files, err
+	}
+	sysTokens, err := project.TokenCount(sysMsg)
+	if err != nil {
+		return "", nil, err
+	}
+	userTokens, err := project.TokenCount(userMsg)
+	if err != nil {
+		return "", nil, err
+	}
+	if sysTokens+userTokens <= b.limit {
+		return userMsg, files, nil
+	}
+
+	sizes := map[string]int{}
+	for _, f := range files {
+		content, err := fs.ReadFile(rootFS, f)
+		if err != nil {
+			return "", nil, err
+		}
+		if sizes[f], err = project.TokenCount(string(content)); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if b.onOverflow == config.BudgetAnnotate && target != nil {
+		// the sizes of the files are enough to tell when the request may
+		// fit; only then is it rebuilt, and counted again.
+		estimate := sysTokens + userTokens
+		for _, mf := range submoduleFiles(target, files, sizes) {
+			files = without(files, mf.files)
+			estimate -= mf.tokens
+			fmt.Printf("warning: leaving out the %d files of module %s and its submodules (~%d tokens) to fit the request in %s; its public context is sent instead\n", len(mf.files), mf.module.Name, mf.tokens, b.reason)
+			if estimate > b.limit {
+				continue
+			}
+			if userMsg, err = build(files); err != nil {
+				return "", nil, err
+			}
+			if userTokens, err = project.TokenCount(userMsg); err != nil {
+				return "", nil, err
+			}
+			if sysTokens+userTokens <= b.limit {
+				return userMsg, files, nil
+			}
+			estimate = sysTokens + userTokens
+		}
+	}
+	return "", nil, b.overflowError(sysTokens, userTokens, files, sizes)
+}
+
+// guard wraps the send function of a conversation, as held by `vyb chat`
+// and by commands run with --tools, so that every request is checked
+// against the budget: fit only checks the first message, and the history
+// grows with every turn and every tool result. Nothing can be left out of
+// a conversation, so a request over budget fails with its breakdown.
+func (b requestBudget) guard(sysMsg string, send func([]payload.Message) (*payload.ChatReply, error)) func([]payload.Message) (*payload.ChatReply, error) {
+	if b.limit <= 0 {
+		return send
+	}
+	return func(history []payload.Message) (*payload.ChatReply, error) {
+		sysTokens, err := project.TokenCount(sysMsg)
+		if err != nil {
+			return nil, err
+		}
+		sizes := make([]int, len(history))
+		total := sysTokens
+		for i, m := range history {
+			if sizes[i], err = project.TokenCount(m.Content); err != nil {
+				return nil, err
+			}
+			total += sizes[i]
+		}
+		if total > b.limit {
+			return nil, b.historyOverflowError(sysTokens, history, sizes)
+		}
+		return send(history)
+	}
+}
+
+// historyOverflowError breaks a conversation over budget down into the
+// system prompt, the first message, which holds the workspace context, and
+// the messages that followed, largest first.
+func (b requestBudget) historyOverflowError(sysTokens int, history []payload.Message, sizes []int) error {
+	total := sysTokens
+	for _, n := range sizes {
+		total += n
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("the conversation is ~%d tokens, over the %d allowed by %s:\n", total, b.limit, b.reason))
+	sb.WriteString(fmt.Sprintf("  system prompt: %d\n", sysTokens))
+	if len(history) > 0 {
+		sb.WriteString(fmt.Sprintf("  first message: %d\n", sizes[0]))
+	}
+	later := make([]int, 0, len(history))
+	for i := 1; i < len(history); i++ {
+		later = append(later, i)
+	}
+	sort.SliceStable(later, func(i, j int) bool { return sizes[later[i]] > sizes[later[j]] })
+	if len(later) > 0 {
+		sb.WriteString("later messages, largest first:\n")
+	}
+	for n, i := range later {
+		if n == largestFiles {
+			break
+		}
+		sb.WriteString(fmt.Sprintf("  %8d  #%d (%s)\n", sizes[i], i+1, history[i].Role))
+	}
+	sb.WriteString("start a new session, or lower tools.maxTokens to keep tool results smaller")
+	return errors.New(sb.String())
+}
+
+// overflowError breaks a request over budget down into the system prompt,
+// the files and the module annotations, and lists its largest files.
+func (b requestBudget) overflowError(sysTokens, userTokens int, files []string, sizes map[string]int) error {
+	fileTokens := 0
+	for _, f := range files {
+		fileTokens += sizes[f]
+	}
+	largest := append([]string{}, files...)
+	sort.SliceStable(largest, func(i, j int) bool { return sizes[largest[i]] > sizes[largest[j]] })
+	if len(largest) > largestFiles {
+		largest = largest[:largestFiles]
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("the request is ~%d tokens, over the %d allowed by %s:\n", sysTokens+userTokens, b.limit, b.reason))
+	sb.WriteString(fmt.Sprintf("  system prompt: %d\n", sysTokens))
+	sb.WriteString(fmt.Sprintf("  %d files: %d\n", len(files), fileTokens))
+	sb.WriteString(fmt.Sprintf("  module annotations and formatting: %d\n", max(userTokens-fileTokens, 0)))
+	sb.WriteString("largest files:\n")
+	for _, f := range largest {
+		sb.WriteString(fmt.Sprintf("  %8d  %s\n", sizes[f], f))
+	}
+	sb.WriteString("pass a file or run from a smaller module, drop --all, raise the budget, or set budget.onOverflow to annotate")
+	return errors.New(sb.String())
+}
+
+// submoduleFiles groups the files below every direct submodule of target
+// that has a public context, largest submodule first. The files of deeper
+// modules are grouped with the direct submodule that holds them, as only
+// the public context of the direct submodules is sent.
+func submoduleFiles(target *project.Module, files []string, sizes map[string]int) []moduleFiles {
+	var out []moduleFiles
+	for _, child := range target.Modules {
+		if child.Annotation == nil || child.Annotation.PublicContext == "" {
+			continue
+		}
+		mf := moduleFiles{module: child}
+		for _, f := range files {
+			if strings.HasPrefix(f, child.Name+"/") {
+				mf.files = append(mf.files, f)
+				mf.tokens += sizes[f]
+			}
+		}
+		if len(mf.files) > 0 {
+			out = append(out, mf)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].tokens > out[j].tokens })
+	return out
+}
+
+// without returns files minus the files in drop.
+func without(files, drop []string) []string {
+	skip := map[string]bool{}
+	for _, f := range drop {
+		skip[f] = true
+	}
+	var out []string
+	for _, f := range files {
+		if !skip[f] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+package template
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+func TestSubmoduleFiles(t *testing.T) {
+	annotated := &project.Annotation{PublicContext: "public"}
+	target := &project.Module{Name: "app", Modules: []*project.Module{
+		{Name: "app/api", Annotation: annotated, Modules: []*project.Module{{Name: "app/api/v1", Annotation: annotated}}},
+		{Name: "app/db", Annotation: annotated},
+		{Name: "app/raw"},
+	}}
+	files := []string{"app/main.go", "app/api/api.go", "app/api/v1/v1.go", "app/db/db.go", "app/raw/raw.go"}
+	sizes := map[string]int{"app/main.go": 50, "app/api/api.go": 10, "app/api/v1/v1.go": 30, "app/db/db.go": 20, "app/raw/raw.go": 90}
+
+	var got []string
+	for _, mf := range submoduleFiles(target, files, sizes) {
+		got = append(got, mf.module.Name+": "+strings.Join(mf.files, ","))
+	}
+	// the files of app/api/v1 go with app/api, whose public context is
+	// sent; app/raw has none, so its files are kept.
+	want := []string{"app/api: app/api/api.go,app/api/v1/v1.go", "app/db: app/db/db.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("submoduleFiles() = %q, want %q", got, want)
+	}
+}
+
+func TestRequestBudgetGuard(t *testing.T) {
+	calls := 0
+	send := requestBudget{limit: 50, reason: "budget.commands.chat"}.guard("You are helpful.", func([]payload.Message) (*payload.ChatReply, error) {
+		calls++
+		return &payload.ChatReply{Answer: "ok"}, nil
+	})
+
+	history := []payload.Message{{Role: payload.RoleUser, Content: "hi"}}
+	if _, err := send(history); err != nil {
+		t.Fatalf("send() = %v", err)
+	}
+	history = append(history, payload.Message{Role: payload.RoleAssistant, Content: strings.Repeat("word ", 100)})
+	_, err := send(history)
+	if err == nil || !strings.Contains(err.Error(), "over the 50 allowed by budget.commands.chat") {
+		t.Fatalf("expected the conversation to be over budget, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 request to be sent, got %d", calls)
+	}
+}
+
+func TestRequestBudgetGuard_Agent(t *testing.T) {
+	calls := 0
+	b := requestBudget{limit: 200, reason: "the 200-token context window of the reasoning/large model"}
+	a := &agent{
+		out:   &bytes.Buffer{},
+		tools: newTestToolbox(t, (*config.ToolsConfig)(nil).WithDefaults()),
+		send: b.guard("You are helpful.", func([]payload.Message) (*payload.ChatReply, error) {
+			calls++
+			return &payload.ChatReply{ToolCalls: []payload.ToolCall{{Name: "read_file", Path: "greet/greet.go"}, {Name: "read_file", Path: "main.go"}}}, nil
+		}),
+	}
+	_, err := a.run("# Request\ncall greet")
+	if err == nil || !strings.Contains(err.Error(), "over the 200 allowed by the 200-token context window") {
+		t.Fatalf("expected the tool results to push the conversation over budget, got %v", err)
+	}
+	for _, want := range []string{"system prompt:", "first message:", "later messages, largest first:", "(user)", "(assistant)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the breakdown to contain %q:\n%v", want, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected the loop to stop once over budget, got %d requests", calls)
+	}
+}
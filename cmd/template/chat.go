@@ -0,0 +1,202 @@
+package template
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+// chatDefinition drives `vyb chat`. Like `vyb code`, any file of the
+// working module can be passed as a target and modified.
+var chatDefinition = &Definition{
+	Name: "chat",
+	Model: Model{
+		Family: config.ModelFamilyReasoning,
+		Size:   config.ModelSizeLarge,
+	},
+	ArgInclusionPatterns:          []string{"*"},
+	ModificationInclusionPatterns: []string{"*"},
+	Prompt: `You are a software engineer having a conversation with the user about the
+files included in the first user message, and the project context around them.
+
+Every reply either answers the user in prose, or proposes changes to the
+workspace:
+- To answer, set ` + "`answer`" + ` and leave ` + "`proposals`" + ` empty. Ask the user
+  directly when you need clarification, instead of leaving ` + "`TODO(user)`" + ` comments.
+- To propose changes, fill ` + "`proposals`" + `, ` + "`summary`" + ` and ` + "`description`" + `
+  exactly as you would for a single change request, and use ` + "`answer`" + ` to
+  briefly explain them.
+
+The user may ask you to revise a proposal over several turns. Every
+proposal must be complete on its own: include every file the change
+touches, not only the files revised in the latest turn. Edits and diffs
+apply to the files as they are in the workspace, not as an earlier
+proposal left them.`,
+	ShortDescription: "Chat about the working module, and apply the changes proposed along the way",
+	LongDescription: `Starts an interactive conversation with the configured provider, seeded
+with the same context other commands send for the working module. Every
+reply either answers in prose or proposes workspace changes; type /apply
+to apply the latest proposal, or keep chatting to refine it. Type /quit,
+or send EOF, to end the session.`,
+}
+
+const chatHelp = `Commands:
+  /apply  apply the latest proposed changes
+  /quit   end the session
+  /help   show this help`
+
+// chatSession is the state of a `vyb chat` conversation.
+type chatSession struct {
+	in  io.Reader
+	out io.Writer
+	// seed is the workspace context, sent with the first user turn.
+	seed string
+	// send asks the provider to continue the conversation in history.
+	send func(history []payload.Message) (*payload.ChatReply, error)
+	// apply applies a proposal to the workspace.
+	apply func(proposal *payload.WorkspaceChangeProposal) error
+}
+
+// run reads user turns from s.in until /quit or EOF. Errors returned by
+// send and apply are printed and do not end the session.
+func (s *chatSession) run() error {
+	var history []payload.Message
+	var pending *payload.WorkspaceChangeProposal
+
+	fmt.Fprintf(s.out, "\n%s\n", chatHelp)
+	scanner := bufio.NewScanner(s.in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for {
+		fmt.Fprint(s.out, "\n> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(s.out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+			continue
+		case "/quit", "/exit":
+			return nil
+		case "/help":
+			fmt.Fprintln(s.out, chatHelp)
+			continue
+		case "/apply":
+			if pending == nil {
+				fmt.Fprintln(s.out, "There are no proposed changes to apply.")
+				continue
+			}
+			if err := s.apply(pending); err != nil {
+				fmt.Fprintf(s.out, "error: %v\n", err)
+				continue
+			}
+			pending = nil
+			continue
+		}
+
+		content := line
+		// The workspace context opens the conversation, so that every
+		// request shares the same prefix and providers that cache prompts
+		// only bill it in full once.
+		if len(history) == 0 {
+			content = s.seed + "\n# Request\n" + line
+		}
+		history = append(history, payload.Message{Role: payload.RoleUser, Content: content})
+
+		reply, err := s.send(history)
+		if err != nil {
+			// drop the unanswered turn, so the user can simply try again.
+			history = history[:len(history)-1]
+			fmt.Fprintf(s.out, "error: %v\n", err)
+			continue
+		}
+		history = append(history, reply.AsMessage())
+
+		if reply.Answer != "" {
+			fmt.Fprintf(s.out, "\n%s\n", reply.Answer)
+		}
+		if p := reply.Proposal(); p != nil {
+			pending = p
+			fmt.Fprintf(s.out, "\nProposed change: %s\n", p.Summary)
+			for _, file := range p.Proposals {
+				fmt.Fprintf(s.out, "  %s -- delete? %v\n", file.FileName, file.Delete)
+			}
+			fmt.Fprintln(s.out, "Type /apply to apply it, or keep chatting to refine it.")
+		}
+	}
+}
+
+func chat(cmd *cobra.Command, args []string) error {
+	def := chatDefinition
+	req, err := prepareRequest(cmd, args, def)
+	if err != nil {
+		return err
+	}
+
+	s := &chatSession{
+		in:   cmd.InOrStdin(),
+		out:  cmd.OutOrStdout(),
+		seed: req.userMsg,
+		// the seed is budgeted by prepareRequest; the turns that follow
+		// are checked as the history grows.
+		send: budgetFor(req.cfg, def).guard(req.sysMsg, func(history []payload.Message) (*payload.ChatReply, error) {
+			return llm.GetChatReply(req.llmCtx, req.cfg, def.Model.Family, def.Model.Size, def.Model.GenerationParams, req.sysMsg, history)
+		}),
+		apply: func(proposal *payload.WorkspaceChangeProposal) error {
+			return applyProposal(req, def, proposal)
+		},
+	}
+	// With tools, every turn may take several requests; the budget is
+	// shared by the whole session.
+	if req.tools != nil {
+		a := &agent{out: s.out, tools: req.tools, send: s.send}
+		s.send = a.reply
+	} else {
+		s.send = withoutTools(s.out, s.send)
+	}
+	return s.run()
+}
+
+// toolsDisabled answers the tool calls of a model that has no tools.
+const toolsDisabled = "Tools are disabled in this session, so your tool calls were not run. Reply without calling any tool."
+
+// withoutTools wraps send for a session without tools: the chat_reply
+// schema always lets the model call tools, so calls are answered with
+// toolsDisabled, once, and the model is asked to reply again. Like with an
+// agent, the calls and the notice are not kept in history.
+func withoutTools(out io.Writer, send func([]payload.Message) (*payload.ChatReply, error)) func([]payload.Message) (*payload.ChatReply, error) {
+	return func(history []payload.Message) (*payload.ChatReply, error) {
+		reply, err := send(history)
+		if err != nil || len(reply.ToolCalls) == 0 {
+			return reply, err
+		}
+		fmt.Fprintln(out, "The model asked to call tools, which are disabled; pass --tools to enable them.")
+		history = append(history[:len(history):len(history)], reply.AsMessage(), payload.Message{Role: payload.RoleUser, Content: toolsDisabled})
+		if reply, err = send(history); err != nil {
+			return nil, err
+		}
+		if len(reply.ToolCalls) > 0 {
+			return nil, fmt.Errorf("the model kept calling tools, which are disabled; pass --tools to enable them")
+		}
+		return reply, nil
+	}
+}
+
+func newChatCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   chatDefinition.Name,
+		Short: chatDefinition.ShortDescription,
+		Long:  chatDefinition.LongDescription,
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  chat,
+	}
+	cmd.Flags().BoolP("all", "a", false, "include all files, even those in descendant modules")
+	cmd.Flags().Bool("tools", false, "let the model read, list and grep other project files before answering")
+	return cmd
+}
@@ -0,0 +1,97 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func TestChatSession(t *testing.T) {
+	var sent [][]payload.Message
+	replies := []*payload.ChatReply{
+		nil, // the first call fails
+		{Answer: "It parses the config."},
+		{Answer: "Renamed it.", Summary: "refactor: rename Load", Proposals: []payload.FileChangeProposal{{FileName: "a.go", Content: "package a"}}},
+	}
+	var applied []*payload.WorkspaceChangeProposal
+	var out bytes.Buffer
+
+	s := &chatSession{
+		in:   strings.NewReader("/apply\nwhat does Load do?\nwhat does Load do?\n\nrename it\n/apply\n/apply\n/quit\nignored\n"),
+		out:  &out,
+		seed: "# Module: `a`\n",
+		send: func(history []payload.Message) (*payload.ChatReply, error) {
+			sent = append(sent, append([]payload.Message(nil), history...))
+			r := replies[len(sent)-1]
+			if r == nil {
+				return nil, errors.New("boom")
+			}
+			return r, nil
+		},
+		apply: func(p *payload.WorkspaceChangeProposal) error {
+			applied = append(applied, p)
+			return nil
+		},
+	}
+	if err := s.run(); err != nil {
+		t.Fatalf("run() = %v", err)
+	}
+
+	if len(sent) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(sent))
+	}
+	// the failed turn is dropped, so the retry opens the conversation again.
+	if len(sent[1]) != 1 || sent[1][0].Content != "# Module: `a`\n\n# Request\nwhat does Load do?" {
+		t.Errorf("unexpected first turn: %+v", sent[1])
+	}
+	last := sent[2]
+	if len(last) != 3 || last[1].Role != payload.RoleAssistant || last[2].Role != payload.RoleUser || last[2].Content != "rename it" {
+		t.Errorf("unexpected history: %+v", last)
+	}
+	if last[0].Content != sent[1][0].Content {
+		t.Errorf("the seeded turn changed between requests")
+	}
+
+	if len(applied) != 1 || applied[0].Proposals[0].FileName != "a.go" {
+		t.Errorf("expected the proposal to be applied exactly once, got %+v", applied)
+	}
+	for _, want := range []string{"error: boom", "It parses the config.", "refactor: rename Load", "There are no proposed changes to apply."} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestWithoutTools(t *testing.T) {
+	var sent [][]payload.Message
+	replies := []*payload.ChatReply{
+		{ToolCalls: []payload.ToolCall{{Name: "read_file", Path: "a.go"}}},
+		{Answer: "It parses the config."},
+	}
+	var out bytes.Buffer
+	send := withoutTools(&out, func(history []payload.Message) (*payload.ChatReply, error) {
+		sent = append(sent, history)
+		return replies[len(sent)-1], nil
+	})
+
+	history := []payload.Message{{Role: payload.RoleUser, Content: "what does Load do?"}}
+	reply, err := send(history)
+	if err != nil || reply.Answer != "It parses the config." {
+		t.Fatalf("send() = %+v, %v", reply, err)
+	}
+	if len(sent) != 2 || len(sent[1]) != 3 || sent[1][2].Content != toolsDisabled {
+		t.Fatalf("expected the tool calls to be answered with %q, got %+v", toolsDisabled, sent)
+	}
+	if len(history) != 1 || !strings.Contains(out.String(), "--tools") {
+		t.Errorf("expected history to be left alone and the user to be told, got %+v and %q", history, out.String())
+	}
+
+	// a model that keeps calling tools is an error.
+	sent, replies = nil, []*payload.ChatReply{replies[0], replies[0]}
+	if _, err := send(history); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package template
 
 import (
 	"embed"
+	"fmt"
 	"github.com/vybdev/vyb/config"
 	"gopkg.in/yaml.v3"
 	"io/fs"
@@ -48,6 +49,14 @@ func loadConfigs(rootFS fs.FS) []*Definition {
 				// Handle or log error as needed
 				continue
 			}
+			if err := cmdDef.Model.Validate(); err != nil {
+				fmt.Printf("warning: ignoring template %s: %v\n", entry.Name(), err)
+				continue
+			}
+			if err := cmdDef.FileEncoding.Validate(); err != nil {
+				fmt.Printf("warning: ignoring template %s: %v\n", entry.Name(), err)
+				continue
+			}
 
 			cmdDefinitions = append(cmdDefinitions, cmdDef)
 		}
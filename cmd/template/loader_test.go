@@ -2,6 +2,10 @@ package template
 
 import (
 	"testing"
+	"testing/fstest"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
 )
 
 func Test_loadEmbeddedConfigs(t *testing.T) {
@@ -11,3 +15,43 @@ func Test_loadEmbeddedConfigs(t *testing.T) {
 		t.Errorf("loadEmbeddedConfigs() = %v, expected at least one", len(got))
 	}
 }
+
+func Test_loadConfigs_GenerationParams(t *testing.T) {
+	fsys := fstest.MapFS{
+		"brainstorm.vyb": {Data: []byte("name: brainstorm\nmodel:\n  family: gpt\n  size: large\n  temperature: 1.3\n  topP: 0.95\n  maxOutputTokens: 8000\n  seed: 42\n")},
+		"plan.vyb":       {Data: []byte("name: plan\nmodel:\n  reasoningEffort: high\n")},
+		"broken.vyb":     {Data: []byte("name: broken\nmodel:\n  temperature: 3\n")},
+	}
+	defs := toMap(loadConfigs(fsys))
+
+	if _, ok := defs["broken"]; ok {
+		t.Errorf("expected the template with an invalid temperature to be ignored")
+	}
+	b := defs["brainstorm"].Model
+	if b.Family != config.ModelFamilyGPT || b.Temperature == nil || *b.Temperature != 1.3 || *b.TopP != 0.95 || b.MaxOutputTokens != 8000 || *b.Seed != 42 {
+		t.Errorf("unexpected brainstorm model: %+v", b)
+	}
+	p := defs["plan"].Model
+	if p.Family != config.ModelFamilyReasoning || p.ReasoningEffort != config.ReasoningEffortHigh || p.Temperature != nil {
+		t.Errorf("unexpected plan model: %+v", p)
+	}
+}
+
+func Test_loadConfigs_FileEncoding(t *testing.T) {
+	fsys := fstest.MapFS{
+		"docs.vyb":   {Data: []byte("name: docs\nfileEncoding: xml\n")},
+		"code.vyb":   {Data: []byte("name: code\n")},
+		"broken.vyb": {Data: []byte("name: broken\nfileEncoding: html\n")},
+	}
+	defs := toMap(loadConfigs(fsys))
+
+	if _, ok := defs["broken"]; ok {
+		t.Errorf("expected the template with an unknown file encoding to be ignored")
+	}
+	if got := defs["docs"].FileEncoding; got != payload.EncodingXML {
+		t.Errorf("docs encoding = %q, want xml", got)
+	}
+	if got := defs["code"].FileEncoding; got != "" {
+		t.Errorf("code encoding = %q, want the default", got)
+	}
+}
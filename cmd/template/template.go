@@ -1,16 +1,22 @@
 package template
 
 import (
+	stdcontext "context"
 	"fmt"
 	"github.com/vybdev/vyb/config"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/cbroglie/mustache"
 	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/internal/llmctx"
 	"github.com/vybdev/vyb/llm"
 	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/llm/progress"
+	"github.com/vybdev/vyb/llm/usage"
 	"github.com/vybdev/vyb/workspace/context"
 	"github.com/vybdev/vyb/workspace/matcher"
 	"github.com/vybdev/vyb/workspace/project"
@@ -29,6 +35,9 @@ var systemExclusionPatterns = []string{
 type Model struct {
 	Family config.ModelFamily `yaml:"family"`
 	Size   config.ModelSize   `yaml:"size"`
+	// GenerationParams (temperature, topP, reasoningEffort, maxOutputTokens
+	// and seed) are declared next to family and size.
+	config.GenerationParams `yaml:",inline"`
 }
 
 type Definition struct {
@@ -51,6 +60,9 @@ type Definition struct {
 	// ModificationInclusionPatterns specifies patterns for files that could be modified when executing this command.
 	ModificationInclusionPatterns []string `yaml:"modificationInclusionPatterns"`
 
+	// FileEncoding selects how file contents are delimited in the request payload: fenced Markdown code blocks (the default) or XML tags.
+	FileEncoding payload.FileEncoding `yaml:"fileEncoding"`
+
 	// Prompt specifies the command-specific user prompt that should be included in the LLM request
 	Prompt string `yaml:"prompt"`
 	// TargetSpecificPrompt specifies additional instructions to be included in the user prompt, if a target is provided.
@@ -98,9 +110,25 @@ func prepareExecutionContext(target *string) (*context.ExecutionContext, error)
 	return ec, nil
 }
 
-func execute(cmd *cobra.Command, args []string, def *Definition) error {
+// request holds everything needed to send a command's request to the LLM
+// and to apply its answer.
+type request struct {
+	ec      *context.ExecutionContext
+	rootFS  fs.FS
+	cfg     *config.Config
+	llmCtx  stdcontext.Context
+	sysMsg  string
+	userMsg string
+	// tools is set when the model may call read-only tools before
+	// answering, with --tools or `tools.enabled` in .vyb/config.yaml.
+	tools *toolbox
+}
+
+// prepareRequest selects the files in scope for def, and renders the system
+// and user messages describing them.
+func prepareRequest(cmd *cobra.Command, args []string, def *Definition) (*request, error) {
 	if len(def.ArgInclusionPatterns) == 0 && len(args) > 0 {
-		return fmt.Errorf("command \"%s\" expects no arguments, but got %v", cmd.Use, args)
+		return nil, fmt.Errorf("command \"%s\" expects no arguments, but got %v", cmd.Use, args)
 	}
 
 	// ---------------------------
@@ -115,7 +143,7 @@ func execute(cmd *cobra.Command, args []string, def *Definition) error {
 
 	ec, err := prepareExecutionContext(target)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	absRoot := ec.ProjectRoot
@@ -132,18 +160,18 @@ func execute(cmd *cobra.Command, args []string, def *Definition) error {
 
 	cfg, err := config.Load(absRoot)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if relTarget != nil {
 		if !matcher.IsIncluded(rootFS, *relTarget, append(systemExclusionPatterns, def.ArgExclusionPatterns...), def.ArgInclusionPatterns) {
-			return fmt.Errorf("command \"%s\" does not support given target %s", cmd.Use, *relTarget)
+			return nil, fmt.Errorf("command \"%s\" does not support given target %s", cmd.Use, *relTarget)
 		}
 	}
 
 	files, err := selector.Select(rootFS, ec, append(systemExclusionPatterns, def.ArgExclusionPatterns...), def.ArgInclusionPatterns)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// ------------------------------------------------------------
@@ -154,31 +182,44 @@ func execute(cmd *cobra.Command, args []string, def *Definition) error {
 	// ------------------------------------------------------------
 	storedMeta, err := project.LoadMetadata(absRoot)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	freshMeta, err := project.BuildMetadataFS(rootFS)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Validate that the module name sets are identical.
 	if !equalModuleNameSets(storedMeta.Modules, freshMeta.Modules) {
-		return fmt.Errorf("module hierarchy mismatch between stored metadata and filesystem snapshot – please run 'vyb update' first")
+		return nil, fmt.Errorf("module hierarchy mismatch between stored metadata and filesystem snapshot – please run 'vyb update' first")
 	}
 
 	// Merge – keep annotations from storedMeta, replace structure from freshMeta.
 	storedMeta.Patch(freshMeta)
 	meta := storedMeta
 
+	// Every LLM call is recorded in the usage ledger, labelled with the
+	// command and the target module, logged when transcripts are enabled,
+	// and served from the response cache unless --no-cache is set. Its
+	// response is streamed, with its progress reported on stderr.
+	llmCtx := progress.WithWriter(llmctx.For(cmd, absRoot), cmd.ErrOrStderr())
+
+	relTargetDir, _ := filepath.Rel(absRoot, ec.TargetDir)
+	relTargetDir = filepath.ToSlash(relTargetDir)
+	var targetModule *project.Module
+	if meta.Modules != nil {
+		targetModule = project.FindModule(meta.Modules, relTargetDir)
+	}
+	if targetModule != nil {
+		llmCtx = usage.WithModule(llmCtx, targetModule.Name)
+	}
+
 	// ------------------------------------------------------------
 	// Unless --all is provided, filter out files that belong to
 	// descendant modules of the target module (i.e. keep only files
 	// whose module == targetModule).
 	// ------------------------------------------------------------
-	if !includeAll && meta.Modules != nil {
-		relTargetDir, _ := filepath.Rel(absRoot, ec.TargetDir)
-		relTargetDir = filepath.ToSlash(relTargetDir)
-		targetModule := project.FindModule(meta.Modules, relTargetDir)
+	if !includeAll {
 		if targetModule != nil {
 			var filtered []string
 			for _, f := range files {
@@ -190,6 +231,35 @@ func execute(cmd *cobra.Command, args []string, def *Definition) error {
 		}
 	}
 
+	promptGeneralInstructions, _ := embedded.ReadFile("embedded/prompts/instructions.md.mustache")
+	tmpl, err := mustache.ParseString(string(promptGeneralInstructions))
+	if err != nil {
+		return nil, err
+	}
+
+	var tools *toolbox
+	limits := cfg.Tools.WithDefaults()
+	if enabled, _ := cmd.Flags().GetBool("tools"); enabled || limits.Enabled {
+		tools, err = newToolbox(rootFS, def, limits, cmd.OutOrStdout())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rendered, err := tmpl.Render(def, map[string]bool{"Tools": tools != nil})
+	if err != nil {
+		return nil, err
+	}
+
+	// The request is checked against the context window of the model
+	// before it is sent, instead of being rejected by the provider.
+	userMsg, files, err := budgetFor(cfg, def).fit(rootFS, targetModule, rendered, files, func(files []string) (string, error) {
+		return buildExtendedUserMessage(rootFS, meta, ec, files, def.FileEncoding)
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	fmt.Printf("The following files will be included in the request:\n")
 	for _, file := range files {
 		if relTarget != nil && file == *relTarget {
@@ -199,29 +269,62 @@ func execute(cmd *cobra.Command, args []string, def *Definition) error {
 		}
 	}
 
-	userMsg, err := buildExtendedUserMessage(rootFS, meta, ec, files)
+	return &request{
+		ec:      ec,
+		rootFS:  rootFS,
+		cfg:     cfg,
+		llmCtx:  llmCtx,
+		sysMsg:  rendered,
+		userMsg: userMsg,
+		tools:   tools,
+	}, nil
+}
+
+func execute(cmd *cobra.Command, args []string, def *Definition) error {
+	req, err := prepareRequest(cmd, args, def)
 	if err != nil {
 		return err
 	}
 
-	promptGeneralInstructions, _ := embedded.ReadFile("embedded/prompts/instructions.md.mustache")
-	tmpl, err := mustache.ParseString(string(promptGeneralInstructions))
+	var proposal *payload.WorkspaceChangeProposal
+	if req.tools != nil {
+		// The tool calls are carried by chat replies, so the model can
+		// alternate between calling tools and proposing changes.
+		a := &agent{
+			out:   cmd.OutOrStdout(),
+			tools: req.tools,
+			// tool results grow the history past what fit checked.
+			send: budgetFor(req.cfg, def).guard(req.sysMsg, func(history []payload.Message) (*payload.ChatReply, error) {
+				return llm.GetChatReply(req.llmCtx, req.cfg, def.Model.Family, def.Model.Size, def.Model.GenerationParams, req.sysMsg, history)
+			}),
+		}
+		proposal, err = a.run(req.userMsg)
+	} else {
+		proposal, err = llm.GetWorkspaceChangeProposals(req.llmCtx, req.cfg, def.Model.Family, def.Model.Size, def.Model.GenerationParams, req.sysMsg, req.userMsg)
+	}
 	if err != nil {
 		return err
 	}
 
-	rendered, err := tmpl.Render(def)
-	if err != nil {
+	if err := applyProposal(req, def, proposal); err != nil {
 		return err
 	}
 
-	systemMessage := rendered
-
-	proposal, err := llm.GetWorkspaceChangeProposals(cfg, def.Model.Family, def.Model.Size, systemMessage, userMsg)
-	if err != nil {
-		return err
+	fmt.Printf("Change summary: %s\n\n", proposal.Summary)
+	fmt.Printf("Change description: %s\n\n", proposal.Description)
+	fmt.Printf("Changed files: \n")
+	for _, file := range proposal.Proposals {
+		fmt.Printf("  %s -- delete? %v\n", file.FileName, file.Delete)
 	}
 
+	return nil
+}
+
+// applyProposal checks that every file in proposal may be modified by def,
+// and applies the changes.
+func applyProposal(req *request, def *Definition, proposal *payload.WorkspaceChangeProposal) error {
+	absRoot := req.ec.ProjectRoot
+
 	// --------------------------------------------------------
 	// Validate that every file in the proposal is allowed to be modified.
 	// --------------------------------------------------------
@@ -239,13 +342,13 @@ func execute(cmd *cobra.Command, args []string, def *Definition) error {
 
 	for _, prop := range proposal.Proposals {
 		// 1. Pattern based validation (existing behaviour).
-		if !matcher.IsIncluded(rootFS, prop.FileName, append(systemExclusionPatterns, def.ModificationExclusionPatterns...), def.ModificationInclusionPatterns) {
+		if !matcher.IsIncluded(req.rootFS, prop.FileName, append(systemExclusionPatterns, def.ModificationExclusionPatterns...), def.ModificationInclusionPatterns) {
 			invalidFiles = append(invalidFiles, prop.FileName)
 			continue
 		}
 		// 2. Must reside within the working_dir using absolute paths.
 		absProp := filepath.Join(absRoot, prop.FileName)
-		if !isWithinDir(ec.WorkingDir, absProp) {
+		if !isWithinDir(req.ec.WorkingDir, absProp) {
 			invalidFiles = append(invalidFiles, prop.FileName+" (outside working_dir)")
 		}
 	}
@@ -254,38 +357,64 @@ func execute(cmd *cobra.Command, args []string, def *Definition) error {
 		return fmt.Errorf("change proposal contains modifications to unallowed files: %v", invalidFiles)
 	}
 
-	if err := applyProposals(absRoot, proposal.Proposals); err != nil {
-		return err
-	}
-
-	fmt.Printf("Change summary: %s\n\n", proposal.Summary)
-	fmt.Printf("Change description: %s\n\n", proposal.Description)
-	fmt.Printf("Changed files: \n")
-	for _, file := range proposal.Proposals {
-		fmt.Printf("  %s -- delete? %v\n", file.FileName, file.Delete)
-	}
-
-	return nil
+	return applyProposals(absRoot, proposal.Proposals)
 }
 
 // applyProposals applies all file modifications as proposed by the LLM.
+// Proposals are applied in order, in memory, before anything is written:
+// several proposals for the same file build on one another, and a hunk
+// that does not apply leaves the workspace untouched.
 func applyProposals(absRoot string, proposals []payload.FileChangeProposal) error {
+	// files holds the state of every proposed file, in the order they are
+	// first proposed.
+	type file struct {
+		name    string
+		content string
+		deleted bool
+	}
+	var files []*file
+	byName := map[string]*file{}
 	for _, prop := range proposals {
-		absPath := filepath.Join(absRoot, prop.FileName)
-		if prop.Delete {
+		key := path.Clean(prop.FileName)
+		f := byName[key]
+		if f == nil {
+			f = &file{name: prop.FileName}
+			if prop.IsPatch() {
+				b, err := os.ReadFile(filepath.Join(absRoot, prop.FileName))
+				if err != nil {
+					return fmt.Errorf("cannot edit %s: %w", prop.FileName, err)
+				}
+				f.content = string(b)
+			}
+			byName[key] = f
+			files = append(files, f)
+		}
+		if prop.IsPatch() && f.deleted {
+			return fmt.Errorf("change proposal does not apply: %s: the file is deleted by an earlier proposal", prop.FileName)
+		}
+		content, err := prop.Apply(f.content)
+		if err != nil {
+			return fmt.Errorf("change proposal does not apply: %w", err)
+		}
+		f.content, f.deleted = content, prop.Delete
+	}
+
+	for _, f := range files {
+		absPath := filepath.Join(absRoot, f.name)
+		if f.deleted {
 			if err := os.Remove(absPath); err != nil && !os.IsNotExist(err) {
 				return fmt.Errorf("failed to delete file %s: %w", absPath, err)
 			}
-			fmt.Printf("Deleted file: %s\n", prop.FileName)
+			fmt.Printf("Deleted file: %s\n", f.name)
 		} else {
 			dir := filepath.Dir(absPath)
 			if err := os.MkdirAll(dir, 0755); err != nil {
 				return fmt.Errorf("failed to create directory %s: %w", dir, err)
 			}
-			if err := os.WriteFile(absPath, []byte(prop.Content), 0644); err != nil {
+			if err := os.WriteFile(absPath, []byte(f.content), 0644); err != nil {
 				return fmt.Errorf("failed to write to file %s: %w", absPath, err)
 			}
-			fmt.Printf("Modified file: %s\n", prop.FileName)
+			fmt.Printf("Modified file: %s\n", f.name)
 		}
 	}
 	return nil
@@ -304,8 +433,10 @@ func Register(rootCmd *cobra.Command) error {
 			},
 		}
 		cmd.Flags().BoolP("all", "a", false, "include all files, even those in descendant modules")
+		cmd.Flags().Bool("tools", false, "let the model read, list and grep other project files before answering")
 		rootCmd.AddCommand(cmd)
 	}
+	rootCmd.AddCommand(newChatCmd())
 	return nil
 }
 
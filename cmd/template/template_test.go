@@ -0,0 +1,55 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func TestApplyProposals_SameFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := applyProposals(dir, []payload.FileChangeProposal{
+		{FileName: "main.go", Edits: []payload.Edit{{Search: "func main() {}", Replace: "func main() { run() }"}}},
+		{FileName: "./main.go", Edits: []payload.Edit{{Search: "run()", Replace: "run(os.Args)"}}},
+		{FileName: "run.go", Content: "package main\n"},
+		{FileName: "run.go", Edits: []payload.Edit{{Search: "package main\n", Replace: "package main\n\nfunc run(args []string) {}\n"}}},
+	})
+	if err != nil {
+		t.Fatalf("applyProposals() = %v", err)
+	}
+	for name, want := range map[string]string{
+		"main.go": "package main\n\nfunc main() { run(os.Args) }\n",
+		"run.go":  "package main\n\nfunc run(args []string) {}\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestApplyProposals_EditDeletedFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := applyProposals(dir, []payload.FileChangeProposal{
+		{FileName: "main.go", Delete: true},
+		{FileName: "main.go", Edits: []payload.Edit{{Search: "main", Replace: "app"}}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "deleted by an earlier proposal") {
+		t.Fatalf("applyProposals() = %v, want an error about the deleted file", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main.go")); err != nil {
+		t.Errorf("main.go was touched: %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package template
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/workspace/context"
+	"github.com/vybdev/vyb/workspace/selector"
+)
+
+// Names of the read-only tools the model may call.
+const (
+	toolReadFile = "read_file"
+	toolListDir  = "list_dir"
+	toolGrep     = "grep"
+)
+
+// toolbox runs the tool calls of the model over the project root. Only the
+// files a request of the command could include can be read, listed or
+// searched: those selector.Select returns for the whole project with the
+// patterns prepareRequest selects files with, minus the command's request
+// exclusion patterns.
+//
+// Every call counts against the configured budget: once MaxCalls calls
+// were run, or their results reach MaxTokens, further calls are refused.
+type toolbox struct {
+	rootFS fs.FS
+	// files is the sorted list of the files the tools may see.
+	files  []string
+	limits config.ToolsConfig
+	// enc delimits the files read_file returns, as in the request.
+	enc payload.FileEncoding
+	// log receives one line per call.
+	log io.Writer
+
+	calls  int
+	tokens int
+}
+
+// newToolbox returns a toolbox over the files of rootFS that def may send
+// in a request.
+func newToolbox(rootFS fs.FS, def *Definition, limits config.ToolsConfig, log io.Writer) (*toolbox, error) {
+	// The whole project is in scope, whatever the working directory.
+	ec := &context.ExecutionContext{ProjectRoot: ".", WorkingDir: ".", TargetDir: "."}
+	exclusions := append(append(append([]string{}, systemExclusionPatterns...), def.ArgExclusionPatterns...), def.RequestExclusionPatterns...)
+	files, err := selector.Select(rootFS, ec, exclusions, def.ArgInclusionPatterns)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return &toolbox{rootFS: rootFS, files: files, limits: limits, enc: def.FileEncoding, log: log}, nil
+}
+
+// exhausted reports whether the budget allows no further call.
+func (t *toolbox) exhausted() bool {
+	return t.calls >= t.limits.MaxCalls || t.tokens >= t.limits.MaxTokens
+}
+
+// run runs calls and renders their results as the next user message.
+func (t *toolbox) run(calls []payload.ToolCall) string {
+	var sb strings.Builder
+	sb.WriteString("# Tool Results\n")
+	for _, call := range calls {
+		sb.WriteString(fmt.Sprintf("## %s\n", describeCall(call)))
+		if t.exhausted() {
+			sb.WriteString("Not run: the tool budget is used up.\n\n")
+			continue
+		}
+		t.calls++
+
+		result, err := t.call(call)
+		if err != nil {
+			result = "Error: " + err.Error() + "\n"
+		}
+		// Results are cut to what is left of the token budget.
+		if left := (t.limits.MaxTokens - t.tokens) * 4; len(result) > left {
+			// the cut must not split a multi-byte rune.
+			for left > 0 && !utf8.RuneStart(result[left]) {
+				left--
+			}
+			result = result[:left] + "\n[truncated: the tool budget is used up]\n"
+		}
+		tokens := (len(result) + 3) / 4
+		t.tokens += tokens
+		fmt.Fprintf(t.log, "tool call %d/%d: %s (%d tokens)\n", t.calls, t.limits.MaxCalls, describeCall(call), tokens)
+
+		sb.WriteString(result)
+		sb.WriteString("\n")
+	}
+	if t.exhausted() {
+		sb.WriteString("The tool budget is used up: reply now, without calling tools.\n")
+	}
+	return sb.String()
+}
+
+func describeCall(call payload.ToolCall) string {
+	if call.Name == toolGrep {
+		return fmt.Sprintf("%s `%s` in `%s`", call.Name, call.Pattern, call.Path)
+	}
+	return fmt.Sprintf("%s `%s`", call.Name, call.Path)
+}
+
+// call runs a single tool call, returning its Markdown result.
+func (t *toolbox) call(call payload.ToolCall) (string, error) {
+	p := path.Clean(strings.TrimPrefix(call.Path, "/"))
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("%s is outside the project", call.Path)
+	}
+	switch call.Name {
+	case toolReadFile:
+		return t.readFile(p)
+	case toolListDir:
+		return t.listDir(p)
+	case toolGrep:
+		return t.grep(p, call.Pattern)
+	}
+	return "", fmt.Errorf("unknown tool %q", call.Name)
+}
+
+func (t *toolbox) readFile(p string) (string, error) {
+	i := sort.SearchStrings(t.files, p)
+	if i == len(t.files) || t.files[i] != p {
+		return "", fmt.Errorf("%s does not exist or is excluded", p)
+	}
+	return payload.BuildUserMessage(t.rootFS, []string{p}, t.enc)
+}
+
+// under returns the files in dir, at any depth, or the file named dir.
+func (t *toolbox) under(dir string) []string {
+	if dir == "." {
+		return t.files
+	}
+	var out []string
+	for _, f := range t.files {
+		if f == dir || strings.HasPrefix(f, dir+"/") {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (t *toolbox) listDir(dir string) (string, error) {
+	seen := map[string]bool{}
+	var entries []string
+	for _, f := range t.under(dir) {
+		rest := f
+		if dir != "." {
+			rest = strings.TrimPrefix(f, dir+"/")
+		}
+		if rest == f && dir != "." {
+			return "", fmt.Errorf("%s is a file", dir)
+		}
+		entry := rest
+		if i := strings.Index(rest, "/"); i >= 0 {
+			entry = rest[:i+1]
+		}
+		if !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("%s does not exist or is excluded", dir)
+	}
+	return "```\n" + strings.Join(entries, "\n") + "\n```\n", nil
+}
+
+// maxGrepLine is the length past which grep does not search a line.
+const maxGrepLine = 1024 * 1024
+
+func (t *toolbox) grep(dir, pattern string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, f := range t.under(dir) {
+		if err := grepFile(t.rootFS, f, re, &sb); err != nil {
+			return "", err
+		}
+	}
+	if sb.Len() == 0 {
+		return "No matches.\n", nil
+	}
+	return "```\n" + sb.String() + "```\n", nil
+}
+
+// grepFile writes the lines of f matching re to sb. Lines longer than
+// maxGrepLine are not searched, and reported as such, so that the rest of
+// the file still is.
+func grepFile(rootFS fs.FS, f string, re *regexp.Regexp, sb *strings.Builder) error {
+	file, err := rootFS.Open(f)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	for n := 1; ; n++ {
+		line, long, err := readLine(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", f, n, err)
+		}
+		if long {
+			sb.WriteString(fmt.Sprintf("%s:%d: [not searched: the line is longer than %d bytes]\n", f, n, maxGrepLine))
+		} else if re.MatchString(line) {
+			sb.WriteString(fmt.Sprintf("%s:%d: %s\n", f, n, line))
+		}
+	}
+}
+
+// readLine reads the next line of r, without its line ending. Past
+// maxGrepLine bytes, the rest of the line is skipped and long is true. err
+// is io.EOF once r has no more lines.
+func readLine(r *bufio.Reader) (line string, long bool, err error) {
+	var sb strings.Builder
+	read := false
+	for {
+		chunk, more, err := r.ReadLine()
+		if err != nil {
+			if err == io.EOF && read {
+				err = nil
+			}
+			return sb.String(), long, err
+		}
+		read = true
+		if !long && sb.Len()+len(chunk) > maxGrepLine {
+			long = true
+			sb.Reset()
+		}
+		if !long {
+			sb.Write(chunk)
+		}
+		if !more {
+			return sb.String(), long, nil
+		}
+	}
+}
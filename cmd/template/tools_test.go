@@ -0,0 +1,167 @@
+package template
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"unicode/utf8"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func newTestToolbox(t *testing.T, limits config.ToolsConfig) *toolbox {
+	t.Helper()
+	rootFS := fstest.MapFS{
+		".gitignore":           {Data: []byte("*.log\n")},
+		"go.sum":               {Data: []byte("example.com/dep v1.0.0 h1:checksum\n")},
+		"main.go":              {Data: []byte("package main\n\nfunc main() { greet.Hello() }\n")},
+		"debug.log":            {Data: []byte("func Hello() in a log\n")},
+		"greet/greet.go":       {Data: []byte("package greet\n\nfunc Hello() string { return \"hi\" }\n")},
+		"greet/internal/x.go":  {Data: []byte("package internal\n")},
+		"greet/testdata/a.txt": {Data: []byte("secret\n")},
+		"drafts/plan.txt":      {Data: []byte("func Hello() in a draft\n")},
+		"greet.svg":            {Data: []byte("<svg/>\n")},
+	}
+	// like in a request, drafts/ can not be selected, and only the files
+	// matching the inclusion patterns are.
+	def := &Definition{
+		ArgInclusionPatterns:     []string{"*.go", "*.txt", "*.sum"},
+		ArgExclusionPatterns:     []string{"drafts/"},
+		RequestExclusionPatterns: []string{"greet/testdata/"},
+	}
+	tb, err := newToolbox(rootFS, def, limits, io.Discard)
+	if err != nil {
+		t.Fatalf("newToolbox() = %v", err)
+	}
+	return tb
+}
+
+func TestToolbox(t *testing.T) {
+	tb := newTestToolbox(t, (*config.ToolsConfig)(nil).WithDefaults())
+
+	tests := []struct {
+		call    payload.ToolCall
+		want    []string
+		wantNot []string
+	}{
+		{
+			call: payload.ToolCall{Name: "read_file", Path: "greet/greet.go"},
+			want: []string{"### greet/greet.go\n```go\n", "func Hello() string"},
+		},
+		{
+			call: payload.ToolCall{Name: "read_file", Path: "./greet/../go.sum"},
+			want: []string{"Error: go.sum does not exist or is excluded"},
+		},
+		{
+			call: payload.ToolCall{Name: "read_file", Path: "../etc/passwd"},
+			want: []string{"Error: ../etc/passwd is outside the project"},
+		},
+		{
+			call:    payload.ToolCall{Name: "list_dir", Path: "."},
+			want:    []string{"greet/\nmain.go\n"},
+			wantNot: []string{"debug.log", ".gitignore", "drafts", "greet.svg"},
+		},
+		{
+			call:    payload.ToolCall{Name: "list_dir", Path: "greet"},
+			want:    []string{"greet.go\ninternal/\n"},
+			wantNot: []string{"testdata"},
+		},
+		{
+			call: payload.ToolCall{Name: "list_dir", Path: "main.go"},
+			want: []string{"Error: main.go is a file"},
+		},
+		{
+			call:    payload.ToolCall{Name: "grep", Path: ".", Pattern: `func \w+\(\)`},
+			want:    []string{"greet/greet.go:3: func Hello() string", "main.go:3: func main()"},
+			wantNot: []string{"debug.log", "drafts"},
+		},
+		{
+			call: payload.ToolCall{Name: "grep", Path: "greet", Pattern: "main"},
+			want: []string{"No matches."},
+		},
+		{
+			call: payload.ToolCall{Name: "grep", Path: ".", Pattern: "("},
+			want: []string{"Error: error parsing regexp"},
+		},
+		{
+			call: payload.ToolCall{Name: "write_file", Path: "main.go"},
+			want: []string{`Error: unknown tool "write_file"`},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(describeCall(tc.call), func(t *testing.T) {
+			got := tb.run([]payload.ToolCall{tc.call})
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("result lacks %q:\n%s", want, got)
+				}
+			}
+			for _, unwanted := range tc.wantNot {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("result includes %q:\n%s", unwanted, got)
+				}
+			}
+		})
+	}
+}
+
+func TestToolbox_GrepLongLine(t *testing.T) {
+	long := strings.Repeat("x", maxGrepLine+1)
+	rootFS := fstest.MapFS{
+		"min.js": {Data: []byte("var a = 1\n" + long + "\nfunction hello() {}\n" + long)},
+	}
+	tb, err := newToolbox(rootFS, &Definition{ArgInclusionPatterns: []string{"*"}}, (*config.ToolsConfig)(nil).WithDefaults(), io.Discard)
+	if err != nil {
+		t.Fatalf("newToolbox() = %v", err)
+	}
+	got, err := tb.grep(".", "hello|x{10}")
+	if err != nil {
+		t.Fatalf("grep() = %v", err)
+	}
+	for _, want := range []string{"min.js:2: [not searched", "min.js:3: function hello() {}", "min.js:4: [not searched"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("result lacks %q:\n%.300s", want, got)
+		}
+	}
+}
+
+func TestToolbox_Budget(t *testing.T) {
+	t.Run("calls", func(t *testing.T) {
+		tb := newTestToolbox(t, config.ToolsConfig{MaxCalls: 2, MaxTokens: 1000})
+		call := payload.ToolCall{Name: "read_file", Path: "main.go"}
+		got := tb.run([]payload.ToolCall{call, call, call})
+		if n := strings.Count(got, "greet.Hello()"); n != 2 {
+			t.Errorf("expected 2 calls to run, got %d:\n%s", n, got)
+		}
+		if !strings.Contains(got, "Not run: the tool budget is used up.") || !strings.HasSuffix(got, "reply now, without calling tools.\n") {
+			t.Errorf("expected the third call to be refused:\n%s", got)
+		}
+		if !tb.exhausted() {
+			t.Errorf("expected the budget to be used up")
+		}
+	})
+	t.Run("tokens", func(t *testing.T) {
+		tb := newTestToolbox(t, config.ToolsConfig{MaxCalls: 10, MaxTokens: 5})
+		got := tb.run([]payload.ToolCall{{Name: "read_file", Path: "greet/greet.go"}})
+		if strings.Contains(got, "func Hello()") || !strings.Contains(got, "[truncated: the tool budget is used up]") {
+			t.Errorf("expected the result to be truncated:\n%s", got)
+		}
+		if !tb.exhausted() {
+			t.Errorf("expected the budget to be used up")
+		}
+	})
+	t.Run("runes", func(t *testing.T) {
+		rootFS := fstest.MapFS{"hello.txt": {Data: []byte(strings.Repeat("héllo ", 20))}}
+		for maxTokens := 1; maxTokens < 12; maxTokens++ {
+			tb, err := newToolbox(rootFS, &Definition{ArgInclusionPatterns: []string{"*"}}, config.ToolsConfig{MaxCalls: 1, MaxTokens: maxTokens}, io.Discard)
+			if err != nil {
+				t.Fatalf("newToolbox() = %v", err)
+			}
+			if got := tb.run([]payload.ToolCall{{Name: "read_file", Path: "hello.txt"}}); !utf8.ValidString(got) {
+				t.Errorf("truncating to %d tokens split a rune:\n%q", maxTokens, got)
+			}
+		}
+	})
+}
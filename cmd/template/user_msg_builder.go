@@ -16,11 +16,11 @@ import (
 // by the specification — before the raw file contents. When metadata is
 // nil or when any contextual information is missing the function falls
 // back gracefully, emitting only what is available.
-func buildExtendedUserMessage(rootFS fs.FS, meta *project.Metadata, ec *context.ExecutionContext, filePaths []string) (string, error) {
+func buildExtendedUserMessage(rootFS fs.FS, meta *project.Metadata, ec *context.ExecutionContext, filePaths []string, enc payload.FileEncoding) (string, error) {
 	// If metadata is missing we revert to the original behaviour – emit
 	// just the files.
 	if meta == nil || meta.Modules == nil {
-		return payload.BuildUserMessage(rootFS, filePaths)
+		return payload.BuildUserMessage(rootFS, filePaths, enc)
 	}
 
 	// Helper to clean/normalise relative paths.
@@ -107,7 +107,7 @@ func buildExtendedUserMessage(rootFS fs.FS, meta *project.Metadata, ec *context.
 	// 5. Append file contents (only files from target module were
 	//    selected by selector.Select).
 	// ------------------------------------------------------------
-	filesMsg, err := payload.BuildUserMessage(rootFS, filePaths)
+	filesMsg, err := payload.BuildUserMessage(rootFS, filePaths, enc)
 	if err != nil {
 		return "", err
 	}
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"testing/fstest"
 
+	"github.com/vybdev/vyb/llm/payload"
 	"github.com/vybdev/vyb/workspace/context"
 	"github.com/vybdev/vyb/workspace/project"
 )
@@ -49,7 +50,7 @@ func Test_buildExtendedUserMessage(t *testing.T) {
 		TargetDir:   "w/mid/child",
 	}
 
-	msg, err := buildExtendedUserMessage(mfs, meta, ec, []string{"w/mid/child/file.txt"})
+	msg, err := buildExtendedUserMessage(mfs, meta, ec, []string{"w/mid/child/file.txt"}, payload.EncodingMarkdown)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
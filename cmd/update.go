@@ -2,24 +2,24 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/cmd/internal/llmctx"
 	"github.com/vybdev/vyb/workspace/project"
 )
 
 var updateCmd = &cobra.Command{
 	Use:   "update",
 	Short: "Updates the vyb project metadata.",
-	Run:   Update,
+	RunE:  Update,
 }
 
-func Update(_ *cobra.Command, _ []string) {
+func Update(cmd *cobra.Command, _ []string) error {
 	// for now, `vyb update` only works when executed on the root of the project
-	err := project.Update(".")
+	err := project.Update(llmctx.For(cmd, "."), ".")
 	if err != nil {
-		fmt.Printf("Error creating metadata: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to update metadata: %w", err)
 	}
 	fmt.Println("Project metadata updated successfully.")
+	return nil
 }
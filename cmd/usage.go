@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/vybdev/vyb/llm/usage"
+	"github.com/vybdev/vyb/workspace/project"
+)
+
+var usageBy []string
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Summarizes the tokens consumed by vyb in this project, and their estimated cost.",
+	Long: `Summarizes the LLM usage recorded in .vyb/usage.jsonl.
+
+Every LLM call made by vyb is recorded along with the command and module it
+was made for. Costs are estimated from a built-in price table that can be
+extended through the "pricing" section of .vyb/config.yaml.`,
+	Run: Usage,
+}
+
+func init() {
+	usageCmd.Flags().StringSliceVar(&usageBy, "by", []string{usage.ByDay, usage.ByCommand, usage.ByModule},
+		"dimensions to group usage by: day, command, module and/or model")
+}
+
+// Usage is the cobra handler for `vyb usage`.
+func Usage(_ *cobra.Command, _ []string) {
+	distToRoot, err := project.FindDistanceToRoot(".")
+	if err != nil {
+		fmt.Printf("Error locating project root: %v\n", err)
+		os.Exit(1)
+	}
+
+	records, err := usage.Load(usage.Path(distToRoot))
+	if err != nil {
+		fmt.Printf("Error reading usage: %v\n", err)
+		os.Exit(1)
+	}
+	totals, err := usage.Aggregate(records, usageBy)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(totals) == 0 {
+		fmt.Println("No usage recorded yet.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := make([]string, len(usageBy))
+	for i, dim := range usageBy {
+		header[i] = strings.ToUpper(dim)
+	}
+	fmt.Fprintf(w, "%s\tCALLS\tINPUT\tOUTPUT\tCOST (USD)\t\n", strings.Join(header, "\t"))
+
+	var sum usage.Total
+	for _, t := range totals {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.4f\t\n", strings.Join(t.Key, "\t"), t.Calls, t.InputTokens, t.OutputTokens, t.Cost)
+		sum.Calls += t.Calls
+		sum.InputTokens += t.InputTokens
+		sum.OutputTokens += t.OutputTokens
+		sum.Cost += t.Cost
+	}
+	label := make([]string, len(usageBy))
+	if len(label) > 0 {
+		label[0] = "TOTAL"
+	}
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.4f\t\n", strings.Join(label, "\t"), sum.Calls, sum.InputTokens, sum.OutputTokens, sum.Cost)
+	_ = w.Flush()
+}
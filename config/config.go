@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -17,6 +20,13 @@ import (
 // Example YAML:
 //
 //	provider: openai
+//	fallback: [gemini]
+//	openai:
+//	  baseURL: http://localhost:11434/v1
+//	  models:
+//	    reasoning:
+//	      large: qwen3:32b
+//	      small: qwen3:8b
 //
 // Zero-value Config is invalid – use Default() when no config file is
 // found.
@@ -29,6 +39,649 @@ import (
 //nolint:revive // field name is intentionally simple
 type Config struct {
 	Provider string `yaml:"provider"`
+
+	// Fallback lists the providers tried, in order, when Provider is out of
+	// quota, rejects the credentials or keeps failing after retries.
+	Fallback []string `yaml:"fallback,omitempty"`
+
+	// OpenAI holds optional settings for the OpenAI provider. It is only
+	// needed when talking to an OpenAI-compatible server other than the
+	// public OpenAI API (e.g. Ollama, llama.cpp or vLLM), or an Azure
+	// OpenAI resource.
+	OpenAI *OpenAIConfig `yaml:"openai,omitempty"`
+
+	// Cassette enables recording LLM calls to disk or replaying previously
+	// recorded calls without network access.
+	Cassette *CassetteConfig `yaml:"cassette,omitempty"`
+
+	// Retry controls how LLM calls that failed with a transient error (rate
+	// limits, server errors, timeouts) are retried.
+	Retry *RetryConfig `yaml:"retry,omitempty"`
+
+	// Timeouts bounds the duration of a single LLM request per model size,
+	// e.g. {small: 2m, large: 10m}. Sizes that are not listed use the
+	// built-in defaults.
+	Timeouts map[ModelSize]time.Duration `yaml:"timeouts,omitempty"`
+
+	// Pricing adds to (or overrides) the built-in price table used to
+	// estimate the cost of every LLM call recorded in .vyb/usage.jsonl.
+	// Keys are model identifiers, or prefixes of them.
+	Pricing map[string]ModelPrice `yaml:"pricing,omitempty"`
+
+	// Cache tunes the on-disk cache of LLM responses kept under
+	// .vyb/cache.
+	Cache *CacheConfig `yaml:"cache,omitempty"`
+
+	// Models overrides, per provider name, the built-in table that maps a
+	// (family,size) pair to a concrete model, and sets extra request
+	// parameters per model. Use ModelsFor to look a provider up.
+	Models map[string]*ProviderModels `yaml:"models,omitempty"`
+
+	// Logs enables transcripts of every request sent to (and response
+	// received from) the LLM providers, for debugging.
+	Logs *LogsConfig `yaml:"logs,omitempty"`
+
+	// Plugins declares external providers by name. A plugin is selected
+	// like any built-in provider, e.g. `provider: gateway`.
+	Plugins map[string]*PluginConfig `yaml:"plugins,omitempty"`
+
+	// RateLimits caps, per provider name, the requests and tokens sent per
+	// minute. The limits are shared by every call made by the process.
+	RateLimits map[string]*RateLimitConfig `yaml:"rateLimits,omitempty"`
+
+	// Annotation tunes how module annotations are generated by init and
+	// update.
+	Annotation *AnnotationConfig `yaml:"annotation,omitempty"`
+
+	// HTTP configures the client every built-in provider sends its
+	// requests with.
+	HTTP *HTTPConfig `yaml:"http,omitempty"`
+
+	// Credentials configures, per provider name, where its API key is
+	// read from.
+	Credentials map[string]*CredentialConfig `yaml:"credentials,omitempty"`
+
+	// Tools lets change-proposal commands call read-only workspace tools
+	// (read_file, list_dir, grep) before answering.
+	Tools *ToolsConfig `yaml:"tools,omitempty"`
+
+	// Budget bounds the size of the requests sent by change-proposal
+	// commands, and tells what to do with requests that exceed it.
+	Budget *BudgetConfig `yaml:"budget,omitempty"`
+}
+
+// CredentialConfig lists the sources of a provider's API key. They are
+// tried in order – environment variable, file, command – and the first one
+// that yields a key wins.
+//
+// Example YAML:
+//
+//	credentials:
+//	  openai:
+//	    apiKeyEnv: TEAM_OPENAI_KEY
+//	    apiKeyFile: ~/.config/vyb/openai.key
+//	    apiKeyCommand: op read op://dev/openai/credential
+type CredentialConfig struct {
+	// APIKeyEnv replaces the provider's default variable, e.g.
+	// GEMINI_API_KEY. For openai, it may also be set as openai.apiKeyEnv,
+	// or openai.azure.apiKeyEnv on Azure; Load rejects different values.
+	APIKeyEnv string `yaml:"apiKeyEnv,omitempty"`
+
+	// APIKeyFile holds the key, surrounding whitespace aside. A leading ~
+	// is expanded to the home directory, and relative paths are resolved
+	// against the project root by Load.
+	APIKeyFile string `yaml:"apiKeyFile,omitempty"`
+
+	// APIKeyCommand is run through the shell, and its output is used as
+	// the key. It runs at most once per process.
+	APIKeyCommand string `yaml:"apiKeyCommand,omitempty"`
+}
+
+// Credential returns the credential sources configured for the named
+// provider, or nil. Names are matched case-insensitively.
+func (c *Config) Credential(provider string) *CredentialConfig {
+	for k, cc := range c.Credentials {
+		if strings.EqualFold(k, provider) {
+			return cc
+		}
+	}
+	return nil
+}
+
+// HTTPConfig configures the HTTP client shared by the built-in providers,
+// e.g. to go through a TLS-intercepting corporate proxy.
+//
+// Example YAML:
+//
+//	http:
+//	  proxy: http://proxy.corp.example:3128
+//	  caFile: /etc/ssl/certs/corp-root.pem
+//	  certFile: certs/client.pem
+//	  keyFile: certs/client-key.pem
+//	  userAgent: vyb (platform team)
+//	  headers:
+//	    X-Cost-Center: "4711"
+type HTTPConfig struct {
+	// Proxy is the URL of the proxy every request goes through. When
+	// empty, HTTPS_PROXY, HTTP_PROXY and NO_PROXY are honoured.
+	Proxy string `yaml:"proxy,omitempty"`
+
+	// CAFile is a PEM bundle of certificate authorities trusted in
+	// addition to the system ones. Relative paths are resolved against
+	// the project root by Load, like CertFile and KeyFile.
+	CAFile string `yaml:"caFile,omitempty"`
+
+	// CertFile and KeyFile hold the PEM client certificate, and its key,
+	// presented to servers that require mutual TLS.
+	CertFile string `yaml:"certFile,omitempty"`
+	KeyFile  string `yaml:"keyFile,omitempty"`
+
+	// Headers are added to every request. They never replace the headers
+	// set by a provider, such as its authentication.
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// UserAgent replaces the default User-Agent header.
+	UserAgent string `yaml:"userAgent,omitempty"`
+}
+
+// RateLimitConfig limits the traffic sent to a provider. Zero fields are
+// not limited.
+//
+// Example YAML:
+//
+//	rateLimits:
+//	  openai:
+//	    requestsPerMinute: 60
+//	    tokensPerMinute: 200000
+type RateLimitConfig struct {
+	RequestsPerMinute int `yaml:"requestsPerMinute,omitempty"`
+	// TokensPerMinute is checked against an estimate of the input tokens
+	// of every request, made before it is sent.
+	TokensPerMinute int `yaml:"tokensPerMinute,omitempty"`
+}
+
+// RateLimit returns the limits configured for the named provider, or nil.
+// Names are matched case-insensitively.
+func (c *Config) RateLimit(provider string) *RateLimitConfig {
+	for k, l := range c.RateLimits {
+		if strings.EqualFold(k, provider) {
+			return l
+		}
+	}
+	return nil
+}
+
+// defaultAnnotationConcurrency is the number of modules annotated at once
+// when .vyb/config.yaml does not say otherwise.
+const defaultAnnotationConcurrency = 4
+
+// AnnotationConfig configures the generation of module annotations.
+type AnnotationConfig struct {
+	// Concurrency is the maximum number of modules annotated at once.
+	Concurrency int `yaml:"concurrency,omitempty"`
+}
+
+// WithDefaults returns a copy of a where every unset field holds its
+// default value. It is safe to call on a nil receiver.
+func (a *AnnotationConfig) WithDefaults() AnnotationConfig {
+	out := AnnotationConfig{}
+	if a != nil {
+		out = *a
+	}
+	if out.Concurrency <= 0 {
+		out.Concurrency = defaultAnnotationConcurrency
+	}
+	return out
+}
+
+// Default bounds of the tool-calling loop, when .vyb/config.yaml does not
+// say otherwise.
+const (
+	defaultToolsMaxCalls  = 20
+	defaultToolsMaxTokens = 50000
+)
+
+// ToolsConfig configures the read-only tools the model may call, before
+// proposing changes, to look at files it was not sent.
+//
+// Example YAML:
+//
+//	tools:
+//	  enabled: true
+//	  maxCalls: 10
+//	  maxTokens: 20000
+type ToolsConfig struct {
+	// Enabled turns the tools on for every change-proposal command, as
+	// --tools does for a single run.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// MaxCalls is the number of tool calls allowed per command.
+	MaxCalls int `yaml:"maxCalls,omitempty"`
+	// MaxTokens caps the estimated size of all tool results sent back to
+	// the model, per command.
+	MaxTokens int `yaml:"maxTokens,omitempty"`
+}
+
+// WithDefaults returns a copy of t where every unset field holds its
+// default value. It is safe to call on a nil receiver.
+func (t *ToolsConfig) WithDefaults() ToolsConfig {
+	out := ToolsConfig{}
+	if t != nil {
+		out = *t
+	}
+	if out.MaxCalls <= 0 {
+		out.MaxCalls = defaultToolsMaxCalls
+	}
+	if out.MaxTokens <= 0 {
+		out.MaxTokens = defaultToolsMaxTokens
+	}
+	return out
+}
+
+// BudgetOverflow selects what happens to a request larger than its budget.
+type BudgetOverflow string
+
+const (
+	// BudgetFail stops the command before anything is sent, listing the
+	// largest files of the request (the default).
+	BudgetFail BudgetOverflow = "fail"
+	// BudgetAnnotate leaves out the files of descendant modules, largest
+	// module first, until the request fits: the model only sees their
+	// annotations, as it would without --all.
+	BudgetAnnotate BudgetOverflow = "annotate"
+)
+
+// BudgetConfig bounds the input tokens of a request. A request may use the
+// context window of its model, minus the maxOutputTokens of its command,
+// and no more than the budget configured for its command.
+//
+// Example YAML:
+//
+//	budget:
+//	  onOverflow: annotate
+//	  contextWindows:
+//	    qwen3: 32768
+//	  commands:
+//	    code: 100000
+type BudgetConfig struct {
+	// OnOverflow is fail or annotate. Defaults to fail.
+	OnOverflow BudgetOverflow `yaml:"onOverflow,omitempty"`
+	// ContextWindows adds to (or overrides) the built-in table of context
+	// windows, in tokens. Keys are model identifiers, or prefixes of them.
+	ContextWindows map[string]int `yaml:"contextWindows,omitempty"`
+	// Commands caps the input tokens of the named commands.
+	Commands map[string]int `yaml:"commands,omitempty"`
+}
+
+// Overflow returns what to do with requests over budget. It is safe to
+// call on a nil receiver.
+func (b *BudgetConfig) Overflow() BudgetOverflow {
+	if b == nil || b.OnOverflow == "" {
+		return BudgetFail
+	}
+	return b.OnOverflow
+}
+
+// Command returns the budget configured for the named command, or 0. It is
+// safe to call on a nil receiver.
+func (b *BudgetConfig) Command(name string) int {
+	if b == nil {
+		return 0
+	}
+	return b.Commands[name]
+}
+
+// PluginConfig describes an exec plugin: an executable that answers LLM
+// requests, exchanging one JSON document over stdin/stdout per call.
+//
+// Example YAML:
+//
+//	provider: gateway
+//	plugins:
+//	  gateway:
+//	    command: ./tools/vyb-gateway
+//	    args: [--region, eu]
+//	    env:
+//	      GATEWAY_TEAM: platform
+type PluginConfig struct {
+	// Command is the executable to run. Relative paths containing a
+	// separator are resolved against the project root by Load; bare names
+	// are looked up in PATH.
+	Command string `yaml:"command"`
+	// Args are passed to Command verbatim.
+	Args []string `yaml:"args,omitempty"`
+	// Env adds variables to the environment inherited from vyb.
+	Env map[string]string `yaml:"env,omitempty"`
+}
+
+// ProviderModels customises the models of a single provider.
+//
+// Example YAML:
+//
+//	models:
+//	  gemini:
+//	    map:
+//	      reasoning:
+//	        large: gemini-2.5-pro
+//	      fast:
+//	        small: gemini-2.5-flash-lite
+//	    params:
+//	      gemini-2.5-pro:
+//	        generationConfig:
+//	          thinkingConfig:
+//	            thinkingBudget: 4096
+type ProviderModels struct {
+	// Map resolves (family,size) pairs to model identifiers. Families that
+	// are not built in can be declared here and used by templates.
+	Map map[ModelFamily]map[ModelSize]string `yaml:"map,omitempty"`
+
+	// Params holds extra request fields per model identifier. They are
+	// deep-merged into the JSON body sent to the provider.
+	Params map[string]map[string]any `yaml:"params,omitempty"`
+}
+
+// ModelsFor returns the models configured for the named provider, or nil.
+// Names are matched case-insensitively.
+func (c *Config) ModelsFor(provider string) *ProviderModels {
+	for k, pm := range c.Models {
+		if strings.EqualFold(k, provider) {
+			return pm
+		}
+	}
+	return nil
+}
+
+// ModelOverride returns the model configured for the (family,size) pair of
+// the given provider, or an empty string when there is none. For the
+// openai provider, Azure deployments take precedence, and the legacy
+// openai.models section is honoured.
+func (c *Config) ModelOverride(provider string, fam ModelFamily, sz ModelSize) string {
+	if strings.EqualFold(provider, "openai") {
+		if d := c.OpenAI.Deployment(fam, sz); d != "" {
+			return d
+		}
+	}
+	if pm := c.ModelsFor(provider); pm != nil {
+		if m := pm.Map[fam][sz]; m != "" {
+			return m
+		}
+	}
+	if strings.EqualFold(provider, "openai") {
+		return c.OpenAI.Model(fam, sz)
+	}
+	return ""
+}
+
+// ModelParams returns the extra request parameters configured for model
+// on the given provider, or nil.
+func (c *Config) ModelParams(provider, model string) map[string]any {
+	if pm := c.ModelsFor(provider); pm != nil {
+		return pm.Params[model]
+	}
+	return nil
+}
+
+// ModelPrice is the price of a model in USD per million tokens.
+type ModelPrice struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// defaultTimeouts bound a single request when .vyb/config.yaml does not.
+// Large reasoning models may think for several minutes before answering.
+var defaultTimeouts = map[ModelSize]time.Duration{
+	ModelSizeSmall: 5 * time.Minute,
+	ModelSizeLarge: 15 * time.Minute,
+}
+
+// Plugin returns the exec plugin configured under name, or nil. Names are
+// matched case-insensitively, like built-in provider names.
+func (c *Config) Plugin(name string) *PluginConfig {
+	for k, p := range c.Plugins {
+		if strings.EqualFold(k, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// RequestTimeout returns the maximum duration of a single request sent to
+// a model of the given size.
+func (c *Config) RequestTimeout(sz ModelSize) time.Duration {
+	if d, ok := c.Timeouts[sz]; ok && d > 0 {
+		return d
+	}
+	if d, ok := defaultTimeouts[sz]; ok {
+		return d
+	}
+	return defaultTimeouts[ModelSizeLarge]
+}
+
+// Retry defaults, used for every field left unset in .vyb/config.yaml.
+const (
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = 2 * time.Second
+	defaultMaxBackoff     = time.Minute
+)
+
+// RetryConfig configures the exponential backoff applied to transient LLM
+// failures. Durations use Go syntax, e.g. "500ms" or "2s".
+type RetryConfig struct {
+	// MaxAttempts is the total number of attempts, including the first
+	// one. Set it to 1 to disable retries.
+	MaxAttempts int `yaml:"maxAttempts,omitempty"`
+	// InitialBackoff is the upper bound of the delay before the second
+	// attempt. It doubles on every subsequent attempt.
+	InitialBackoff time.Duration `yaml:"initialBackoff,omitempty"`
+	// MaxBackoff caps the computed delay. Delays requested by the provider
+	// through Retry-After are honoured even when larger.
+	MaxBackoff time.Duration `yaml:"maxBackoff,omitempty"`
+}
+
+// WithDefaults returns a copy of r where every unset field holds its
+// default value. It is safe to call on a nil receiver.
+func (r *RetryConfig) WithDefaults() RetryConfig {
+	out := RetryConfig{}
+	if r != nil {
+		out = *r
+	}
+	if out.MaxAttempts <= 0 {
+		out.MaxAttempts = defaultMaxAttempts
+	}
+	if out.InitialBackoff <= 0 {
+		out.InitialBackoff = defaultInitialBackoff
+	}
+	if out.MaxBackoff <= 0 {
+		out.MaxBackoff = defaultMaxBackoff
+	}
+	return out
+}
+
+// Cache defaults, used for every field left unset in .vyb/config.yaml.
+const (
+	defaultCacheTTL       = 7 * 24 * time.Hour
+	defaultCacheMaxSizeMB = 256
+)
+
+// CacheConfig configures the response cache. Identical requests (same
+// provider, model, messages and schema) are answered from disk instead of
+// being sent again.
+type CacheConfig struct {
+	// Disabled turns the cache off, as --no-cache does for a single run.
+	Disabled bool `yaml:"disabled,omitempty"`
+	// TTL is how long a response stays valid, e.g. "72h".
+	TTL time.Duration `yaml:"ttl,omitempty"`
+	// MaxSizeMB caps the size of the cache directory. The least recently
+	// used responses are evicted first.
+	MaxSizeMB int64 `yaml:"maxSizeMB,omitempty"`
+}
+
+// WithDefaults returns a copy of c where every unset field holds its
+// default value. It is safe to call on a nil receiver.
+func (c *CacheConfig) WithDefaults() CacheConfig {
+	out := CacheConfig{}
+	if c != nil {
+		out = *c
+	}
+	if out.TTL <= 0 {
+		out.TTL = defaultCacheTTL
+	}
+	if out.MaxSizeMB <= 0 {
+		out.MaxSizeMB = defaultCacheMaxSizeMB
+	}
+	return out
+}
+
+// defaultLogsMaxFiles is the number of transcripts kept when
+// .vyb/config.yaml does not say otherwise.
+const defaultLogsMaxFiles = 50
+
+// LogsConfig configures transcript logging. Transcripts contain the full
+// prompts sent to the providers, and therefore project source code; they
+// are only written when Enabled is set. API keys are always redacted.
+type LogsConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Dir holds one JSON file per call, .vyb/logs by default. Relative
+	// paths are resolved against the project root by Load.
+	Dir string `yaml:"dir,omitempty"`
+	// MaxFiles is the number of transcripts kept; older ones are deleted
+	// as new ones are written.
+	MaxFiles int `yaml:"maxFiles,omitempty"`
+}
+
+// WithDefaults returns a copy of c where every unset field holds its
+// default value. It is safe to call on a nil receiver.
+func (c *LogsConfig) WithDefaults() LogsConfig {
+	out := LogsConfig{}
+	if c != nil {
+		out = *c
+	}
+	if out.MaxFiles <= 0 {
+		out.MaxFiles = defaultLogsMaxFiles
+	}
+	return out
+}
+
+// CassetteMode selects how the cassette directory is used.
+type CassetteMode string
+
+const (
+	// CassetteOff disables record/replay (the default).
+	CassetteOff CassetteMode = ""
+	// CassetteRecord forwards every call to the configured provider and
+	// stores the request/response pair in the cassette directory.
+	CassetteRecord CassetteMode = "record"
+	// CassetteReplay serves every call from the cassette directory and never
+	// reaches the network. Unrecorded requests fail.
+	CassetteReplay CassetteMode = "replay"
+)
+
+// defaultCassetteDir is used when a cassette mode is set without a
+// directory. It is relative to the project root.
+const defaultCassetteDir = ".vyb/cassettes"
+
+// CassetteConfig configures the record/replay mode of the llm package.
+type CassetteConfig struct {
+	Mode CassetteMode `yaml:"mode"`
+	// Dir holds one JSON file per recorded call. Relative paths are
+	// resolved against the project root by Load.
+	Dir string `yaml:"dir,omitempty"`
+}
+
+// OpenAIConfig customises the endpoint, credentials and models used by the
+// OpenAI provider. Every field is optional – the zero value targets the
+// public OpenAI API.
+type OpenAIConfig struct {
+	// BaseURL is the API root the chat-completions path is appended to,
+	// e.g. "http://localhost:11434/v1". Defaults to the public OpenAI API.
+	BaseURL string `yaml:"baseURL,omitempty"`
+
+	// APIKeyEnv is the name of the environment variable holding the API
+	// key. Defaults to OPENAI_API_KEY. When BaseURL is set and APIKeyEnv is
+	// not, a missing key is tolerated and requests are sent without
+	// authentication, which is what most self-hosted servers expect.
+	APIKeyEnv string `yaml:"apiKeyEnv,omitempty"`
+
+	// Models overrides the concrete model used for a (family,size) pair.
+	// Pairs that are not listed keep the provider defaults.
+	//
+	// Deprecated: use the top-level models.openai.map section, which
+	// takes precedence.
+	Models map[ModelFamily]map[ModelSize]string `yaml:"models,omitempty"`
+
+	// Azure sends every request to an Azure OpenAI resource instead of
+	// BaseURL.
+	Azure *AzureOpenAIConfig `yaml:"azure,omitempty"`
+}
+
+// AzureOpenAIConfig targets an Azure OpenAI resource. Azure addresses
+// models through deployments, authenticates with an api-key header and
+// requires an api-version query parameter on every request.
+//
+// Example YAML:
+//
+//	provider: openai
+//	openai:
+//	  azure:
+//	    endpoint: https://my-resource.openai.azure.com
+//	    apiVersion: 2024-10-21
+//	    deployments:
+//	      reasoning:
+//	        large: o3-prod
+//	        small: o4-mini-prod
+//	      gpt:
+//	        large: gpt-41-prod
+type AzureOpenAIConfig struct {
+	// Endpoint is the root URL of the resource.
+	Endpoint string `yaml:"endpoint"`
+
+	// APIVersion is sent as the api-version query parameter. Defaults to
+	// a recent GA version.
+	APIVersion string `yaml:"apiVersion,omitempty"`
+
+	// APIKeyEnv is the name of the environment variable holding the
+	// resource key. Defaults to AZURE_OPENAI_API_KEY.
+	APIKeyEnv string `yaml:"apiKeyEnv,omitempty"`
+
+	// Deployments maps (family,size) pairs to deployment names. Pairs that
+	// are not listed resolve like any other OpenAI model, and the result
+	// is used as the deployment name.
+	Deployments map[ModelFamily]map[ModelSize]string `yaml:"deployments,omitempty"`
+}
+
+// ProviderChain returns Provider followed by its fallbacks, lowercased and
+// without duplicates.
+func (c *Config) ProviderChain() []string {
+	var chain []string
+	seen := map[string]bool{}
+	for _, p := range append([]string{c.Provider}, c.Fallback...) {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		chain = append(chain, p)
+	}
+	return chain
+}
+
+// Model returns the override configured for the given (family,size) pair,
+// or an empty string when there is none. It is safe to call on a nil
+// receiver.
+func (o *OpenAIConfig) Model(fam ModelFamily, sz ModelSize) string {
+	if o == nil {
+		return ""
+	}
+	return o.Models[fam][sz]
+}
+
+// Deployment returns the Azure deployment configured for the given
+// (family,size) pair, or an empty string when there is none. It is safe to
+// call on a nil receiver.
+func (o *OpenAIConfig) Deployment(fam ModelFamily, sz ModelSize) string {
+	if o == nil || o.Azure == nil {
+		return ""
+	}
+	return o.Azure.Deployments[fam][sz]
 }
 
 // defaultProvider is used when no configuration file exists or it cannot
@@ -50,7 +703,39 @@ func Load(projectRoot string) (*Config, error) {
 	if projectRoot == "" {
 		return nil, fmt.Errorf("projectRoot must not be empty")
 	}
-	return LoadFS(os.DirFS(projectRoot))
+	cfg, err := LoadFS(os.DirFS(projectRoot))
+	if err != nil {
+		return nil, err
+	}
+	if c := cfg.Cassette; c != nil && c.Mode != CassetteOff {
+		if c.Dir == "" {
+			c.Dir = defaultCassetteDir
+		}
+		if !filepath.IsAbs(c.Dir) {
+			c.Dir = filepath.Join(projectRoot, c.Dir)
+		}
+	}
+	if l := cfg.Logs; l != nil && l.Dir != "" && !filepath.IsAbs(l.Dir) {
+		l.Dir = filepath.Join(projectRoot, l.Dir)
+	}
+	for _, p := range cfg.Plugins {
+		if p != nil && strings.ContainsRune(p.Command, '/') && !filepath.IsAbs(p.Command) {
+			p.Command = filepath.Join(projectRoot, p.Command)
+		}
+	}
+	for _, cc := range cfg.Credentials {
+		if cc != nil && cc.APIKeyFile != "" && !strings.HasPrefix(cc.APIKeyFile, "~") && !filepath.IsAbs(cc.APIKeyFile) {
+			cc.APIKeyFile = filepath.Join(projectRoot, cc.APIKeyFile)
+		}
+	}
+	if h := cfg.HTTP; h != nil {
+		for _, f := range []*string{&h.CAFile, &h.CertFile, &h.KeyFile} {
+			if *f != "" && !filepath.IsAbs(*f) {
+				*f = filepath.Join(projectRoot, *f)
+			}
+		}
+	}
+	return cfg, nil
 }
 
 // LoadFS performs the same operation as Load but works directly on an
@@ -76,5 +761,30 @@ func LoadFS(fsys fs.FS) (*Config, error) {
 	if cfg.Provider == "" {
 		cfg.Provider = defaultProvider
 	}
+	switch cfg.Budget.Overflow() {
+	case BudgetFail, BudgetAnnotate:
+	default:
+		return nil, fmt.Errorf("%s: budget.onOverflow must be fail or annotate, got %q", relPath, cfg.Budget.OnOverflow)
+	}
+	if err := cfg.checkOpenAIKeyEnv(); err != nil {
+		return nil, fmt.Errorf("%s: %w", relPath, err)
+	}
 	return &cfg, nil
 }
+
+// checkOpenAIKeyEnv rejects configurations that name different variables
+// for the OpenAI key under openai, openai.azure and credentials.openai:
+// only one of them could be consulted.
+func (c *Config) checkOpenAIKeyEnv() error {
+	cred := c.Credential("openai")
+	if cred == nil || cred.APIKeyEnv == "" || c.OpenAI == nil {
+		return nil
+	}
+	if c.OpenAI.Azure != nil && c.OpenAI.Azure.APIKeyEnv != "" && c.OpenAI.Azure.APIKeyEnv != cred.APIKeyEnv {
+		return fmt.Errorf("openai.azure.apiKeyEnv (%s) and credentials.openai.apiKeyEnv (%s) conflict; set only one", c.OpenAI.Azure.APIKeyEnv, cred.APIKeyEnv)
+	}
+	if c.OpenAI.APIKeyEnv != "" && c.OpenAI.APIKeyEnv != cred.APIKeyEnv {
+		return fmt.Errorf("openai.apiKeyEnv (%s) and credentials.openai.apiKeyEnv (%s) conflict; set only one", c.OpenAI.APIKeyEnv, cred.APIKeyEnv)
+	}
+	return nil
+}
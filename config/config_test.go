@@ -1,9 +1,12 @@
 package config
 
 import (
+    "os"
     "path/filepath"
+    "strings"
     "testing"
     "testing/fstest"
+    "time"
 )
 
 func TestLoadFS_Default(t *testing.T) {
@@ -31,3 +34,340 @@ func TestLoadFS_FromFile(t *testing.T) {
         t.Fatalf("expected provider 'fooai', got %s", cfg.Provider)
     }
 }
+
+func TestLoadFS_OpenAICompatible(t *testing.T) {
+    data := "provider: openai\n" +
+        "openai:\n" +
+        "  baseURL: http://localhost:11434/v1\n" +
+        "  apiKeyEnv: OLLAMA_API_KEY\n" +
+        "  models:\n" +
+        "    reasoning:\n" +
+        "      small: qwen3:8b\n"
+    fsys := fstest.MapFS{
+        ".vyb/config.yaml": &fstest.MapFile{Data: []byte(data)},
+    }
+
+    cfg, err := LoadFS(fsys)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if cfg.OpenAI == nil || cfg.OpenAI.BaseURL != "http://localhost:11434/v1" || cfg.OpenAI.APIKeyEnv != "OLLAMA_API_KEY" {
+        t.Fatalf("unexpected openai config: %+v", cfg.OpenAI)
+    }
+    if got := cfg.OpenAI.Model(ModelFamilyReasoning, ModelSizeSmall); got != "qwen3:8b" {
+        t.Fatalf("Model(reasoning,small) = %q, want %q", got, "qwen3:8b")
+    }
+    if got := cfg.OpenAI.Model(ModelFamilyGPT, ModelSizeLarge); got != "" {
+        t.Fatalf("Model(gpt,large) = %q, want empty", got)
+    }
+    if got := (*OpenAIConfig)(nil).Model(ModelFamilyGPT, ModelSizeLarge); got != "" {
+        t.Fatalf("nil receiver Model() = %q, want empty", got)
+    }
+}
+
+func TestLoadFS_Azure(t *testing.T) {
+    data := "provider: openai\n" +
+        "openai:\n" +
+        "  azure:\n" +
+        "    endpoint: https://my-resource.openai.azure.com\n" +
+        "    apiVersion: 2024-10-21\n" +
+        "    deployments:\n" +
+        "      reasoning:\n" +
+        "        large: o3-prod\n"
+    cfg, err := LoadFS(fstest.MapFS{".vyb/config.yaml": &fstest.MapFile{Data: []byte(data)}})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    az := cfg.OpenAI.Azure
+    if az == nil || az.Endpoint != "https://my-resource.openai.azure.com" || az.APIVersion != "2024-10-21" {
+        t.Fatalf("unexpected azure config: %+v", az)
+    }
+    if got := cfg.ModelOverride("openai", ModelFamilyReasoning, ModelSizeLarge); got != "o3-prod" {
+        t.Fatalf("ModelOverride(reasoning,large) = %q, want %q", got, "o3-prod")
+    }
+    if got := cfg.ModelOverride("gemini", ModelFamilyReasoning, ModelSizeLarge); got != "" {
+        t.Fatalf("deployments must only apply to openai, got %q", got)
+    }
+}
+
+func TestLoad_CassetteDirIsResolvedAgainstRoot(t *testing.T) {
+    root := t.TempDir()
+    if err := os.MkdirAll(filepath.Join(root, ".vyb"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(filepath.Join(root, ".vyb", "config.yaml"), []byte("provider: openai\ncassette:\n  mode: replay\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    cfg, err := Load(root)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    want := filepath.Join(root, ".vyb", "cassettes")
+    if cfg.Cassette == nil || cfg.Cassette.Mode != CassetteReplay || cfg.Cassette.Dir != want {
+        t.Fatalf("unexpected cassette config: %+v, want dir %s", cfg.Cassette, want)
+    }
+}
+
+func TestLoad_PluginCommandIsResolvedAgainstRoot(t *testing.T) {
+    root := t.TempDir()
+    if err := os.MkdirAll(filepath.Join(root, ".vyb"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    yaml := "provider: gateway\nplugins:\n  gateway:\n    command: ./tools/gw\n    args: [--eu]\n  system:\n    command: vyb-gw\n"
+    if err := os.WriteFile(filepath.Join(root, ".vyb", "config.yaml"), []byte(yaml), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    cfg, err := Load(root)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    gw := cfg.Plugin("Gateway")
+    if gw == nil || gw.Command != filepath.Join(root, "tools", "gw") || len(gw.Args) != 1 {
+        t.Fatalf("unexpected gateway plugin: %+v", gw)
+    }
+    if sys := cfg.Plugin("system"); sys == nil || sys.Command != "vyb-gw" {
+        t.Fatalf("bare commands must be left for PATH lookup, got %+v", sys)
+    }
+}
+
+func TestLoad_HTTPFilesAreResolvedAgainstRoot(t *testing.T) {
+    root := t.TempDir()
+    if err := os.MkdirAll(filepath.Join(root, ".vyb"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    yaml := "http:\n  proxy: http://proxy:3128\n  caFile: certs/ca.pem\n  certFile: /etc/vyb/client.pem\n  headers:\n    X-Team: platform\n"
+    if err := os.WriteFile(filepath.Join(root, ".vyb", "config.yaml"), []byte(yaml), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    cfg, err := Load(root)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    h := cfg.HTTP
+    if h == nil || h.Proxy != "http://proxy:3128" || h.Headers["X-Team"] != "platform" {
+        t.Fatalf("unexpected http config: %+v", h)
+    }
+    if h.CAFile != filepath.Join(root, "certs", "ca.pem") || h.CertFile != "/etc/vyb/client.pem" || h.KeyFile != "" {
+        t.Fatalf("unexpected file paths: %+v", h)
+    }
+}
+
+func TestLoad_CredentialFilesAreResolvedAgainstRoot(t *testing.T) {
+    root := t.TempDir()
+    if err := os.MkdirAll(filepath.Join(root, ".vyb"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    yaml := "credentials:\n  Gemini:\n    apiKeyFile: secrets/gemini.key\n  openai:\n    apiKeyFile: ~/.openai.key\n    apiKeyCommand: op read op://dev/openai\n"
+    if err := os.WriteFile(filepath.Join(root, ".vyb", "config.yaml"), []byte(yaml), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    cfg, err := Load(root)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if c := cfg.Credential("gemini"); c == nil || c.APIKeyFile != filepath.Join(root, "secrets", "gemini.key") {
+        t.Fatalf("unexpected gemini credentials: %+v", c)
+    }
+    if c := cfg.Credential("openai"); c == nil || c.APIKeyFile != "~/.openai.key" || c.APIKeyCommand != "op read op://dev/openai" {
+        t.Fatalf("unexpected openai credentials: %+v", c)
+    }
+    if c := cfg.Credential("anthropic"); c != nil {
+        t.Fatalf("expected no anthropic credentials, got %+v", c)
+    }
+}
+
+func TestLoadFS_Retry(t *testing.T) {
+    fsys := fstest.MapFS{
+        ".vyb/config.yaml": &fstest.MapFile{Data: []byte("provider: gemini\nretry:\n  maxAttempts: 3\n  initialBackoff: 500ms\n")},
+    }
+
+    cfg, err := LoadFS(fsys)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    got := cfg.Retry.WithDefaults()
+    want := RetryConfig{MaxAttempts: 3, InitialBackoff: 500 * time.Millisecond, MaxBackoff: time.Minute}
+    if got != want {
+        t.Fatalf("Retry.WithDefaults() = %+v, want %+v", got, want)
+    }
+
+    if got := (*RetryConfig)(nil).WithDefaults(); got.MaxAttempts != 5 || got.InitialBackoff != 2*time.Second {
+        t.Fatalf("nil WithDefaults() = %+v", got)
+    }
+}
+
+func TestRequestTimeout(t *testing.T) {
+    fsys := fstest.MapFS{
+        ".vyb/config.yaml": &fstest.MapFile{Data: []byte("provider: gemini\ntimeouts:\n  small: 90s\n")},
+    }
+
+    cfg, err := LoadFS(fsys)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if got := cfg.RequestTimeout(ModelSizeSmall); got != 90*time.Second {
+        t.Fatalf("RequestTimeout(small) = %s, want 90s", got)
+    }
+    if got := cfg.RequestTimeout(ModelSizeLarge); got != 15*time.Minute {
+        t.Fatalf("RequestTimeout(large) = %s, want default 15m", got)
+    }
+}
+
+func TestCacheConfig_WithDefaults(t *testing.T) {
+    fsys := fstest.MapFS{
+        ".vyb/config.yaml": &fstest.MapFile{Data: []byte("provider: gemini\ncache:\n  ttl: 72h\n")},
+    }
+
+    cfg, err := LoadFS(fsys)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    got := cfg.Cache.WithDefaults()
+    want := CacheConfig{TTL: 72 * time.Hour, MaxSizeMB: 256}
+    if got != want {
+        t.Fatalf("Cache.WithDefaults() = %+v, want %+v", got, want)
+    }
+    if got := (*CacheConfig)(nil).WithDefaults(); got.Disabled || got.TTL != 7*24*time.Hour {
+        t.Fatalf("nil WithDefaults() = %+v", got)
+    }
+}
+
+func TestProviderChain(t *testing.T) {
+    fsys := fstest.MapFS{
+        ".vyb/config.yaml": &fstest.MapFile{Data: []byte("provider: Gemini\nfallback: [openai, gemini, anthropic]\n")},
+    }
+
+    cfg, err := LoadFS(fsys)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    got := cfg.ProviderChain()
+    want := []string{"gemini", "openai", "anthropic"}
+    if strings.Join(got, ",") != strings.Join(want, ",") {
+        t.Fatalf("ProviderChain() = %v, want %v", got, want)
+    }
+}
+
+func TestModelsFor_MatchesNamesCaseInsensitively(t *testing.T) {
+    fsys := fstest.MapFS{
+        ".vyb/config.yaml": &fstest.MapFile{Data: []byte("provider: openai\n" +
+            "models:\n" +
+            "  OpenAI:\n" +
+            "    map:\n" +
+            "      reasoning:\n" +
+            "        large: o3-custom\n" +
+            "    params:\n" +
+            "      o3-custom:\n" +
+            "        reasoning_effort: high\n" +
+            "  MyPlugin:\n" +
+            "    map:\n" +
+            "      reasoning:\n" +
+            "        small: local-small\n")},
+    }
+
+    cfg, err := LoadFS(fsys)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if got := cfg.ModelOverride("openai", ModelFamilyReasoning, ModelSizeLarge); got != "o3-custom" {
+        t.Errorf("ModelOverride(openai) = %q, want %q", got, "o3-custom")
+    }
+    if got := cfg.ModelParams("openai", "o3-custom"); got["reasoning_effort"] != "high" {
+        t.Errorf("ModelParams(openai) = %v, want reasoning_effort: high", got)
+    }
+    if got := cfg.ModelOverride("myplugin", ModelFamilyReasoning, ModelSizeSmall); got != "local-small" {
+        t.Errorf("ModelOverride(myplugin) = %q, want %q", got, "local-small")
+    }
+}
+
+func TestLoadFS_OpenAIKeyEnvConflicts(t *testing.T) {
+    tests := map[string]string{
+        "openai.apiKeyEnv":       "openai:\n  apiKeyEnv: LOCAL_KEY\ncredentials:\n  OpenAI:\n    apiKeyEnv: TEAM_KEY\n",
+        "openai.azure.apiKeyEnv": "openai:\n  azure:\n    endpoint: https://x\n    apiKeyEnv: TENANT_KEY\ncredentials:\n  openai:\n    apiKeyEnv: TEAM_KEY\n",
+    }
+    for setting, yml := range tests {
+        _, err := LoadFS(fstest.MapFS{".vyb/config.yaml": &fstest.MapFile{Data: []byte(yml)}})
+        if err == nil || !strings.Contains(err.Error(), setting+" (") || !strings.Contains(err.Error(), "credentials.openai.apiKeyEnv (TEAM_KEY)") {
+            t.Errorf("expected %s to conflict with credentials.openai.apiKeyEnv, got %v", setting, err)
+        }
+    }
+
+    // the same variable, named twice, is not a conflict.
+    yml := "openai:\n  apiKeyEnv: TEAM_KEY\ncredentials:\n  openai:\n    apiKeyEnv: TEAM_KEY\n"
+    if _, err := LoadFS(fstest.MapFS{".vyb/config.yaml": &fstest.MapFile{Data: []byte(yml)}}); err != nil {
+        t.Errorf("unexpected error: %v", err)
+    }
+}
+
+func TestLoadFS_RateLimitsAndAnnotation(t *testing.T) {
+    data := "provider: openai\n" +
+        "rateLimits:\n" +
+        "  OpenAI:\n" +
+        "    requestsPerMinute: 60\n" +
+        "    tokensPerMinute: 200000\n" +
+        "annotation:\n" +
+        "  concurrency: 8\n"
+    cfg, err := LoadFS(fstest.MapFS{".vyb/config.yaml": &fstest.MapFile{Data: []byte(data)}})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if l := cfg.RateLimit("openai"); l == nil || l.RequestsPerMinute != 60 || l.TokensPerMinute != 200000 {
+        t.Fatalf("unexpected rate limit: %+v", l)
+    }
+    if l := cfg.RateLimit("gemini"); l != nil {
+        t.Fatalf("expected no gemini limit, got %+v", l)
+    }
+    if got := cfg.Annotation.WithDefaults().Concurrency; got != 8 {
+        t.Fatalf("Concurrency = %d, want 8", got)
+    }
+    if got := (*AnnotationConfig)(nil).WithDefaults().Concurrency; got != defaultAnnotationConcurrency {
+        t.Fatalf("default Concurrency = %d, want %d", got, defaultAnnotationConcurrency)
+    }
+}
+
+func TestLoadFS_Tools(t *testing.T) {
+    data := "provider: openai\n" +
+        "tools:\n" +
+        "  enabled: true\n" +
+        "  maxCalls: 5\n"
+    cfg, err := LoadFS(fstest.MapFS{".vyb/config.yaml": &fstest.MapFile{Data: []byte(data)}})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    got := cfg.Tools.WithDefaults()
+    if !got.Enabled || got.MaxCalls != 5 || got.MaxTokens != defaultToolsMaxTokens {
+        t.Fatalf("unexpected tools config: %+v", got)
+    }
+    if got := (*ToolsConfig)(nil).WithDefaults(); got.Enabled || got.MaxCalls != defaultToolsMaxCalls {
+        t.Fatalf("unexpected default tools config: %+v", got)
+    }
+}
+
+func TestLoadFS_Budget(t *testing.T) {
+    data := "provider: openai\n" +
+        "budget:\n" +
+        "  onOverflow: annotate\n" +
+        "  contextWindows:\n" +
+        "    qwen3: 32768\n" +
+        "  commands:\n" +
+        "    code: 100000\n"
+    cfg, err := LoadFS(fstest.MapFS{".vyb/config.yaml": &fstest.MapFile{Data: []byte(data)}})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if cfg.Budget.Overflow() != BudgetAnnotate || cfg.Budget.Command("code") != 100000 || cfg.Budget.ContextWindows["qwen3"] != 32768 {
+        t.Fatalf("unexpected budget config: %+v", cfg.Budget)
+    }
+    if got := (*BudgetConfig)(nil); got.Overflow() != BudgetFail || got.Command("code") != 0 {
+        t.Fatalf("unexpected default budget config")
+    }
+
+    _, err = LoadFS(fstest.MapFS{".vyb/config.yaml": &fstest.MapFile{Data: []byte("budget:\n  onOverflow: truncate\n")}})
+    if err == nil || !strings.Contains(err.Error(), "budget.onOverflow") {
+        t.Fatalf("expected an invalid onOverflow to be rejected, got %v", err)
+    }
+}
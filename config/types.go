@@ -1,10 +1,12 @@
 package config
 
+import "fmt"
+
 // ModelFamily represents the generic family of a language model.
 //
-// The enumeration is intentionally small for now – new families can be
-// added without touching the public API surface that depends on the
-// underlying string values.
+// The built-in families below are available with every provider. Any other
+// value is a user-defined family: it can be used by templates as long as
+// .vyb/config.yaml maps it to concrete models (see ProviderModels).
 //
 // NOTE: keep the string literals all-lowercase as they are used for
 // YAML/JSON marshaling and command-line flags.
@@ -14,11 +16,7 @@ package config
 //	var f ModelFamily = ModelFamilyGPT
 //	fmt.Println(f) // -> "gpt"
 //
-// The zero value is an empty string and therefore invalid. Always
-// initialise the variable with one of the provided constants.
-//
-// New families MUST be handled in every provider implementation – use
-// exhaustive switch checks to ensure compile-time safety.
+// The zero value is an empty string and therefore invalid.
 type ModelFamily string
 
 const (
@@ -34,8 +32,8 @@ const (
 func (m ModelFamily) String() string { return string(m) }
 
 // ModelSize captures the coarse size tier of a model within the same
-// family.  Providers translate these buckets to concrete model names
-// (e.g. "large" → "gpt-4o", "small" → "gpt-3.5-turbo-0125").
+// family.  The llm package translates these buckets to concrete model
+// names (e.g. "large" → "o3", "small" → "o4-mini").
 type ModelSize string
 
 const (
@@ -48,3 +46,52 @@ const (
 )
 
 func (m ModelSize) String() string { return string(m) }
+
+// ReasoningEffort tells reasoning models how much thinking to put into an
+// answer before replying.
+type ReasoningEffort string
+
+const (
+	ReasoningEffortMinimal ReasoningEffort = "minimal"
+	ReasoningEffortLow     ReasoningEffort = "low"
+	ReasoningEffortMedium  ReasoningEffort = "medium"
+	ReasoningEffortHigh    ReasoningEffort = "high"
+)
+
+func (r ReasoningEffort) String() string { return string(r) }
+
+// GenerationParams tunes how a model generates its answer. Unset fields
+// leave the provider's defaults in place. Providers drop, with a warning,
+// the parameters the selected model does not support.
+type GenerationParams struct {
+	Temperature     *float64        `yaml:"temperature,omitempty" json:"temperature,omitempty"`
+	TopP            *float64        `yaml:"topP,omitempty" json:"topP,omitempty"`
+	ReasoningEffort ReasoningEffort `yaml:"reasoningEffort,omitempty" json:"reasoningEffort,omitempty"`
+	MaxOutputTokens int             `yaml:"maxOutputTokens,omitempty" json:"maxOutputTokens,omitempty"`
+	Seed            *int64          `yaml:"seed,omitempty" json:"seed,omitempty"`
+}
+
+// IsZero reports whether g leaves every parameter to the provider.
+func (g GenerationParams) IsZero() bool {
+	return g.Temperature == nil && g.TopP == nil && g.ReasoningEffort == "" && g.MaxOutputTokens == 0 && g.Seed == nil
+}
+
+// Validate checks that every parameter set in g is within the range
+// accepted by the providers.
+func (g GenerationParams) Validate() error {
+	if g.Temperature != nil && (*g.Temperature < 0 || *g.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2, got %v", *g.Temperature)
+	}
+	if g.TopP != nil && (*g.TopP <= 0 || *g.TopP > 1) {
+		return fmt.Errorf("topP must be greater than 0 and at most 1, got %v", *g.TopP)
+	}
+	switch g.ReasoningEffort {
+	case "", ReasoningEffortMinimal, ReasoningEffortLow, ReasoningEffortMedium, ReasoningEffortHigh:
+	default:
+		return fmt.Errorf("reasoningEffort must be one of minimal, low, medium or high, got %q", g.ReasoningEffort)
+	}
+	if g.MaxOutputTokens < 0 {
+		return fmt.Errorf("maxOutputTokens must not be negative, got %d", g.MaxOutputTokens)
+	}
+	return nil
+}
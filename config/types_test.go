@@ -52,3 +52,26 @@ func TestModelSizeString(t *testing.T) {
 		t.Fatalf("unhandled ModelSize constant %q", sz)
 	}
 }
+
+func TestGenerationParamsValidate(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	cases := []struct {
+		in      GenerationParams
+		wantErr bool
+	}{
+		{GenerationParams{}, false},
+		{GenerationParams{Temperature: f(0), TopP: f(1), ReasoningEffort: ReasoningEffortMinimal, MaxOutputTokens: 100}, false},
+		{GenerationParams{Temperature: f(2.5)}, true},
+		{GenerationParams{TopP: f(0)}, true},
+		{GenerationParams{ReasoningEffort: "extreme"}, true},
+		{GenerationParams{MaxOutputTokens: -1}, true},
+	}
+	for _, c := range cases {
+		if err := c.in.Validate(); (err != nil) != c.wantErr {
+			t.Errorf("%+v: Validate() = %v, wantErr %v", c.in, err, c.wantErr)
+		}
+	}
+	if !(GenerationParams{}).IsZero() || (GenerationParams{MaxOutputTokens: 1}).IsZero() {
+		t.Errorf("unexpected IsZero results")
+	}
+}
@@ -0,0 +1,62 @@
+package llm
+
+import (
+    "strings"
+
+    "github.com/vybdev/vyb/config"
+)
+
+// DefaultContextWindows lists the context window, in tokens, of the models
+// vyb maps to by default. Keys are matched as model prefixes, like the
+// price table, so "gemini-2.5-pro" also covers
+// "gemini-2.5-pro-preview-06-05".
+var DefaultContextWindows = map[string]int{
+    "gpt-4.1":           1047576,
+    "o3":                200000,
+    "o4-mini":           200000,
+    "gemini-2.5-pro":    1048576,
+    "gemini-2.5-flash":  1048576,
+    "claude-opus-4-1":   200000,
+    "claude-sonnet-4-5": 200000,
+    "claude-haiku-4-5":  200000,
+}
+
+// ContextWindow returns the context window, in tokens, of the model the
+// (fam, sz) pair resolves to, looking at the contextWindows of
+// .vyb/config.yaml before DefaultContextWindows. With fallback providers,
+// it is the smallest window of the chain, as any of them may end up
+// answering. It returns 0 when no model of the chain has a known window.
+func ContextWindow(cfg *config.Config, fam config.ModelFamily, sz config.ModelSize) int {
+    var overrides map[string]int
+    if cfg.Budget != nil {
+        overrides = cfg.Budget.ContextWindows
+    }
+    window := 0
+    for _, name := range cfg.ProviderChain() {
+        m, err := resolveModel(cfg, name, fam, sz)
+        if err != nil {
+            continue
+        }
+        w := lookupWindow(overrides, m.ID)
+        if w == 0 {
+            w = lookupWindow(DefaultContextWindows, m.ID)
+        }
+        if w > 0 && (window == 0 || w < window) {
+            window = w
+        }
+    }
+    return window
+}
+
+// lookupWindow returns the window of the longest prefix of model in
+// windows, ignoring case, or 0.
+func lookupWindow(windows map[string]int, model string) int {
+    model = strings.ToLower(model)
+    window, best := 0, -1
+    for k, w := range windows {
+        if k := strings.ToLower(k); strings.HasPrefix(model, k) && len(k) > best {
+            window, best = w, len(k)
+        }
+    }
+    return window
+}
@@ -0,0 +1,48 @@
+package llm
+
+import (
+    "testing"
+
+    "github.com/vybdev/vyb/config"
+)
+
+func TestContextWindow(t *testing.T) {
+    tests := []struct {
+        name string
+        cfg  *config.Config
+        want int
+    }{
+        {
+            // GPT-4.1 is matched case-insensitively.
+            name: "built-in model",
+            cfg:  &config.Config{Provider: "openai"},
+            want: 1047576,
+        },
+        {
+            name: "smallest window of the chain",
+            cfg:  &config.Config{Provider: "gemini", Fallback: []string{"anthropic"}},
+            want: 200000,
+        },
+        {
+            name: "configured window",
+            cfg: &config.Config{
+                Provider: "openai",
+                Models:   map[string]*config.ProviderModels{"openai": {Map: map[config.ModelFamily]map[config.ModelSize]string{config.ModelFamilyGPT: {config.ModelSizeLarge: "qwen3:32b"}}}},
+                Budget:   &config.BudgetConfig{ContextWindows: map[string]int{"qwen3": 32768}},
+            },
+            want: 32768,
+        },
+        {
+            name: "unknown model",
+            cfg:  &config.Config{Provider: "gateway", Plugins: map[string]*config.PluginConfig{"gateway": {Command: "vyb-gw"}}},
+            want: 0,
+        },
+    }
+    for _, tc := range tests {
+        t.Run(tc.name, func(t *testing.T) {
+            if got := ContextWindow(tc.cfg, config.ModelFamilyGPT, config.ModelSizeLarge); got != tc.want {
+                t.Fatalf("ContextWindow() = %d, want %d", got, tc.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,259 @@
+package llm
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/payload"
+)
+
+// CacheDir returns the location of the response cache of the project
+// rooted at projectRoot.
+func CacheDir(projectRoot string) string {
+    return filepath.Join(projectRoot, ".vyb", "cache")
+}
+
+// Cache is an on-disk, content-addressed store of LLM responses. Entries
+// expire after a TTL, and the least recently used ones are evicted once the
+// directory grows beyond its size cap. It is safe for concurrent use.
+//
+// Commands opt in by attaching a Cache to the context passed to the llm
+// façade with WithCache.
+type Cache struct {
+    mu      sync.Mutex
+    dir     string
+    ttl     time.Duration
+    maxSize int64
+    now     func() time.Time
+}
+
+// NewCache returns the response cache of the project rooted at
+// projectRoot.
+func NewCache(projectRoot string, cfg *config.CacheConfig) *Cache {
+    c := cfg.WithDefaults()
+    return &Cache{dir: CacheDir(projectRoot), ttl: c.TTL, maxSize: c.MaxSizeMB << 20, now: time.Now}
+}
+
+// Clear removes every cached response.
+func (c *Cache) Clear() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return os.RemoveAll(c.dir)
+}
+
+type cacheCtxKey struct{}
+
+// WithCache returns a copy of ctx whose LLM calls are served from, and
+// stored in, c.
+func WithCache(ctx context.Context, c *Cache) context.Context {
+    return context.WithValue(ctx, cacheCtxKey{}, c)
+}
+
+func cacheFromContext(ctx context.Context) *Cache {
+    c, _ := ctx.Value(cacheCtxKey{}).(*Cache)
+    return c
+}
+
+// cacheEntry is the on-disk representation of a cached response.
+type cacheEntry struct {
+    CreatedAt time.Time       `json:"createdAt"`
+    Response  json.RawMessage `json:"response"`
+}
+
+// key hashes every input that can influence the response.
+func (c *Cache) key(namespace, schema, model, sysMsg, userMsg string) string {
+    b, _ := json.Marshal([]string{namespace, schema, model, sysMsg, userMsg})
+    sum := sha256.Sum256(b)
+    return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(key string) string {
+    return filepath.Join(c.dir, key+".json")
+}
+
+// load decodes the response stored under key into v. It reports false on a
+// miss, including expired and unreadable entries.
+func (c *Cache) load(key string, v any) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    p := c.path(key)
+    data, err := os.ReadFile(p)
+    if err != nil {
+        return false
+    }
+    var entry cacheEntry
+    if err := json.Unmarshal(data, &entry); err != nil || c.now().Sub(entry.CreatedAt) > c.ttl {
+        _ = os.Remove(p)
+        return false
+    }
+    if err := json.Unmarshal(entry.Response, v); err != nil {
+        _ = os.Remove(p)
+        return false
+    }
+    // The modification time tracks the last use, for eviction.
+    now := c.now()
+    _ = os.Chtimes(p, now, now)
+    return true
+}
+
+// store persists the response under key and evicts old entries when the
+// cache outgrows its cap.
+func (c *Cache) store(key string, v any) error {
+    resp, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    data, err := json.Marshal(cacheEntry{CreatedAt: c.now(), Response: resp})
+    if err != nil {
+        return err
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if err := os.MkdirAll(c.dir, 0755); err != nil {
+        return err
+    }
+    if err := os.WriteFile(c.path(key), data, 0644); err != nil {
+        return err
+    }
+    now := c.now()
+    _ = os.Chtimes(c.path(key), now, now)
+    return c.evict()
+}
+
+// evict removes expired entries, then the least recently used ones until
+// the cache fits its size cap. Callers must hold c.mu.
+func (c *Cache) evict() error {
+    dirEntries, err := os.ReadDir(c.dir)
+    if err != nil {
+        return err
+    }
+    type file struct {
+        path    string
+        size    int64
+        lastUse time.Time
+    }
+    var files []file
+    var total int64
+    for _, de := range dirEntries {
+        if de.IsDir() || !strings.HasSuffix(de.Name(), ".json") {
+            continue
+        }
+        info, err := de.Info()
+        if err != nil {
+            continue
+        }
+        p := filepath.Join(c.dir, de.Name())
+        if c.now().Sub(info.ModTime()) > c.ttl {
+            _ = os.Remove(p)
+            continue
+        }
+        files = append(files, file{path: p, size: info.Size(), lastUse: info.ModTime()})
+        total += info.Size()
+    }
+    sort.Slice(files, func(i, j int) bool { return files[i].lastUse.Before(files[j].lastUse) })
+    for _, f := range files {
+        if total <= c.maxSize {
+            break
+        }
+        if err := os.Remove(f.path); err == nil || os.IsNotExist(err) {
+            total -= f.size
+        }
+    }
+    return nil
+}
+
+// cached serves the call from the cache when possible, and caches the
+// result of successful calls otherwise. Failing to write the cache never
+// fails the call.
+func cached[T any](c *Cache, key string, call func() (*T, error)) (*T, error) {
+    var out T
+    if c.load(key, &out) {
+        return &out, nil
+    }
+    res, err := call()
+    if err != nil {
+        return nil, err
+    }
+    if err := c.store(key, res); err != nil {
+        fmt.Printf("warning: failed to cache LLM response: %v\n", err)
+    }
+    return res, nil
+}
+
+// cachingProvider decorates a provider with the cache attached to the
+// context of each call. Calls made without a cache go straight to inner.
+type cachingProvider struct {
+    inner Provider
+    // cfg resolves the model of every call, which is part of its key.
+    cfg *config.Config
+    // namespace identifies the provider and the settings that affect its
+    // answers (endpoint, model overrides), so they never share entries.
+    namespace string
+}
+
+func (p *cachingProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+    call := func() (*payload.WorkspaceChangeProposal, error) {
+        return p.inner.GetWorkspaceChangeProposals(ctx, fam, sz, gen, sysMsg, userMsg)
+    }
+    c := cacheFromContext(ctx)
+    if c == nil {
+        return call()
+    }
+    return cached(c, c.key(p.namespace, schemaWorkspaceChangeProposal, modelKey(p.cfg, fam, sz, gen), sysMsg, userMsg), call)
+}
+
+func (p *cachingProvider) GetModuleContext(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    call := func() (*payload.ModuleSelfContainedContext, error) {
+        return p.inner.GetModuleContext(ctx, sysMsg, userMsg)
+    }
+    c := cacheFromContext(ctx)
+    if c == nil {
+        return call()
+    }
+    return cached(c, c.key(p.namespace, schemaModuleContext, modelKey(p.cfg, annotationFamily, annotationSize, config.GenerationParams{}), sysMsg, userMsg), call)
+}
+
+func (p *cachingProvider) GetModuleExternalContexts(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
+    call := func() (*payload.ModuleExternalContextResponse, error) {
+        return p.inner.GetModuleExternalContexts(ctx, sysMsg, userMsg)
+    }
+    c := cacheFromContext(ctx)
+    if c == nil {
+        return call()
+    }
+    return cached(c, c.key(p.namespace, schemaModuleExternalContext, modelKey(p.cfg, annotationFamily, annotationSize, config.GenerationParams{}), sysMsg, userMsg), call)
+}
+
+func (p *cachingProvider) GetChatReply(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg string, history []payload.Message) (*payload.ChatReply, error) {
+    call := func() (*payload.ChatReply, error) {
+        return p.inner.GetChatReply(ctx, fam, sz, gen, sysMsg, history)
+    }
+    c := cacheFromContext(ctx)
+    if c == nil {
+        return call()
+    }
+    return cached(c, c.key(p.namespace, schemaChatReply, modelKey(p.cfg, fam, sz, gen), sysMsg, historyKey(history)), call)
+}
+
+// cacheNamespace renders the provider settings that influence responses.
+func cacheNamespace(cfg *config.Config) string {
+    b, _ := json.Marshal(struct {
+        Providers []string                          `json:"providers"`
+        OpenAI    *config.OpenAIConfig              `json:"openai,omitempty"`
+        Models    map[string]*config.ProviderModels `json:"models,omitempty"`
+        Plugins   map[string]*config.PluginConfig   `json:"plugins,omitempty"`
+    }{cfg.ProviderChain(), cfg.OpenAI, cfg.Models, cfg.Plugins})
+    return string(b)
+}
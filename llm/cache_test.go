@@ -0,0 +1,179 @@
+package llm
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/payload"
+)
+
+func newTestCache(t *testing.T, cfg *config.CacheConfig) (*Cache, *time.Time) {
+    t.Helper()
+    now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+    c := NewCache(t.TempDir(), cfg)
+    c.now = func() time.Time { return now }
+    return c, &now
+}
+
+func TestCachingProvider_HitAndExpiry(t *testing.T) {
+    c, now := newTestCache(t, &config.CacheConfig{TTL: time.Hour})
+    inner := &fakeProvider{ctx: &payload.ModuleSelfContainedContext{InternalContext: "i", PublicContext: "p"}}
+    p := &cachingProvider{inner: inner, cfg: &config.Config{Provider: "openai"}, namespace: "fake"}
+    ctx := WithCache(context.Background(), c)
+
+    for i := 0; i < 2; i++ {
+        got, err := p.GetModuleContext(ctx, "sys", "usr")
+        if err != nil || got.PublicContext != "p" {
+            t.Fatalf("call %d: got %+v (err %v)", i, got, err)
+        }
+    }
+    if inner.calls != 1 {
+        t.Fatalf("provider called %d times, want the second call to be cached", inner.calls)
+    }
+
+    // A different message is a different entry.
+    if _, err := p.GetModuleContext(ctx, "sys", "other"); err != nil || inner.calls != 2 {
+        t.Fatalf("expected a miss for a different request, got %d calls (err %v)", inner.calls, err)
+    }
+
+    *now = now.Add(2 * time.Hour)
+    if _, err := p.GetModuleContext(ctx, "sys", "usr"); err != nil || inner.calls != 3 {
+        t.Fatalf("expected expired entry to be refreshed, got %d calls (err %v)", inner.calls, err)
+    }
+}
+
+func TestCachingProvider_ChatIsKeyedByHistory(t *testing.T) {
+    c, _ := newTestCache(t, nil)
+    inner := &fakeProvider{reply: &payload.ChatReply{Answer: "a"}}
+    p := &cachingProvider{inner: inner, cfg: &config.Config{Provider: "openai"}, namespace: "fake"}
+    ctx := WithCache(context.Background(), c)
+
+    first := []payload.Message{{Role: payload.RoleUser, Content: "q"}}
+    longer := append(append([]payload.Message(nil), first...), (&payload.ChatReply{Answer: "a"}).AsMessage(), payload.Message{Role: payload.RoleUser, Content: "q"})
+    for _, h := range [][]payload.Message{first, first, longer} {
+        if got, err := p.GetChatReply(ctx, config.ModelFamilyGPT, config.ModelSizeSmall, config.GenerationParams{}, "sys", h); err != nil || got.Answer != "a" {
+            t.Fatalf("got %+v (err %v)", got, err)
+        }
+    }
+    if inner.calls != 2 {
+        t.Fatalf("provider called %d times, want only the repeated history to be cached", inner.calls)
+    }
+}
+
+func TestCachingProvider_KeyedByGenerationParams(t *testing.T) {
+    c, _ := newTestCache(t, nil)
+    inner := &fakeProvider{reply: &payload.ChatReply{Answer: "a"}}
+    p := &cachingProvider{inner: inner, cfg: &config.Config{Provider: "openai"}, namespace: "fake"}
+    ctx := WithCache(context.Background(), c)
+
+    low, high := 0.1, 1.5
+    h := []payload.Message{{Role: payload.RoleUser, Content: "q"}}
+    for _, gen := range []config.GenerationParams{{}, {Temperature: &low}, {Temperature: &low}, {Temperature: &high}} {
+        if _, err := p.GetChatReply(ctx, config.ModelFamilyGPT, config.ModelSizeSmall, gen, "sys", h); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if inner.calls != 3 {
+        t.Fatalf("provider called %d times, want one call per distinct set of parameters", inner.calls)
+    }
+}
+
+func TestCachingProvider_KeyedByResolvedModel(t *testing.T) {
+    c, _ := newTestCache(t, nil)
+    inner := &fakeProvider{ctx: &payload.ModuleSelfContainedContext{PublicContext: "p"}}
+    ctx := WithCache(context.Background(), c)
+
+    remapped := &config.Config{Provider: "openai", Models: map[string]*config.ProviderModels{"openai": {Map: map[config.ModelFamily]map[config.ModelSize]string{annotationFamily: {annotationSize: "o3"}}}}}
+    for _, cfg := range []*config.Config{{Provider: "openai"}, {Provider: "openai"}, remapped, {Provider: "gemini"}} {
+        p := &cachingProvider{inner: inner, cfg: cfg, namespace: "fake"}
+        if _, err := p.GetModuleContext(ctx, "sys", "usr"); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if inner.calls != 3 {
+        t.Fatalf("provider called %d times, want one call per provider and model", inner.calls)
+    }
+}
+
+func TestCachingProvider_NoCacheInContext(t *testing.T) {
+    inner := &fakeProvider{ctx: &payload.ModuleSelfContainedContext{}}
+    p := &cachingProvider{inner: inner, cfg: &config.Config{Provider: "openai"}, namespace: "fake"}
+
+    for i := 0; i < 2; i++ {
+        if _, err := p.GetModuleContext(context.Background(), "sys", "usr"); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+    }
+    if inner.calls != 2 {
+        t.Fatalf("provider called %d times, want 2", inner.calls)
+    }
+}
+
+func TestCachingProvider_ErrorsAreNotCached(t *testing.T) {
+    c, _ := newTestCache(t, nil)
+    inner := &fakeProvider{err: errors.New("boom")}
+    p := &cachingProvider{inner: inner, cfg: &config.Config{Provider: "openai"}, namespace: "fake"}
+    ctx := WithCache(context.Background(), c)
+
+    for i := 0; i < 2; i++ {
+        if _, err := p.GetModuleExternalContexts(ctx, "sys", "usr"); err == nil {
+            t.Fatalf("expected error")
+        }
+    }
+    if inner.calls != 2 {
+        t.Fatalf("provider called %d times, want 2", inner.calls)
+    }
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+    c, now := newTestCache(t, nil)
+
+    entry := &payload.ModuleSelfContainedContext{PublicContext: "context"}
+    for _, k := range []string{"a", "b", "c"} {
+        if err := c.store(k, entry); err != nil {
+            t.Fatalf("store(%s): %v", k, err)
+        }
+        *now = now.Add(time.Minute)
+    }
+    // Room for exactly three entries.
+    info, err := os.Stat(filepath.Join(c.dir, "a.json"))
+    if err != nil {
+        t.Fatalf("stat: %v", err)
+    }
+    c.maxSize = 3 * info.Size()
+
+    // Use "a" so "b" becomes the least recently used entry.
+    var out payload.ModuleSelfContainedContext
+    if !c.load("a", &out) {
+        t.Fatalf("expected a hit for a")
+    }
+    *now = now.Add(time.Minute)
+    if err := c.store("d", entry); err != nil {
+        t.Fatalf("store(d): %v", err)
+    }
+
+    for k, want := range map[string]bool{"a": true, "b": false, "d": true} {
+        _, err := os.Stat(filepath.Join(c.dir, k+".json"))
+        if got := err == nil; got != want {
+            t.Errorf("entry %s present = %v, want %v", k, got, want)
+        }
+    }
+}
+
+func TestCache_Clear(t *testing.T) {
+    c, _ := newTestCache(t, nil)
+    if err := c.store("a", &payload.ModuleSelfContainedContext{}); err != nil {
+        t.Fatalf("store: %v", err)
+    }
+    if err := c.Clear(); err != nil {
+        t.Fatalf("Clear: %v", err)
+    }
+    if _, err := os.Stat(c.dir); !os.IsNotExist(err) {
+        t.Fatalf("expected cache dir to be removed, got %v", err)
+    }
+}
@@ -0,0 +1,251 @@
+package llm
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/payload"
+)
+
+// Schema names identify the kind of structured output requested from the
+// provider. They match the names declared in the embedded JSON schemas.
+const (
+    schemaWorkspaceChangeProposal = "workspace_change_proposal"
+    schemaModuleContext           = "module_context_schema"
+    schemaModuleExternalContext   = "module_external_context"
+    schemaChatReply               = "chat_reply"
+)
+
+// historyKey renders a conversation as the user component of cassette and
+// cache keys.
+func historyKey(history []payload.Message) string {
+    b, _ := json.Marshal(history)
+    return string(b)
+}
+
+// Environment variables that override the cassette section of
+// .vyb/config.yaml. They are mostly useful for commands such as `vyb init`
+// that run before a configuration file exists, and for end-to-end tests.
+const (
+    cassetteModeEnv = "VYB_CASSETTE_MODE"
+    cassetteDirEnv  = "VYB_CASSETTE_DIR"
+)
+
+// ErrCassetteMiss is returned in replay mode when no response was recorded
+// for a request.
+var ErrCassetteMiss = errors.New("cassette: no recorded response for request")
+
+// cassette stores request/response pairs as JSON files named after a hash
+// of the request, so a recorded session can be replayed deterministically.
+type cassette struct {
+    dir string
+}
+
+// cassetteEntry is the on-disk representation of a recorded call. The
+// request fields are persisted alongside the response so recordings can be
+// inspected (and diffed) by humans.
+type cassetteEntry struct {
+    Provider string          `json:"provider,omitempty"`
+    Schema   string          `json:"schema"`
+    Model    string          `json:"model"`
+    System   string          `json:"system"`
+    User     string          `json:"user"`
+    Response json.RawMessage `json:"response"`
+}
+
+// cassetteFor returns the cassette configured through cfg and the
+// environment, or a nil cassette when record/replay is disabled.
+func cassetteFor(cfg *config.Config) (*cassette, config.CassetteMode, error) {
+    var mode config.CassetteMode
+    var dir string
+    if cfg.Cassette != nil {
+        mode, dir = cfg.Cassette.Mode, cfg.Cassette.Dir
+    }
+    if env := os.Getenv(cassetteModeEnv); env != "" {
+        mode = config.CassetteMode(strings.ToLower(env))
+    }
+    if env := os.Getenv(cassetteDirEnv); env != "" {
+        dir = env
+    }
+
+    switch mode {
+    case config.CassetteOff:
+        return nil, mode, nil
+    case config.CassetteRecord, config.CassetteReplay:
+        if dir == "" {
+            return nil, mode, fmt.Errorf("cassette: mode %q requires a directory", mode)
+        }
+        return &cassette{dir: dir}, mode, nil
+    default:
+        return nil, mode, fmt.Errorf("cassette: unknown mode %q", mode)
+    }
+}
+
+// key derives the file name for a request. Every field is length-prefixed
+// by the JSON encoding so different splits of the same text never collide.
+func (c *cassette) key(schema, model, sysMsg, userMsg string) string {
+    b, _ := json.Marshal([]string{schema, model, sysMsg, userMsg})
+    sum := sha256.Sum256(b)
+    return hex.EncodeToString(sum[:])
+}
+
+func (c *cassette) path(key string) string {
+    return filepath.Join(c.dir, key+".json")
+}
+
+func (c *cassette) load(schema, model, sysMsg, userMsg string, v any) error {
+    key := c.key(schema, model, sysMsg, userMsg)
+    data, err := os.ReadFile(c.path(key))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return fmt.Errorf("%w (schema=%s model=%s key=%s)", ErrCassetteMiss, schema, model, key)
+        }
+        return fmt.Errorf("cassette: failed to read %s: %w", c.path(key), err)
+    }
+    var entry cassetteEntry
+    if err := json.Unmarshal(data, &entry); err != nil {
+        return fmt.Errorf("cassette: failed to parse %s: %w", c.path(key), err)
+    }
+    if err := json.Unmarshal(entry.Response, v); err != nil {
+        return fmt.Errorf("cassette: failed to decode response in %s: %w", c.path(key), err)
+    }
+    return nil
+}
+
+func (c *cassette) store(entry cassetteEntry) error {
+    if err := os.MkdirAll(c.dir, 0755); err != nil {
+        return fmt.Errorf("cassette: failed to create %s: %w", c.dir, err)
+    }
+    data, err := json.MarshalIndent(entry, "", "  ")
+    if err != nil {
+        return err
+    }
+    key := c.key(entry.Schema, entry.Model, entry.System, entry.User)
+    if err := os.WriteFile(c.path(key), data, 0644); err != nil {
+        return fmt.Errorf("cassette: failed to write %s: %w", c.path(key), err)
+    }
+    return nil
+}
+
+// modelKey renders the model every provider of the chain resolves the
+// (family,size) pair to, and the generation parameters when any is set, so
+// that remapping a model never serves the recordings of another one. A
+// provider that cannot resolve the pair, such as a plugin left to pick its
+// own model, is rendered with the pair itself. Cache entries are keyed the
+// same way.
+func modelKey(cfg *config.Config, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams) string {
+    var models []string
+    for _, name := range cfg.ProviderChain() {
+        id := fmt.Sprintf("%s/%s", fam, sz)
+        if m, err := resolveModel(cfg, name, fam, sz); err == nil {
+            id = m.ID
+        }
+        models = append(models, name+"="+id)
+    }
+    key := strings.Join(models, ",")
+    if !gen.IsZero() {
+        b, _ := json.Marshal(gen)
+        key += " " + string(b)
+    }
+    return key
+}
+
+// record forwards the call and stores its result under the request hash.
+// Failing to store the response never fails the call, which was already
+// answered (and paid for).
+func record[T any](c *cassette, providerName, schema, model, sysMsg, userMsg string, call func() (*T, error)) (*T, error) {
+    out, err := call()
+    if err != nil {
+        return nil, err
+    }
+    resp, err := json.Marshal(out)
+    if err != nil {
+        fmt.Printf("warning: failed to record LLM response: %v\n", err)
+        return out, nil
+    }
+    entry := cassetteEntry{
+        Provider: providerName,
+        Schema:   schema,
+        Model:    model,
+        System:   sysMsg,
+        User:     userMsg,
+        Response: resp,
+    }
+    if err := c.store(entry); err != nil {
+        fmt.Printf("warning: failed to record LLM response: %v\n", err)
+    }
+    return out, nil
+}
+
+// replay serves the call from the cassette.
+func replay[T any](c *cassette, schema, model, sysMsg, userMsg string) (*T, error) {
+    var out T
+    if err := c.load(schema, model, sysMsg, userMsg, &out); err != nil {
+        return nil, err
+    }
+    return &out, nil
+}
+
+// recordingProvider decorates a provider, storing every successful
+// response in the cassette.
+type recordingProvider struct {
+    inner    Provider
+    name     string
+    cfg      *config.Config
+    cassette *cassette
+}
+
+func (p *recordingProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+    return record(p.cassette, p.name, schemaWorkspaceChangeProposal, modelKey(p.cfg, fam, sz, gen), sysMsg, userMsg, func() (*payload.WorkspaceChangeProposal, error) {
+        return p.inner.GetWorkspaceChangeProposals(ctx, fam, sz, gen, sysMsg, userMsg)
+    })
+}
+
+func (p *recordingProvider) GetModuleContext(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    return record(p.cassette, p.name, schemaModuleContext, modelKey(p.cfg, annotationFamily, annotationSize, config.GenerationParams{}), sysMsg, userMsg, func() (*payload.ModuleSelfContainedContext, error) {
+        return p.inner.GetModuleContext(ctx, sysMsg, userMsg)
+    })
+}
+
+func (p *recordingProvider) GetModuleExternalContexts(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
+    return record(p.cassette, p.name, schemaModuleExternalContext, modelKey(p.cfg, annotationFamily, annotationSize, config.GenerationParams{}), sysMsg, userMsg, func() (*payload.ModuleExternalContextResponse, error) {
+        return p.inner.GetModuleExternalContexts(ctx, sysMsg, userMsg)
+    })
+}
+
+func (p *recordingProvider) GetChatReply(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg string, history []payload.Message) (*payload.ChatReply, error) {
+    return record(p.cassette, p.name, schemaChatReply, modelKey(p.cfg, fam, sz, gen), sysMsg, historyKey(history), func() (*payload.ChatReply, error) {
+        return p.inner.GetChatReply(ctx, fam, sz, gen, sysMsg, history)
+    })
+}
+
+// replayProvider answers every call from the cassette, without network
+// access or credentials.
+type replayProvider struct {
+    cfg      *config.Config
+    cassette *cassette
+}
+
+func (p *replayProvider) GetWorkspaceChangeProposals(_ context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+    return replay[payload.WorkspaceChangeProposal](p.cassette, schemaWorkspaceChangeProposal, modelKey(p.cfg, fam, sz, gen), sysMsg, userMsg)
+}
+
+func (p *replayProvider) GetModuleContext(_ context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    return replay[payload.ModuleSelfContainedContext](p.cassette, schemaModuleContext, modelKey(p.cfg, annotationFamily, annotationSize, config.GenerationParams{}), sysMsg, userMsg)
+}
+
+func (p *replayProvider) GetModuleExternalContexts(_ context.Context, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
+    return replay[payload.ModuleExternalContextResponse](p.cassette, schemaModuleExternalContext, modelKey(p.cfg, annotationFamily, annotationSize, config.GenerationParams{}), sysMsg, userMsg)
+}
+
+func (p *replayProvider) GetChatReply(_ context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg string, history []payload.Message) (*payload.ChatReply, error) {
+    return replay[payload.ChatReply](p.cassette, schemaChatReply, modelKey(p.cfg, fam, sz, gen), sysMsg, historyKey(history))
+}
@@ -0,0 +1,160 @@
+package llm
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/payload"
+)
+
+// fakeProvider returns canned responses and counts the calls it receives.
+type fakeProvider struct {
+    calls    int
+    proposal *payload.WorkspaceChangeProposal
+    ctx      *payload.ModuleSelfContainedContext
+    ext      *payload.ModuleExternalContextResponse
+    reply    *payload.ChatReply
+    err      error
+}
+
+func (f *fakeProvider) GetWorkspaceChangeProposals(_ context.Context, _ config.ModelFamily, _ config.ModelSize, _ config.GenerationParams, _, _ string) (*payload.WorkspaceChangeProposal, error) {
+    f.calls++
+    return f.proposal, f.err
+}
+
+func (f *fakeProvider) GetModuleContext(_ context.Context, _, _ string) (*payload.ModuleSelfContainedContext, error) {
+    f.calls++
+    return f.ctx, f.err
+}
+
+func (f *fakeProvider) GetModuleExternalContexts(_ context.Context, _, _ string) (*payload.ModuleExternalContextResponse, error) {
+    f.calls++
+    return f.ext, f.err
+}
+
+func (f *fakeProvider) GetChatReply(_ context.Context, _ config.ModelFamily, _ config.ModelSize, _ config.GenerationParams, _ string, _ []payload.Message) (*payload.ChatReply, error) {
+    f.calls++
+    return f.reply, f.err
+}
+
+func TestCassette_RecordThenReplay(t *testing.T) {
+    c := &cassette{dir: t.TempDir()}
+    inner := &fakeProvider{
+        proposal: &payload.WorkspaceChangeProposal{
+            Summary:   "s",
+            Proposals: []payload.FileChangeProposal{{FileName: "a.go", Content: "package a"}},
+        },
+        ctx: &payload.ModuleSelfContainedContext{InternalContext: "i", PublicContext: "p"},
+        ext: &payload.ModuleExternalContextResponse{Modules: []payload.ModuleExternalContext{{Name: "m", ExternalContext: "e"}}},
+    }
+    cfg := &config.Config{Provider: "openai"}
+    rec := &recordingProvider{inner: inner, name: "openai", cfg: cfg, cassette: c}
+
+    prop, err := rec.GetWorkspaceChangeProposals(context.Background(), config.ModelFamilyGPT, config.ModelSizeLarge, config.GenerationParams{}, "sys", "usr")
+    if err != nil {
+        t.Fatalf("unexpected error recording: %v", err)
+    }
+    if _, err := rec.GetModuleContext(context.Background(), "sys", "usr"); err != nil {
+        t.Fatalf("unexpected error recording: %v", err)
+    }
+    if _, err := rec.GetModuleExternalContexts(context.Background(), "sys", "usr"); err != nil {
+        t.Fatalf("unexpected error recording: %v", err)
+    }
+
+    rep := &replayProvider{cfg: cfg, cassette: c}
+    got, err := rep.GetWorkspaceChangeProposals(context.Background(), config.ModelFamilyGPT, config.ModelSizeLarge, config.GenerationParams{}, "sys", "usr")
+    if err != nil {
+        t.Fatalf("unexpected error replaying: %v", err)
+    }
+    if !reflect.DeepEqual(got, prop) {
+        t.Fatalf("replayed proposal = %+v, want %+v", got, prop)
+    }
+    gotCtx, err := rep.GetModuleContext(context.Background(), "sys", "usr")
+    if err != nil || !reflect.DeepEqual(gotCtx, inner.ctx) {
+        t.Fatalf("replayed ctx = %+v (err %v), want %+v", gotCtx, err, inner.ctx)
+    }
+    gotExt, err := rep.GetModuleExternalContexts(context.Background(), "sys", "usr")
+    if err != nil || !reflect.DeepEqual(gotExt, inner.ext) {
+        t.Fatalf("replayed ext = %+v (err %v), want %+v", gotExt, err, inner.ext)
+    }
+    if inner.calls != 3 {
+        t.Fatalf("inner provider called %d times, want 3", inner.calls)
+    }
+
+    // Any difference in the request is a miss.
+    if _, err := rep.GetWorkspaceChangeProposals(context.Background(), config.ModelFamilyGPT, config.ModelSizeSmall, config.GenerationParams{}, "sys", "usr"); !errors.Is(err, ErrCassetteMiss) {
+        t.Fatalf("expected ErrCassetteMiss for a different model, got %v", err)
+    }
+    if _, err := rep.GetModuleContext(context.Background(), "sys", "other"); !errors.Is(err, ErrCassetteMiss) {
+        t.Fatalf("expected ErrCassetteMiss for a different user message, got %v", err)
+    }
+
+    // Remapping the annotation model is a miss as well.
+    remapped := &config.Config{Provider: "openai", Models: map[string]*config.ProviderModels{"openai": {Map: map[config.ModelFamily]map[config.ModelSize]string{annotationFamily: {annotationSize: "o3"}}}}}
+    rep = &replayProvider{cfg: remapped, cassette: c}
+    if _, err := rep.GetModuleContext(context.Background(), "sys", "usr"); !errors.Is(err, ErrCassetteMiss) {
+        t.Fatalf("expected ErrCassetteMiss for a remapped model, got %v", err)
+    }
+}
+
+func TestCassette_StoreFailureKeepsResponse(t *testing.T) {
+    // the cassette directory cannot be created below a regular file.
+    file := filepath.Join(t.TempDir(), "file")
+    if err := os.WriteFile(file, nil, 0644); err != nil {
+        t.Fatal(err)
+    }
+    inner := &fakeProvider{ctx: &payload.ModuleSelfContainedContext{PublicContext: "p"}}
+    rec := &recordingProvider{inner: inner, name: "openai", cfg: &config.Config{Provider: "openai"}, cassette: &cassette{dir: filepath.Join(file, "cassettes")}}
+
+    got, err := rec.GetModuleContext(context.Background(), "sys", "usr")
+    if err != nil || !reflect.DeepEqual(got, inner.ctx) {
+        t.Fatalf("got %+v (err %v), want the response despite the failed store", got, err)
+    }
+}
+
+func TestCassette_FailedCallsAreNotRecorded(t *testing.T) {
+    c := &cassette{dir: t.TempDir()}
+    rec := &recordingProvider{inner: &fakeProvider{err: errors.New("boom")}, name: "openai", cfg: &config.Config{Provider: "openai"}, cassette: c}
+
+    if _, err := rec.GetModuleContext(context.Background(), "sys", "usr"); err == nil {
+        t.Fatalf("expected error to be propagated")
+    }
+    entries, _ := os.ReadDir(c.dir)
+    if len(entries) != 0 {
+        t.Fatalf("expected no recordings, found %d", len(entries))
+    }
+}
+
+func TestResolveProvider_Cassette(t *testing.T) {
+    dir := t.TempDir()
+
+    p, err := resolveProvider(&config.Config{Provider: "openai", Cassette: &config.CassetteConfig{Mode: config.CassetteRecord, Dir: dir}})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if _, ok := p.(*recordingProvider); !ok {
+        t.Fatalf("expected *recordingProvider, got %T", p)
+    }
+
+    // Replay never instantiates the configured provider, so even an unknown
+    // provider name is accepted.
+    t.Setenv(cassetteModeEnv, "replay")
+    t.Setenv(cassetteDirEnv, dir)
+    p, err = resolveProvider(&config.Config{Provider: "fooai"})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if _, ok := p.(*replayProvider); !ok {
+        t.Fatalf("expected *replayProvider, got %T", p)
+    }
+
+    t.Setenv(cassetteModeEnv, "rewind")
+    if _, err := resolveProvider(&config.Config{Provider: "openai"}); err == nil {
+        t.Fatalf("expected error for unknown cassette mode")
+    }
+}
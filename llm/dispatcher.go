@@ -1,105 +1,273 @@
 package llm
 
 import (
+    "context"
     "fmt"
     "strings"
 
     "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/internal/anthropic"
     "github.com/vybdev/vyb/llm/internal/gemini"
     "github.com/vybdev/vyb/llm/internal/openai"
+    "github.com/vybdev/vyb/llm/internal/plugin"
     "github.com/vybdev/vyb/llm/payload"
 )
 
-// provider captures the common operations expected from any LLM backend.
-// It is intentionally unexported so that the public surface of the llm
-// package stays minimal while allowing internal dispatch based on user
-// configuration.
+// Provider captures the common operations expected from any LLM backend.
+// Backends other than the built-in ones implement it and are made
+// available with RegisterProvider.
+//
+// Implementations must honour the cancellation of ctx. GetModuleContext
+// and GetModuleExternalContexts pick the model used to annotate modules on
+// their own; the other methods get the family and size requested by the
+// command, and the generation parameters of its template.
 //
 // Additional methods should be appended here whenever new high-level
 // helpers are added to the llm façade.
-type provider interface {
-    GetWorkspaceChangeProposals(fam config.ModelFamily, sz config.ModelSize, systemMessage, userMessage string) (*payload.WorkspaceChangeProposal, error)
-    GetModuleContext(systemMessage, userMessage string) (*payload.ModuleSelfContainedContext, error)
-    GetModuleExternalContexts(systemMessage, userMessage string) (*payload.ModuleExternalContextResponse, error)
+type Provider interface {
+    GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, systemMessage, userMessage string) (*payload.WorkspaceChangeProposal, error)
+    GetModuleContext(ctx context.Context, systemMessage, userMessage string) (*payload.ModuleSelfContainedContext, error)
+    GetModuleExternalContexts(ctx context.Context, systemMessage, userMessage string) (*payload.ModuleExternalContextResponse, error)
+    // GetChatReply continues a conversation. history holds every turn so
+    // far, oldest first, and ends with the user's latest message.
+    GetChatReply(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, systemMessage string, history []payload.Message) (*payload.ChatReply, error)
 }
 
-type openAIProvider struct{}
+// The adapters below resolve the (family,size) pair through the model table
+// and delegate to the provider packages. Module annotations always use
+// annotationFamily/annotationSize.
 
-type geminiProvider struct{}
+type openAIProvider struct {
+    cfg *config.Config
+}
 
-func (*openAIProvider) GetWorkspaceChangeProposals(fam config.ModelFamily, sz config.ModelSize, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
-    return openai.GetWorkspaceChangeProposals(fam, sz, sysMsg, userMsg)
+type geminiProvider struct {
+    cfg *config.Config
 }
 
-func (*openAIProvider) GetModuleContext(sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
-    return openai.GetModuleContext(sysMsg, userMsg)
+type anthropicProvider struct {
+    cfg *config.Config
 }
 
-func (*openAIProvider) GetModuleExternalContexts(sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
-    return openai.GetModuleExternalContexts(sysMsg, userMsg)
+// pluginProvider delegates to the exec plugin configured under name. The
+// model id is optional for plugins: when the user did not map the pair,
+// the plugin receives the family and size and picks a model on its own.
+type pluginProvider struct {
+    name string
+    cfg  *config.Config
 }
 
-// -----------------------------------------------------------------------------
-//  Gemini provider implementation – WorkspaceChangeProposals hooked up
-// -----------------------------------------------------------------------------
+func (p *openAIProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+    m, err := resolveModel(p.cfg, "openai", fam, sz)
+    if err != nil {
+        return nil, err
+    }
+    return openai.GetWorkspaceChangeProposals(ctx, p.cfg.OpenAI, m.ID, m.Params, gen, sysMsg, userMsg)
+}
 
-func mapGeminiModel(fam config.ModelFamily, sz config.ModelSize) (string, error) {
-    switch sz {
-    case config.ModelSizeSmall:
-        return "gemini-2.5-flash-preview-05-20", nil
-    case config.ModelSizeLarge:
-        return "gemini-2.5-pro-preview-06-05", nil
-    default:
-        return "", fmt.Errorf("gemini: unsupported model size %s", sz)
+func (p *openAIProvider) GetModuleContext(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    m, err := resolveModel(p.cfg, "openai", annotationFamily, annotationSize)
+    if err != nil {
+        return nil, err
+    }
+    return openai.GetModuleContext(ctx, p.cfg.OpenAI, m.ID, m.Params, sysMsg, userMsg)
+}
+
+func (p *openAIProvider) GetModuleExternalContexts(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
+    m, err := resolveModel(p.cfg, "openai", annotationFamily, annotationSize)
+    if err != nil {
+        return nil, err
+    }
+    return openai.GetModuleExternalContexts(ctx, p.cfg.OpenAI, m.ID, m.Params, sysMsg, userMsg)
+}
+
+func (p *openAIProvider) GetChatReply(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg string, history []payload.Message) (*payload.ChatReply, error) {
+    m, err := resolveModel(p.cfg, "openai", fam, sz)
+    if err != nil {
+        return nil, err
+    }
+    return openai.GetChatReply(ctx, p.cfg.OpenAI, m.ID, m.Params, gen, sysMsg, history)
+}
+
+func (p *geminiProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+    m, err := resolveModel(p.cfg, "gemini", fam, sz)
+    if err != nil {
+        return nil, err
+    }
+    return gemini.GetWorkspaceChangeProposals(ctx, m.ID, m.Params, gen, sysMsg, userMsg)
+}
+
+func (p *geminiProvider) GetModuleContext(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    m, err := resolveModel(p.cfg, "gemini", annotationFamily, annotationSize)
+    if err != nil {
+        return nil, err
+    }
+    return gemini.GetModuleContext(ctx, m.ID, m.Params, sysMsg, userMsg)
+}
+
+func (p *geminiProvider) GetModuleExternalContexts(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
+    m, err := resolveModel(p.cfg, "gemini", annotationFamily, annotationSize)
+    if err != nil {
+        return nil, err
+    }
+    return gemini.GetModuleExternalContexts(ctx, m.ID, m.Params, sysMsg, userMsg)
+}
+
+func (p *geminiProvider) GetChatReply(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg string, history []payload.Message) (*payload.ChatReply, error) {
+    m, err := resolveModel(p.cfg, "gemini", fam, sz)
+    if err != nil {
+        return nil, err
+    }
+    return gemini.GetChatReply(ctx, m.ID, m.Params, gen, sysMsg, history)
+}
+
+func (p *anthropicProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+    m, err := resolveModel(p.cfg, "anthropic", fam, sz)
+    if err != nil {
+        return nil, err
+    }
+    return anthropic.GetWorkspaceChangeProposals(ctx, m.ID, m.Params, gen, sysMsg, userMsg)
+}
+
+func (p *anthropicProvider) GetModuleContext(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    m, err := resolveModel(p.cfg, "anthropic", annotationFamily, annotationSize)
+    if err != nil {
+        return nil, err
+    }
+    return anthropic.GetModuleContext(ctx, m.ID, m.Params, sysMsg, userMsg)
+}
+
+func (p *anthropicProvider) GetModuleExternalContexts(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
+    m, err := resolveModel(p.cfg, "anthropic", annotationFamily, annotationSize)
+    if err != nil {
+        return nil, err
     }
+    return anthropic.GetModuleExternalContexts(ctx, m.ID, m.Params, sysMsg, userMsg)
 }
 
-func (*geminiProvider) GetWorkspaceChangeProposals(fam config.ModelFamily, sz config.ModelSize, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
-    return gemini.GetWorkspaceChangeProposals(fam, sz, sysMsg, userMsg)
+func (p *anthropicProvider) GetChatReply(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg string, history []payload.Message) (*payload.ChatReply, error) {
+    m, err := resolveModel(p.cfg, "anthropic", fam, sz)
+    if err != nil {
+        return nil, err
+    }
+    return anthropic.GetChatReply(ctx, m.ID, m.Params, gen, sysMsg, history)
 }
 
-func (*geminiProvider) GetModuleContext(sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
-    return gemini.GetModuleContext(sysMsg, userMsg)
+func (p *pluginProvider) model(fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams) plugin.Model {
+    id := p.cfg.ModelOverride(p.name, fam, sz)
+    m := plugin.Model{Family: string(fam), Size: string(sz), ID: id, Params: p.cfg.ModelParams(p.name, id)}
+    if !gen.IsZero() {
+        m.Generation = &gen
+    }
+    return m
 }
 
-func (*geminiProvider) GetModuleExternalContexts(sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
-    return gemini.GetModuleExternalContexts(sysMsg, userMsg)
+func (p *pluginProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+    return plugin.GetWorkspaceChangeProposals(ctx, p.name, p.cfg.Plugin(p.name), p.model(fam, sz, gen), sysMsg, userMsg)
+}
+
+func (p *pluginProvider) GetModuleContext(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    return plugin.GetModuleContext(ctx, p.name, p.cfg.Plugin(p.name), p.model(annotationFamily, annotationSize, config.GenerationParams{}), sysMsg, userMsg)
+}
+
+func (p *pluginProvider) GetModuleExternalContexts(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
+    return plugin.GetModuleExternalContexts(ctx, p.name, p.cfg.Plugin(p.name), p.model(annotationFamily, annotationSize, config.GenerationParams{}), sysMsg, userMsg)
+}
+
+func (p *pluginProvider) GetChatReply(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg string, history []payload.Message) (*payload.ChatReply, error) {
+    return plugin.GetChatReply(ctx, p.name, p.cfg.Plugin(p.name), p.model(fam, sz, gen), sysMsg, history)
 }
 
 // -----------------------------------------------------------------------------
 //  Public façade helpers remain unchanged (dispatcher section).
 // -----------------------------------------------------------------------------
 
-func GetModuleExternalContexts(cfg *config.Config, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
+// GetModuleExternalContexts asks the configured provider for the external
+// context of every module described in userMsg.
+func GetModuleExternalContexts(ctx context.Context, cfg *config.Config, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
     if provider, err := resolveProvider(cfg); err != nil {
         return nil, err
     } else {
-        return provider.GetModuleExternalContexts(sysMsg, userMsg)
+        return provider.GetModuleExternalContexts(ctx, sysMsg, userMsg)
     }
 }
 
-func GetModuleContext(cfg *config.Config, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+// GetModuleContext asks the configured provider for the internal and
+// public context of the module described in userMsg.
+func GetModuleContext(ctx context.Context, cfg *config.Config, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
     if provider, err := resolveProvider(cfg); err != nil {
         return nil, err
     } else {
-        return provider.GetModuleContext(sysMsg, userMsg)
+        return provider.GetModuleContext(ctx, sysMsg, userMsg)
     }
 }
-func GetWorkspaceChangeProposals(cfg *config.Config, fam config.ModelFamily, sz config.ModelSize, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+
+// GetWorkspaceChangeProposals asks the configured provider for a set of
+// file changes using the model resolved from (fam, sz).
+//
+// Cancelling ctx aborts the in-flight request and any pending retry.
+func GetWorkspaceChangeProposals(ctx context.Context, cfg *config.Config, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+    if provider, err := resolveProvider(cfg); err != nil {
+        return nil, err
+    } else {
+        return provider.GetWorkspaceChangeProposals(ctx, fam, sz, gen, sysMsg, userMsg)
+    }
+}
+
+// GetChatReply asks the configured provider to continue a conversation.
+// history holds every turn so far, oldest first, and must end with the
+// user's latest message; the reply either answers in prose or proposes
+// workspace changes.
+func GetChatReply(ctx context.Context, cfg *config.Config, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg string, history []payload.Message) (*payload.ChatReply, error) {
     if provider, err := resolveProvider(cfg); err != nil {
         return nil, err
     } else {
-        return provider.GetWorkspaceChangeProposals(fam, sz, sysMsg, userMsg)
+        return provider.GetChatReply(ctx, fam, sz, gen, sysMsg, history)
     }
 }
 
-func resolveProvider(cfg *config.Config) (provider, error) {
-    switch strings.ToLower(cfg.Provider) {
-    case "openai":
-        return &openAIProvider{}, nil
-    case "gemini":
-        return &geminiProvider{}, nil
+// resolveProvider returns the provider configured in cfg, decorated with
+// the shared retry policy, schema repair, the response cache, the shared
+// HTTP client and credential sources, and with the cassette recorder when
+// record mode is on. When fallback providers are configured,
+// each provider of the chain retries on its own before the next one is
+// tried. In replay mode the configured providers are never instantiated.
+func resolveProvider(cfg *config.Config) (Provider, error) {
+    c, mode, err := cassetteFor(cfg)
+    if err != nil {
+        return nil, err
+    }
+    if mode == config.CassetteReplay {
+        return &replayProvider{cfg: cfg, cassette: c}, nil
+    }
+
+    client, err := httpClientFor(cfg.HTTP)
+    if err != nil {
+        return nil, err
+    }
+    var chain []namedProvider
+    for _, name := range cfg.ProviderChain() {
+        inner, err := newProvider(name, cfg)
+        if err != nil {
+            return nil, err
+        }
+        inner = &contextProvider{inner: inner, client: client, credentials: cfg.Credentials}
+        // Every attempt, retries included, is charged to the rate limits.
+        p := newRetryingProvider(inner, cfg.Retry, cfg.RequestTimeout, limiterFor(name, cfg.RateLimit(name)))
+        chain = append(chain, namedProvider{name: name, Provider: &repairingProvider{inner: p}})
+    }
+    var p Provider
+    switch len(chain) {
+    case 0:
+        return nil, fmt.Errorf("no provider configured")
+    case 1:
+        p = chain[0].Provider
     default:
-        return nil, fmt.Errorf("unknown provider: %s", cfg.Provider)
+        p = &fallbackProvider{chain: chain}
+    }
+    p = &cachingProvider{inner: p, cfg: cfg, namespace: cacheNamespace(cfg)}
+    if mode == config.CassetteRecord {
+        return &recordingProvider{inner: p, name: strings.Join(cfg.ProviderChain(), ","), cfg: cfg, cassette: c}, nil
     }
+    return p, nil
 }
@@ -1,40 +1,115 @@
 package llm
 
 import (
+    "strings"
     "testing"
 
     "github.com/vybdev/vyb/config"
 )
 
-// TestMapGeminiModel ensures that the (family,size) tuple is translated to
-// the correct concrete model identifier and that unsupported sizes are
-// properly rejected.
-func TestMapGeminiModel(t *testing.T) {
+// TestResolveModel_Defaults ensures that the (family,size) tuple is
+// translated to the correct concrete model identifier and that unmapped
+// pairs are properly rejected.
+func TestResolveModel_Defaults(t *testing.T) {
     t.Parallel()
 
     cases := []struct {
-        fam  config.ModelFamily
-        size config.ModelSize
-        want string
+        provider string
+        fam      config.ModelFamily
+        size     config.ModelSize
+        want     string
     }{
-        {config.ModelFamilyGPT, config.ModelSizeSmall, "gemini-2.5-flash-preview-05-20"},
-        {config.ModelFamilyGPT, config.ModelSizeLarge, "gemini-2.5-pro-preview-06-05"},
-        {config.ModelFamilyReasoning, config.ModelSizeSmall, "gemini-2.5-flash-preview-05-20"},
-        {config.ModelFamilyReasoning, config.ModelSizeLarge, "gemini-2.5-pro-preview-06-05"},
+        {"gemini", config.ModelFamilyGPT, config.ModelSizeSmall, "gemini-2.5-flash-preview-05-20"},
+        {"gemini", config.ModelFamilyGPT, config.ModelSizeLarge, "gemini-2.5-pro-preview-06-05"},
+        {"gemini", config.ModelFamilyReasoning, config.ModelSizeSmall, "gemini-2.5-flash-preview-05-20"},
+        {"gemini", config.ModelFamilyReasoning, config.ModelSizeLarge, "gemini-2.5-pro-preview-06-05"},
+        {"openai", config.ModelFamilyReasoning, config.ModelSizeSmall, "o4-mini"},
+        {"anthropic", config.ModelFamilyReasoning, config.ModelSizeLarge, "claude-opus-4-1"},
     }
 
     for _, c := range cases {
-        got, err := mapGeminiModel(c.fam, c.size)
+        got, err := resolveModel(config.Default(), c.provider, c.fam, c.size)
         if err != nil {
-            t.Fatalf("mapGeminiModel(%s,%s) returned unexpected error: %v", c.fam, c.size, err)
+            t.Fatalf("resolveModel(%s,%s,%s) returned unexpected error: %v", c.provider, c.fam, c.size, err)
         }
-        if got != c.want {
-            t.Fatalf("mapGeminiModel(%s,%s) = %q, want %q", c.fam, c.size, got, c.want)
+        if got.ID != c.want {
+            t.Fatalf("resolveModel(%s,%s,%s) = %q, want %q", c.provider, c.fam, c.size, got.ID, c.want)
         }
     }
 
     // Ensure an unsupported size triggers an error.
-    if _, err := mapGeminiModel(config.ModelFamilyGPT, config.ModelSize("medium")); err == nil {
+    if _, err := resolveModel(config.Default(), "gemini", config.ModelFamilyGPT, config.ModelSize("medium")); err == nil {
         t.Fatalf("expected error for unsupported model size, got nil")
     }
 }
+
+func TestResolveModel_Overrides(t *testing.T) {
+    cfg := &config.Config{
+        Provider: "gemini",
+        Models: map[string]*config.ProviderModels{
+            "gemini": {
+                Map: map[config.ModelFamily]map[config.ModelSize]string{
+                    config.ModelFamilyReasoning: {config.ModelSizeLarge: "gemini-2.5-pro"},
+                    "fast":                      {config.ModelSizeSmall: "gemini-2.5-flash-lite"},
+                },
+                Params: map[string]map[string]any{
+                    "gemini-2.5-pro": {"generationConfig": map[string]any{"temperature": 0.1}},
+                },
+            },
+        },
+        OpenAI: &config.OpenAIConfig{Models: map[config.ModelFamily]map[config.ModelSize]string{
+            config.ModelFamilyGPT: {config.ModelSizeLarge: "qwen3:32b"},
+        }},
+    }
+
+    got, err := resolveModel(cfg, "gemini", config.ModelFamilyReasoning, config.ModelSizeLarge)
+    if err != nil || got.ID != "gemini-2.5-pro" || got.Params["generationConfig"] == nil {
+        t.Fatalf("override not applied: %+v (err %v)", got, err)
+    }
+    if got, err := resolveModel(cfg, "gemini", "fast", config.ModelSizeSmall); err != nil || got.ID != "gemini-2.5-flash-lite" {
+        t.Fatalf("custom family not resolved: %+v (err %v)", got, err)
+    }
+    // Pairs that are not overridden keep the built-in defaults.
+    if got, _ := resolveModel(cfg, "gemini", config.ModelFamilyGPT, config.ModelSizeSmall); got.ID != "gemini-2.5-flash-preview-05-20" {
+        t.Fatalf("expected default model, got %q", got.ID)
+    }
+    // The legacy openai.models section is still honoured.
+    if got, _ := resolveModel(cfg, "openai", config.ModelFamilyGPT, config.ModelSizeLarge); got.ID != "qwen3:32b" {
+        t.Fatalf("expected openai.models override, got %q", got.ID)
+    }
+
+    _, err = resolveModel(cfg, "anthropic", "fast", config.ModelSizeSmall)
+    if err == nil || !strings.Contains(err.Error(), "reasoning") {
+        t.Fatalf("expected an error listing the known families, got %v", err)
+    }
+}
+
+func TestResolveModel_AzureDeployments(t *testing.T) {
+    cfg := &config.Config{
+        Provider: "openai",
+        Models: map[string]*config.ProviderModels{
+            "openai": {Map: map[config.ModelFamily]map[config.ModelSize]string{
+                config.ModelFamilyReasoning: {config.ModelSizeLarge: "o3-pro"},
+            }},
+        },
+        OpenAI: &config.OpenAIConfig{Azure: &config.AzureOpenAIConfig{
+            Endpoint: "https://r.openai.azure.com",
+            Deployments: map[config.ModelFamily]map[config.ModelSize]string{
+                config.ModelFamilyReasoning: {config.ModelSizeLarge: "o3-prod"},
+                "fast":                      {config.ModelSizeSmall: "mini-prod"},
+            },
+        }},
+    }
+
+    // Deployments take precedence over the models section.
+    if got, _ := resolveModel(cfg, "openai", config.ModelFamilyReasoning, config.ModelSizeLarge); got.ID != "o3-prod" {
+        t.Fatalf("expected the deployment, got %q", got.ID)
+    }
+    if got, err := resolveModel(cfg, "openai", "fast", config.ModelSizeSmall); err != nil || got.ID != "mini-prod" {
+        t.Fatalf("custom family not resolved: %+v (err %v)", got, err)
+    }
+    // Unmapped pairs use the model name as deployment name.
+    if got, _ := resolveModel(cfg, "openai", config.ModelFamilyGPT, config.ModelSizeSmall); got.ID != "GPT-4.1-mini" {
+        t.Fatalf("expected default model, got %q", got.ID)
+    }
+}
@@ -0,0 +1,68 @@
+package llm
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "sync"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/internal/credentials"
+    "github.com/vybdev/vyb/llm/internal/transport"
+    "github.com/vybdev/vyb/llm/payload"
+)
+
+// contextProvider attaches the process-level settings the provider
+// packages read from the context to every call: the HTTP client built from
+// the http section of .vyb/config.yaml, so each built-in provider sends its
+// requests through the same proxy, CAs, client certificate and headers,
+// and the credential sources of the credentials section.
+type contextProvider struct {
+    inner       Provider
+    client      *http.Client
+    credentials map[string]*config.CredentialConfig
+}
+
+func (p *contextProvider) attach(ctx context.Context) context.Context {
+    return credentials.WithSources(transport.WithClient(ctx, p.client), p.credentials)
+}
+
+func (p *contextProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+    return p.inner.GetWorkspaceChangeProposals(p.attach(ctx), fam, sz, gen, sysMsg, userMsg)
+}
+
+func (p *contextProvider) GetModuleContext(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    return p.inner.GetModuleContext(p.attach(ctx), sysMsg, userMsg)
+}
+
+func (p *contextProvider) GetModuleExternalContexts(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
+    return p.inner.GetModuleExternalContexts(p.attach(ctx), sysMsg, userMsg)
+}
+
+func (p *contextProvider) GetChatReply(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg string, history []payload.Message) (*payload.ChatReply, error) {
+    return p.inner.GetChatReply(p.attach(ctx), fam, sz, gen, sysMsg, history)
+}
+
+// clients holds one HTTP client per distinct configuration, so every call
+// made by the process reuses the same connections.
+var clients = struct {
+    sync.Mutex
+    m map[string]*http.Client
+}{m: map[string]*http.Client{}}
+
+// httpClientFor returns the process-wide client configured by cfg.
+func httpClientFor(cfg *config.HTTPConfig) (*http.Client, error) {
+    b, _ := json.Marshal(cfg)
+    key := string(b)
+    clients.Lock()
+    defer clients.Unlock()
+    if c, ok := clients.m[key]; ok {
+        return c, nil
+    }
+    c, err := transport.NewClient(cfg)
+    if err != nil {
+        return nil, err
+    }
+    clients.m[key] = c
+    return c, nil
+}
@@ -0,0 +1,101 @@
+package llm
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/internal/transport"
+    "github.com/vybdev/vyb/llm/payload"
+    "github.com/vybdev/vyb/llm/usage"
+)
+
+// namedProvider pairs a provider with the name it is configured under.
+type namedProvider struct {
+    name string
+    Provider
+}
+
+// fallbackProvider tries each provider of a chain in order, moving on to
+// the next one when a provider is unusable: out of quota, rejecting the
+// credentials, or still failing once its own retries are exhausted.
+//
+// Every fallback decision is printed, and the providers given up on are
+// recorded alongside the usage of the one that answered.
+type fallbackProvider struct {
+    chain []namedProvider
+}
+
+func (p *fallbackProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+    return withFallback(ctx, p, func(ctx context.Context, np namedProvider) (*payload.WorkspaceChangeProposal, error) {
+        return np.GetWorkspaceChangeProposals(ctx, fam, sz, gen, sysMsg, userMsg)
+    })
+}
+
+func (p *fallbackProvider) GetModuleContext(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    return withFallback(ctx, p, func(ctx context.Context, np namedProvider) (*payload.ModuleSelfContainedContext, error) {
+        return np.GetModuleContext(ctx, sysMsg, userMsg)
+    })
+}
+
+func (p *fallbackProvider) GetModuleExternalContexts(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
+    return withFallback(ctx, p, func(ctx context.Context, np namedProvider) (*payload.ModuleExternalContextResponse, error) {
+        return np.GetModuleExternalContexts(ctx, sysMsg, userMsg)
+    })
+}
+
+func (p *fallbackProvider) GetChatReply(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg string, history []payload.Message) (*payload.ChatReply, error) {
+    return withFallback(ctx, p, func(ctx context.Context, np namedProvider) (*payload.ChatReply, error) {
+        return np.GetChatReply(ctx, fam, sz, gen, sysMsg, history)
+    })
+}
+
+func withFallback[T any](ctx context.Context, p *fallbackProvider, call func(context.Context, namedProvider) (*T, error)) (*T, error) {
+    var failed []string
+    for i, np := range p.chain {
+        callCtx := ctx
+        if len(failed) > 0 {
+            callCtx = usage.WithFallbackFrom(ctx, failed)
+        }
+        out, err := call(callCtx, np)
+        if err == nil {
+            if len(failed) > 0 {
+                fmt.Printf("LLM call answered by fallback provider %s\n", np.name)
+            }
+            return out, nil
+        }
+        if i == len(p.chain)-1 || !shouldFallBack(ctx, err) {
+            return nil, err
+        }
+        fmt.Printf("LLM provider %s failed (%v), falling back to %s\n", np.name, err, p.chain[i+1].name)
+        failed = append(failed, np.name)
+    }
+    return nil, errors.New("llm: empty provider chain")
+}
+
+// shouldFallBack reports whether err makes the provider that returned it
+// unusable for this call. Errors caused by the request itself (e.g. a bad
+// request) would fail on any provider and are returned as is.
+func shouldFallBack(ctx context.Context, err error) bool {
+    if ctx.Err() != nil {
+        return false
+    }
+    var apiErr *transport.Error
+    if errors.As(err, &apiErr) {
+        switch {
+        case apiErr.QuotaExhausted,
+            apiErr.Interrupted,
+            apiErr.StatusCode == http.StatusUnauthorized,
+            apiErr.StatusCode == http.StatusForbidden,
+            apiErr.StatusCode == http.StatusTooManyRequests,
+            apiErr.StatusCode >= 500:
+            return true
+        }
+        return false
+    }
+    // Timeouts that survived the retry policy.
+    retryable, _ := classify(err)
+    return retryable
+}
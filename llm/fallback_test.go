@@ -0,0 +1,96 @@
+package llm
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "testing"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/internal/transport"
+    "github.com/vybdev/vyb/llm/payload"
+    "github.com/vybdev/vyb/llm/usage"
+)
+
+// reportingProvider reports usage under its own name, as real providers do.
+type reportingProvider struct {
+    fakeProvider
+    name string
+}
+
+func (r *reportingProvider) GetModuleContext(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    out, err := r.fakeProvider.GetModuleContext(ctx, sysMsg, userMsg)
+    if err == nil {
+        usage.Report(ctx, r.name, "m", 1, 1)
+    }
+    return out, err
+}
+
+func TestFallback_FallsThroughUnusableProviders(t *testing.T) {
+    quota := &reportingProvider{name: "gemini", fakeProvider: fakeProvider{err: &transport.Error{StatusCode: http.StatusTooManyRequests, QuotaExhausted: true, Err: errors.New("no credit")}}}
+    auth := &reportingProvider{name: "openai", fakeProvider: fakeProvider{err: &transport.Error{StatusCode: http.StatusUnauthorized, Err: errors.New("bad key")}}}
+    ok := &reportingProvider{name: "anthropic", fakeProvider: fakeProvider{ctx: &payload.ModuleSelfContainedContext{PublicContext: "p"}}}
+    p := &fallbackProvider{chain: []namedProvider{{"gemini", quota}, {"openai", auth}, {"anthropic", ok}}}
+
+    root := t.TempDir()
+    ctx := usage.WithLedger(context.Background(), usage.NewLedger(root, nil))
+    got, err := p.GetModuleContext(ctx, "sys", "usr")
+    if err != nil || got.PublicContext != "p" {
+        t.Fatalf("got %+v (err %v), want the last provider's answer", got, err)
+    }
+    if quota.calls != 1 || auth.calls != 1 || ok.calls != 1 {
+        t.Fatalf("calls = %d/%d/%d, want 1/1/1", quota.calls, auth.calls, ok.calls)
+    }
+
+    records, err := usage.Load(usage.Path(root))
+    if err != nil || len(records) != 1 {
+        t.Fatalf("expected one usage record, got %v (err %v)", records, err)
+    }
+    if r := records[0]; r.Provider != "anthropic" || len(r.FallbackFrom) != 2 || r.FallbackFrom[0] != "gemini" || r.FallbackFrom[1] != "openai" {
+        t.Fatalf("unexpected usage record: %+v", r)
+    }
+}
+
+func TestFallback_RequestErrorsAreFinal(t *testing.T) {
+    bad := &fakeProvider{err: &transport.Error{StatusCode: http.StatusBadRequest, Err: errors.New("bad request")}}
+    next := &fakeProvider{ctx: &payload.ModuleSelfContainedContext{}}
+    p := &fallbackProvider{chain: []namedProvider{{"gemini", bad}, {"openai", next}}}
+
+    if _, err := p.GetModuleContext(context.Background(), "sys", "usr"); err == nil {
+        t.Fatalf("expected the bad request error to be returned")
+    }
+    if next.calls != 0 {
+        t.Fatalf("fallback provider called %d times, want 0", next.calls)
+    }
+}
+
+func TestFallback_LastErrorIsReturned(t *testing.T) {
+    lastErr := &transport.Error{StatusCode: http.StatusServiceUnavailable, Err: errors.New("down")}
+    p := &fallbackProvider{chain: []namedProvider{
+        {"gemini", &fakeProvider{err: &transport.Error{StatusCode: http.StatusInternalServerError, Err: errors.New("boom")}}},
+        {"openai", &fakeProvider{err: lastErr}},
+    }}
+
+    if _, err := p.GetModuleContext(context.Background(), "sys", "usr"); !errors.Is(err, lastErr) {
+        t.Fatalf("expected the last provider's error, got %v", err)
+    }
+}
+
+func TestResolveProvider_FallbackChain(t *testing.T) {
+    p, err := resolveProvider(&config.Config{Provider: "gemini", Fallback: []string{"openai"}})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    cp, ok := p.(*cachingProvider)
+    if !ok {
+        t.Fatalf("expected *cachingProvider, got %T", p)
+    }
+    fp, ok := cp.inner.(*fallbackProvider)
+    if !ok || len(fp.chain) != 2 || fp.chain[0].name != "gemini" || fp.chain[1].name != "openai" {
+        t.Fatalf("unexpected fallback chain: %#v", cp.inner)
+    }
+
+    if _, err := resolveProvider(&config.Config{Provider: "gemini", Fallback: []string{"fooai"}}); err == nil {
+        t.Fatalf("expected error for unknown fallback provider")
+    }
+}
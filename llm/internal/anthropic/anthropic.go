@@ -0,0 +1,282 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/internal/anthropic/internal/schema"
+	"github.com/vybdev/vyb/llm/internal/credentials"
+	"github.com/vybdev/vyb/llm/internal/transport"
+	"github.com/vybdev/vyb/llm/internal/validate"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/llm/transcript"
+	"github.com/vybdev/vyb/llm/usage"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// GetWorkspaceChangeProposals composes the request, sends it to Anthropic
+// and converts the forced tool call into a strongly-typed
+// WorkspaceChangeProposal.
+func GetWorkspaceChangeProposals(ctx context.Context, model string, params map[string]any, gen config.GenerationParams, systemMessage, userMessage string) (*payload.WorkspaceChangeProposal, error) {
+	raw, err := callAnthropic(ctx, systemMessage, userTurn(userMessage), schema.GetWorkspaceChangeProposalTool(), model, params, gen)
+	if err != nil {
+		return nil, err
+	}
+
+	var proposal payload.WorkspaceChangeProposal
+	if err := validate.Decode("anthropic", validate.WorkspaceChangeProposal, raw, &proposal); err != nil {
+		return nil, err
+	}
+	return &proposal, nil
+}
+
+// GetModuleContext calls the LLM and returns a parsed
+// ModuleSelfContainedContext value.
+func GetModuleContext(ctx context.Context, model string, params map[string]any, systemMessage, userMessage string) (*payload.ModuleSelfContainedContext, error) {
+	raw, err := callAnthropic(ctx, systemMessage, userTurn(userMessage), schema.GetModuleContextTool(), model, params, config.GenerationParams{})
+	if err != nil {
+		return nil, err
+	}
+
+	var moduleCtx payload.ModuleSelfContainedContext
+	if err := validate.Decode("anthropic", validate.ModuleContext, raw, &moduleCtx); err != nil {
+		return nil, err
+	}
+	return &moduleCtx, nil
+}
+
+// GetModuleExternalContexts calls the LLM and returns a list of external
+// context strings – one per module.
+func GetModuleExternalContexts(ctx context.Context, model string, params map[string]any, systemMessage, userMessage string) (*payload.ModuleExternalContextResponse, error) {
+	raw, err := callAnthropic(ctx, systemMessage, userTurn(userMessage), schema.GetModuleExternalContextTool(), model, params, config.GenerationParams{})
+	if err != nil {
+		return nil, err
+	}
+
+	var ext payload.ModuleExternalContextResponse
+	if err := validate.Decode("anthropic", validate.ModuleExternalContext, raw, &ext); err != nil {
+		return nil, err
+	}
+	return &ext, nil
+}
+
+// GetChatReply continues the conversation in history, whose last message
+// is the user's, and returns the parsed assistant reply.
+func GetChatReply(ctx context.Context, model string, params map[string]any, gen config.GenerationParams, systemMessage string, history []payload.Message) (*payload.ChatReply, error) {
+	raw, err := callAnthropic(ctx, systemMessage, history, schema.GetChatReplyTool(), model, params, gen)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply payload.ChatReply
+	if err := validate.Decode("anthropic", validate.ChatReply, raw, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// -----------------------------------------------------------------------------
+// Provider-specific data structures & helpers (non-exported)
+// -----------------------------------------------------------------------------
+
+// NOTE: baseEndpoint is a var (not const) to allow test overrides.
+var baseEndpoint = "https://api.anthropic.com/v1"
+
+// apiVersion is sent in the anthropic-version header on every request.
+const apiVersion = "2023-06-01"
+
+// maxTokens bounds the size of the generated answer. The Messages API
+// requires the field to be set; whole-file rewrites can be long, so keep
+// it generous.
+const maxTokens = 32000
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type toolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type request struct {
+	Model       string        `json:"model"`
+	MaxTokens   int           `json:"max_tokens"`
+	System      string        `json:"system,omitempty"`
+	Messages    []message     `json:"messages"`
+	Tools       []schema.Tool `json:"tools"`
+	ToolChoice  toolChoice    `json:"tool_choice"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	TopP        *float64      `json:"top_p,omitempty"`
+}
+
+// anthropicResponse mirrors the minimal subset of the response envelope we
+// care about.
+type anthropicResponse struct {
+	Content []struct {
+		Type  string          `json:"type"`
+		Name  string          `json:"name,omitempty"`
+		Text  string          `json:"text,omitempty"`
+		Input json.RawMessage `json:"input,omitempty"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+type anthropicErrorResponse struct {
+	Err struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (e anthropicErrorResponse) Error() string {
+	return fmt.Sprintf("Anthropic API error (%s): %s", e.Err.Type, e.Err.Message)
+}
+
+// userTurn wraps a single user message into a history.
+func userTurn(userMessage string) []payload.Message {
+	return []payload.Message{{Role: payload.RoleUser, Content: userMessage}}
+}
+
+// buildRequest renders the Messages API request. The parameters of gen the
+// API does not support are dropped with a warning: there is no seed, the
+// temperature only goes up to 1, recent models reject temperature and
+// top_p together, and extended thinking cannot be combined with the forced
+// tool call structured output relies on.
+func buildRequest(systemMessage string, history []payload.Message, tool schema.Tool, model string, gen config.GenerationParams) ([]byte, error) {
+	if len(history) == 0 || history[0].Content == "" {
+		return nil, errors.New("anthropic: user message must not be empty")
+	}
+
+	messages := make([]message, 0, len(history))
+	for _, m := range history {
+		messages = append(messages, message{Role: string(m.Role), Content: m.Content})
+	}
+
+	r := request{
+		Model:     model,
+		MaxTokens: maxTokens,
+		System:    systemMessage,
+		Messages:  messages,
+		Tools:     []schema.Tool{tool},
+		ToolChoice: toolChoice{
+			Type: "tool",
+			Name: tool.Name,
+		},
+		Temperature: gen.Temperature,
+		TopP:        gen.TopP,
+	}
+	if gen.MaxOutputTokens > 0 {
+		r.MaxTokens = gen.MaxOutputTokens
+	}
+
+	var dropped []string
+	if r.Temperature != nil && *r.Temperature > 1 {
+		dropped = append(dropped, "temperature above 1")
+		r.Temperature = nil
+	}
+	if r.Temperature != nil && r.TopP != nil {
+		dropped = append(dropped, "topP together with temperature")
+		r.TopP = nil
+	}
+	if gen.Seed != nil {
+		dropped = append(dropped, "seed")
+	}
+	if gen.ReasoningEffort != "" {
+		dropped = append(dropped, "reasoningEffort")
+	}
+	if len(dropped) > 0 {
+		fmt.Printf("warning: anthropic model %s does not support %s, ignoring\n", model, strings.Join(dropped, ", "))
+	}
+
+	return json.Marshal(r)
+}
+
+// toolInput extracts the input of the forced tool call from the response.
+func toolInput(resp *anthropicResponse, toolName string) (json.RawMessage, error) {
+	for _, c := range resp.Content {
+		if c.Type == "tool_use" && c.Name == toolName {
+			return c.Input, nil
+		}
+	}
+	if resp.StopReason == "max_tokens" {
+		return nil, errors.New("anthropic: response truncated before the tool call completed (max_tokens reached)")
+	}
+	return nil, fmt.Errorf("anthropic: response does not contain a %q tool call", toolName)
+}
+
+// callAnthropic sends the request to the Messages API and returns the raw
+// JSON input of the forced tool call.
+func callAnthropic(ctx context.Context, systemMessage string, history []payload.Message, tool schema.Tool, model string, params map[string]any, gen config.GenerationParams) (json.RawMessage, error) {
+	apiKey, err := credentials.APIKey(ctx, "anthropic", "ANTHROPIC_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	if model == "" {
+		return nil, errors.New("anthropic: model must not be empty")
+	}
+
+	bodyBytes, err := buildRequest(systemMessage, history, tool, model, gen)
+	if err != nil {
+		return nil, err
+	}
+	if bodyBytes, err = transport.MergeParams(bodyBytes, params); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseEndpoint+"/messages", bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("anthropic: failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("anthropic-version", apiVersion)
+
+	resp, err := transport.Client(ctx).Do(req)
+	if err != nil {
+		transcript.Write(ctx, transcript.Entry{Provider: "anthropic", URL: req.URL.String(), Request: bodyBytes, Error: err.Error()}, apiKey)
+		return nil, fmt.Errorf("anthropic: request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		transcript.Write(ctx, transcript.Entry{Provider: "anthropic", URL: req.URL.String(), Status: resp.StatusCode, Request: bodyBytes, Error: err.Error()}, apiKey)
+		return nil, fmt.Errorf("anthropic: failed to read response body: %w", err)
+	}
+
+	transcript.Write(ctx, transcript.Entry{
+		Provider: "anthropic",
+		URL:      req.URL.String(),
+		Status:   resp.StatusCode,
+		Request:  bodyBytes,
+		Response: transcript.Body(respBytes),
+	}, apiKey)
+
+	if resp.StatusCode != http.StatusOK {
+		var aErr anthropicErrorResponse
+		if jsonErr := json.Unmarshal(respBytes, &aErr); jsonErr == nil && aErr.Err.Message != "" {
+			return nil, transport.NewError(resp, aErr)
+		}
+		return nil, transport.NewError(resp, fmt.Errorf("anthropic: http %d – %s", resp.StatusCode, string(respBytes)))
+	}
+
+	var out anthropicResponse
+	if err := json.Unmarshal(respBytes, &out); err != nil {
+		return nil, fmt.Errorf("anthropic: failed to unmarshal response: %w", err)
+	}
+	usage.Report(ctx, "anthropic", model, out.Usage.InputTokens, out.Usage.OutputTokens)
+
+	return toolInput(&out, tool.Name)
+}
@@ -0,0 +1,192 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/internal/anthropic/internal/schema"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/llm/transcript"
+)
+
+// toolUseServer returns a test server that answers every request with a
+// single tool_use block carrying the given input. The decoded request is
+// stored in *got so tests can inspect it.
+func toolUseServer(t *testing.T, toolName, input string, got *request) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "x" {
+			t.Errorf("missing x-api-key header")
+		}
+		if r.Header.Get("anthropic-version") != apiVersion {
+			t.Errorf("unexpected anthropic-version header %q", r.Header.Get("anthropic-version"))
+		}
+		if got != nil {
+			_ = json.NewDecoder(r.Body).Decode(got)
+		}
+		resp := map[string]any{
+			"content": []any{
+				map[string]any{
+					"type":  "tool_use",
+					"name":  toolName,
+					"input": json.RawMessage(input),
+				},
+			},
+			"stop_reason": "tool_use",
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func withServer(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	os.Setenv("ANTHROPIC_API_KEY", "x")
+	t.Cleanup(func() {
+		baseEndpoint = oldBase
+		os.Unsetenv("ANTHROPIC_API_KEY")
+		srv.Close()
+	})
+}
+
+func TestGetWorkspaceChangeProposals(t *testing.T) {
+	var got request
+	srv := toolUseServer(t, "workspace_change_proposal", `{"summary":"s","description":"d","proposals":[{"file_name":"a.go","content":"package a","delete":false}]}`, &got)
+	withServer(t, srv)
+
+	prop, err := GetWorkspaceChangeProposals(context.Background(), "claude-sonnet-4-5", nil, config.GenerationParams{}, "sys", "usr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &payload.WorkspaceChangeProposal{
+		Summary:     "s",
+		Description: "d",
+		Proposals:   []payload.FileChangeProposal{{FileName: "a.go", Content: "package a"}},
+	}
+	if !reflect.DeepEqual(prop, want) {
+		t.Fatalf("unexpected proposal: %+v", prop)
+	}
+
+	if got.Model != "claude-sonnet-4-5" || got.System != "sys" {
+		t.Fatalf("unexpected request: model=%q system=%q", got.Model, got.System)
+	}
+	if got.ToolChoice.Type != "tool" || got.ToolChoice.Name != "workspace_change_proposal" {
+		t.Fatalf("tool choice not forced: %+v", got.ToolChoice)
+	}
+	if len(got.Tools) != 1 || got.Tools[0].InputSchema.Type != "object" {
+		t.Fatalf("unexpected tools: %+v", got.Tools)
+	}
+}
+
+func TestGetModuleContext(t *testing.T) {
+	srv := toolUseServer(t, "module_context_schema", `{"internal_context":"i","public_context":"p"}`, nil)
+	withServer(t, srv)
+
+	got, err := GetModuleContext(context.Background(), "claude-sonnet-4-5", nil, "sys", "usr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &payload.ModuleSelfContainedContext{InternalContext: "i", PublicContext: "p"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected ctx: %+v", got)
+	}
+}
+
+func TestGetModuleExternalContexts(t *testing.T) {
+	srv := toolUseServer(t, "module_external_context", `{"modules":[{"name":"foo","external_context":"bar"}]}`, nil)
+	withServer(t, srv)
+
+	got, err := GetModuleExternalContexts(context.Background(), "claude-sonnet-4-5", nil, "sys", "usr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &payload.ModuleExternalContextResponse{Modules: []payload.ModuleExternalContext{{Name: "foo", ExternalContext: "bar"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected ext ctx: %+v", got)
+	}
+}
+
+func TestCallAnthropic_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`))
+	}))
+	withServer(t, srv)
+
+	_, err := GetModuleContext(context.Background(), "claude-sonnet-4-5", nil, "sys", "usr")
+	var aErr anthropicErrorResponse
+	if err == nil || !errors.As(err, &aErr) || aErr.Err.Type != "rate_limit_error" {
+		t.Fatalf("expected rate_limit_error, got %v", err)
+	}
+}
+
+func TestCallAnthropic_TranscriptOnTransportFailure(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	// the body ends before the length announced in its header.
+	truncated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		_, _ = w.Write([]byte(`{"content":`))
+	}))
+
+	for name, srv := range map[string]*httptest.Server{"request": closed, "response body": truncated} {
+		t.Run(name, func(t *testing.T) {
+			withServer(t, srv)
+			dir := t.TempDir()
+			ctx := transcript.WithLogger(context.Background(), transcript.NewLogger(dir, 10))
+			if _, err := GetModuleContext(ctx, "claude-sonnet-4-5", nil, "sys", "usr"); err == nil {
+				t.Fatalf("expected the request to fail")
+			}
+			infos, err := transcript.List(dir)
+			if err != nil || len(infos) != 1 {
+				t.Fatalf("expected the failed request to be logged, got %v (err %v)", infos, err)
+			}
+			data, err := transcript.Read(dir, infos[0].ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var entry transcript.Entry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry.URL != srv.URL+"/messages" || entry.Error == "" || !strings.Contains(string(entry.Request), `"usr"`) {
+				t.Errorf("expected the URL, the request and the error to be logged, got %s", data)
+			}
+		})
+	}
+}
+
+func TestBuildRequest_GenerationParams(t *testing.T) {
+	temp, topP, seed := 0.3, 0.9, int64(1)
+	gen := config.GenerationParams{Temperature: &temp, TopP: &topP, MaxOutputTokens: 1000, Seed: &seed, ReasoningEffort: config.ReasoningEffortHigh}
+
+	var got request
+	body, err := buildRequest("sys", userTurn("usr"), schema.GetChatReplyTool(), "claude-sonnet-4-5", gen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = json.Unmarshal(body, &got)
+	if got.Temperature == nil || *got.Temperature != 0.3 || got.TopP != nil || got.MaxTokens != 1000 {
+		t.Errorf("unexpected request: %s", body)
+	}
+
+	// without parameters, the request keeps the defaults.
+	got = request{}
+	body, _ = buildRequest("sys", userTurn("usr"), schema.GetChatReplyTool(), "claude-sonnet-4-5", config.GenerationParams{})
+	_ = json.Unmarshal(body, &got)
+	if got.Temperature != nil || got.MaxTokens != maxTokens {
+		t.Errorf("unexpected request: %s", body)
+	}
+}
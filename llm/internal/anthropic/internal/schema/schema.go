@@ -0,0 +1,57 @@
+package schema
+
+import (
+	"encoding/json"
+
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
+
+// Tool describes a tool definition as expected by the Anthropic Messages
+// API. Structured output is obtained by forcing the model to call a single
+// tool whose input schema matches the payload we want back.
+type Tool struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	InputSchema JSONSchema `json:"input_schema"`
+}
+
+type JSONSchema struct {
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties bool                   `json:"additionalProperties"`
+}
+
+// GetWorkspaceChangeProposalTool returns the tool used to collect workspace
+// change proposals.
+func GetWorkspaceChangeProposalTool() Tool {
+	return getTool(schemas.WorkspaceChangeProposal)
+}
+
+// GetModuleContextTool returns the tool used to collect the internal and
+// public context of a module.
+func GetModuleContextTool() Tool {
+	return getTool(schemas.ModuleContext)
+}
+
+// GetModuleExternalContextTool returns the tool used to collect external
+// contexts in bulk.
+func GetModuleExternalContextTool() Tool {
+	return getTool(schemas.ModuleExternalContext)
+}
+
+// GetChatReplyTool returns the tool used to collect a `vyb chat` reply.
+func GetChatReplyTool() Tool {
+	return getTool(schemas.ChatReply)
+}
+
+// getTool derives a tool from a canonical schema.
+func getTool(file string) Tool {
+	s := schemas.Get(file)
+	t := Tool{Name: s.Name, Description: s.Description}
+	_ = json.Unmarshal(s.Schema, &t.InputSchema) // the embedded asset is trusted
+	return t
+}
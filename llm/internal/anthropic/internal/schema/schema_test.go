@@ -0,0 +1,21 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
+
+func TestToolsMatchCanonical(t *testing.T) {
+	for _, file := range schemas.All {
+		s, tool := schemas.Get(file), getTool(file)
+		if tool.Name != s.Name || tool.Description != s.Description {
+			t.Errorf("%s: tool %q (%q), want %q (%q)", file, tool.Name, tool.Description, s.Name, s.Description)
+		}
+		derived, _ := json.Marshal(tool.InputSchema)
+		if d := schemas.Diff(s.Schema, derived, "minLength"); d != "" {
+			t.Errorf("%s diverges from the canonical schema: %s", file, d)
+		}
+	}
+}
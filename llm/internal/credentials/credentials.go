@@ -0,0 +1,158 @@
+// Package credentials resolves the API keys of the built-in providers.
+//
+// A key is looked up, in order, in an environment variable, a key file and
+// the output of a command (e.g. a password-manager CLI), as configured
+// under `credentials` in .vyb/config.yaml. Commands run at most once per
+// process: their output is cached, so teammates who are not allowed to
+// export long-lived keys only unlock their password manager once.
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/vybdev/vyb/config"
+)
+
+// ErrNotFound is returned, wrapped in an error listing every source tried,
+// when no source yields a key.
+var ErrNotFound = errors.New("no API key found")
+
+type ctxKey struct{}
+
+// WithSources returns a copy of ctx whose API keys are resolved from
+// sources, keyed by provider name.
+func WithSources(ctx context.Context, sources map[string]*config.CredentialConfig) context.Context {
+	if len(sources) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, sources)
+}
+
+// Configured reports whether credential sources were configured for the
+// named provider.
+func Configured(ctx context.Context, provider string) bool {
+	return sourcesFor(ctx, provider) != nil
+}
+
+func sourcesFor(ctx context.Context, provider string) *config.CredentialConfig {
+	sources, _ := ctx.Value(ctxKey{}).(map[string]*config.CredentialConfig)
+	for k, s := range sources {
+		if strings.EqualFold(k, provider) {
+			return s
+		}
+	}
+	return nil
+}
+
+// APIKey returns the key of the named provider, looking at the sources
+// configured for it in ctx. defaultEnv is the variable consulted when the
+// credential sources do not name another one; for openai, callers pass the
+// variable set under openai, which config.Load keeps from conflicting.
+func APIKey(ctx context.Context, provider, defaultEnv string) (string, error) {
+	src := sourcesFor(ctx, provider)
+	if src == nil {
+		src = &config.CredentialConfig{}
+	}
+	envName := defaultEnv
+	if src.APIKeyEnv != "" {
+		envName = src.APIKeyEnv
+	}
+
+	var tried []string
+	if envName != "" {
+		if key := strings.TrimSpace(os.Getenv(envName)); key != "" {
+			return key, nil
+		}
+		tried = append(tried, fmt.Sprintf("env %s (not set)", envName))
+	}
+	if src.APIKeyFile != "" {
+		key, err := readFile(src.APIKeyFile)
+		if err == nil {
+			return key, nil
+		}
+		tried = append(tried, fmt.Sprintf("file %s (%v)", src.APIKeyFile, err))
+	}
+	if src.APIKeyCommand != "" {
+		key, err := commands.run(ctx, src.APIKeyCommand)
+		if err == nil {
+			return key, nil
+		}
+		tried = append(tried, fmt.Sprintf("command %q (%v)", src.APIKeyCommand, err))
+	}
+	return "", fmt.Errorf("%s: %w; tried %s", provider, ErrNotFound, strings.Join(tried, ", "))
+}
+
+func readFile(path string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, rest)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", errors.New("does not exist")
+		}
+		return "", err
+	}
+	key := strings.TrimSpace(string(b))
+	if key == "" {
+		return "", errors.New("empty")
+	}
+	return key, nil
+}
+
+// commands caches the output of every key command run by the process.
+var commands = &commandCache{keys: map[string]string{}}
+
+type commandCache struct {
+	// mu is held while a command runs, so concurrent calls wait for its
+	// output instead of running it again.
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+// run returns the cached output of command, running it first if needed.
+// Failures are not cached: the next call runs the command again.
+func (c *commandCache) run(ctx context.Context, command string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[command]; ok {
+		return key, nil
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	var stdout, stderr bytes.Buffer
+	// Password managers may need to prompt the user to unlock.
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	key := strings.TrimSpace(stdout.String())
+	if key == "" {
+		return "", errors.New("empty output")
+	}
+	c.keys[command] = key
+	return key, nil
+}
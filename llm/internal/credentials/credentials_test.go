@@ -0,0 +1,88 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+)
+
+func TestAPIKey_Sources(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("GEMINI_API_KEY", "from-default-env")
+	t.Setenv("TEAM_KEY", "")
+	if err := os.WriteFile(filepath.Join(home, "gemini.key"), []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without configuration, the default variable is used.
+	if key, err := APIKey(context.Background(), "gemini", "GEMINI_API_KEY"); err != nil || key != "from-default-env" {
+		t.Fatalf("APIKey() = %q, %v", key, err)
+	}
+
+	// A configured variable replaces the default one, and the file is the
+	// next source.
+	ctx := WithSources(context.Background(), map[string]*config.CredentialConfig{
+		"Gemini": {APIKeyEnv: "TEAM_KEY", APIKeyFile: "~/gemini.key", APIKeyCommand: "exit 1"},
+	})
+	if key, err := APIKey(ctx, "gemini", "GEMINI_API_KEY"); err != nil || key != "from-file" {
+		t.Fatalf("APIKey() = %q, %v", key, err)
+	}
+	t.Setenv("TEAM_KEY", "from-team-env")
+	if key, err := APIKey(ctx, "gemini", "GEMINI_API_KEY"); err != nil || key != "from-team-env" {
+		t.Fatalf("APIKey() = %q, %v", key, err)
+	}
+	if !Configured(ctx, "gemini") || Configured(ctx, "openai") {
+		t.Fatalf("unexpected Configured() results")
+	}
+}
+
+func TestAPIKey_CommandIsCached(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	counter := filepath.Join(t.TempDir(), "runs")
+	command := "echo run >> " + counter + " && echo ' sk-from-command '"
+	ctx := WithSources(context.Background(), map[string]*config.CredentialConfig{"openai": {APIKeyCommand: command}})
+
+	for i := 0; i < 3; i++ {
+		if key, err := APIKey(ctx, "openai", "OPENAI_API_KEY"); err != nil || key != "sk-from-command" {
+			t.Fatalf("APIKey() = %q, %v", key, err)
+		}
+	}
+	runs, _ := os.ReadFile(counter)
+	if n := strings.Count(string(runs), "run"); n != 1 {
+		t.Fatalf("command ran %d times, want 1", n)
+	}
+}
+
+func TestAPIKey_ErrorListsSources(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	dir := t.TempDir()
+	flag := filepath.Join(dir, "unlocked")
+	ctx := WithSources(context.Background(), map[string]*config.CredentialConfig{"anthropic": {
+		APIKeyFile:    filepath.Join(dir, "missing.key"),
+		APIKeyCommand: "test -f " + flag + " && echo key || { echo locked >&2; exit 3; }",
+	}})
+
+	_, err := APIKey(ctx, "anthropic", "ANTHROPIC_API_KEY")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	for _, want := range []string{"env ANTHROPIC_API_KEY (not set)", "missing.key (does not exist)", "exit status 3: locked"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	// Failures are not cached: the command runs again on the next call.
+	if err := os.WriteFile(flag, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := APIKey(ctx, "anthropic", "ANTHROPIC_API_KEY"); err != nil || key != "key" {
+		t.Fatalf("APIKey() = %q, %v", key, err)
+	}
+}
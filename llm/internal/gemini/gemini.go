@@ -2,51 +2,35 @@ package gemini
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/internal/credentials"
 	gemschema "github.com/vybdev/vyb/llm/internal/gemini/internal/schema"
+	"github.com/vybdev/vyb/llm/internal/transport"
+	"github.com/vybdev/vyb/llm/internal/validate"
 	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/llm/progress"
+	"github.com/vybdev/vyb/llm/transcript"
+	"github.com/vybdev/vyb/llm/usage"
 	"io"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
-// mapModel converts the (family,size) tuple into the concrete Gemini
-// model identifier expected by the REST endpoint.
-func mapModel(fam config.ModelFamily, sz config.ModelSize) (string, error) {
-	// The same resolution logic lives also inside llm/dispatcher for the
-	// compile-time tests that exercise dispatch mapping. Keep both in
-	// sync until the refactor that centralises it lands.
-	switch sz {
-	case config.ModelSizeSmall:
-		return "gemini-2.5-flash-preview-05-20", nil
-	case config.ModelSizeLarge:
-		return "gemini-2.5-pro-preview-06-05", nil
-	default:
-		return "", fmt.Errorf("gemini: unsupported model size %s", sz)
-	}
-}
-
 // GetWorkspaceChangeProposals composes the request, sends it to Gemini and
 // converts the response into a strongly-typed WorkspaceChangeProposal.
 //
 // The function mirrors the public surface exposed by the OpenAI provider so
 // callers can remain provider-agnostic.
-func GetWorkspaceChangeProposals(fam config.ModelFamily, sz config.ModelSize, systemMessage, userMessage string) (*payload.WorkspaceChangeProposal, error) {
-	model, err := mapModel(fam, sz)
-	if err != nil {
-		return nil, err
-	}
-
-	if os.Getenv("GEMINI_API_KEY") == "" {
-		return nil, errors.New("GEMINI_API_KEY is not set")
-	}
-
+func GetWorkspaceChangeProposals(ctx context.Context, model string, params map[string]any, gen config.GenerationParams, systemMessage, userMessage string) (*payload.WorkspaceChangeProposal, error) {
 	schema := gemschema.GetWorkspaceChangeProposalSchema()
 
-	resp, err := callGemini(systemMessage, userMessage, schema, model)
+	resp, err := callGemini(ctx, systemMessage, userTurn(userMessage), schema, model, params, gen)
 	if err != nil {
 		return nil, err
 	}
@@ -58,21 +42,16 @@ func GetWorkspaceChangeProposals(fam config.ModelFamily, sz config.ModelSize, sy
 	raw := resp.Candidates[0].Content.Parts[0].Text
 
 	var proposal payload.WorkspaceChangeProposal
-	if err := json.Unmarshal([]byte(raw), &proposal); err != nil {
-		return nil, fmt.Errorf("gemini: failed to unmarshal WorkspaceChangeProposal: %w", err)
+	if err := validate.Decode("gemini", validate.WorkspaceChangeProposal, []byte(raw), &proposal); err != nil {
+		return nil, err
 	}
 	return &proposal, nil
 }
 
-func GetModuleContext(systemMessage, userMessage string) (*payload.ModuleSelfContainedContext, error) {
-	model, err := mapModel(config.ModelFamilyReasoning, config.ModelSizeSmall)
-	if err != nil {
-		return nil, err
-	}
-
+func GetModuleContext(ctx context.Context, model string, params map[string]any, systemMessage, userMessage string) (*payload.ModuleSelfContainedContext, error) {
 	schema := gemschema.GetModuleContextSchema()
 
-	resp, err := callGemini(systemMessage, userMessage, schema, model)
+	resp, err := callGemini(ctx, systemMessage, userTurn(userMessage), schema, model, params, config.GenerationParams{})
 	if err != nil {
 		return nil, err
 	}
@@ -83,22 +62,17 @@ func GetModuleContext(systemMessage, userMessage string) (*payload.ModuleSelfCon
 
 	raw := resp.Candidates[0].Content.Parts[0].Text
 
-	var ctx payload.ModuleSelfContainedContext
-	if err := json.Unmarshal([]byte(raw), &ctx); err != nil {
-		return nil, fmt.Errorf("gemini: failed to unmarshal ModuleSelfContainedContext: %w", err)
-	}
-	return &ctx, nil
-}
-
-func GetModuleExternalContexts(systemMessage, userMessage string) (*payload.ModuleExternalContextResponse, error) {
-	model, err := mapModel(config.ModelFamilyReasoning, config.ModelSizeSmall)
-	if err != nil {
+	var moduleCtx payload.ModuleSelfContainedContext
+	if err := validate.Decode("gemini", validate.ModuleContext, []byte(raw), &moduleCtx); err != nil {
 		return nil, err
 	}
+	return &moduleCtx, nil
+}
 
+func GetModuleExternalContexts(ctx context.Context, model string, params map[string]any, systemMessage, userMessage string) (*payload.ModuleExternalContextResponse, error) {
 	schema := gemschema.GetModuleExternalContextSchema()
 
-	resp, err := callGemini(systemMessage, userMessage, schema, model)
+	resp, err := callGemini(ctx, systemMessage, userTurn(userMessage), schema, model, params, config.GenerationParams{})
 	if err != nil {
 		return nil, err
 	}
@@ -110,12 +84,31 @@ func GetModuleExternalContexts(systemMessage, userMessage string) (*payload.Modu
 	raw := resp.Candidates[0].Content.Parts[0].Text
 
 	var ext payload.ModuleExternalContextResponse
-	if err := json.Unmarshal([]byte(raw), &ext); err != nil {
-		return nil, fmt.Errorf("gemini: failed to unmarshal ModuleExternalContextResponse: %w", err)
+	if err := validate.Decode("gemini", validate.ModuleExternalContext, []byte(raw), &ext); err != nil {
+		return nil, err
 	}
 	return &ext, nil
 }
 
+// GetChatReply continues the conversation in history, whose last message
+// is the user's, and returns the parsed model reply.
+func GetChatReply(ctx context.Context, model string, params map[string]any, gen config.GenerationParams, systemMessage string, history []payload.Message) (*payload.ChatReply, error) {
+	resp, err := callGemini(ctx, systemMessage, history, gemschema.GetChatReplySchema(), model, params, gen)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		return nil, errors.New("gemini: empty response")
+	}
+
+	var reply payload.ChatReply
+	if err := validate.Decode("gemini", validate.ChatReply, []byte(resp.Candidates[0].Content.Parts[0].Text), &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
 // -----------------------------------------------------------------------------
 // Provider-specific data structures & helpers (non-exported)
 // -----------------------------------------------------------------------------
@@ -123,11 +116,29 @@ func GetModuleExternalContexts(systemMessage, userMessage string) (*payload.Modu
 // NOTE: baseEndpoint is a var (not const) to allow test overrides.
 var baseEndpoint = "https://generativelanguage.googleapis.com/v1beta"
 
+// baseEndpointEnv names the environment variable that overrides
+// baseEndpoint, e.g. to point vyb to a proxy or a fake server.
+const baseEndpointEnv = "GEMINI_BASE_URL"
+
+func endpoint() string {
+	if env := os.Getenv(baseEndpointEnv); env != "" {
+		return strings.TrimSuffix(env, "/")
+	}
+	return baseEndpoint
+}
+
 // generateContentTmpl is the relative path (fmt formatted) used to call
 // the "generateContent" method on a specific model, e.g.:
 //
-//	fmt.Sprintf(generateContentTmpl, "gemini-2.5-flash", apiKey)
-const generateContentTmpl = "/models/%s:generateContent?key=%s"
+//	fmt.Sprintf(generateContentTmpl, "gemini-2.5-flash")
+//
+// The API key is sent in the x-goog-api-key header rather than as a query
+// parameter, so it never shows up in URLs, errors or transcripts.
+const generateContentTmpl = "/models/%s:generateContent"
+
+// streamGenerateContentTmpl is the streaming variant of
+// generateContentTmpl, answering with server-sent events.
+const streamGenerateContentTmpl = "/models/%s:streamGenerateContent?alt=sse"
 
 type part struct {
 	Text string `json:"text,omitempty"`
@@ -139,8 +150,33 @@ type content struct {
 }
 
 type generationConfig struct {
-	ResponseMimeType string      `json:"responseMimeType,omitempty"`
-	ResponseSchema   interface{} `json:"responseSchema,omitempty"`
+	ResponseMimeType string          `json:"responseMimeType,omitempty"`
+	ResponseSchema   interface{}     `json:"responseSchema,omitempty"`
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopP             *float64        `json:"topP,omitempty"`
+	MaxOutputTokens  int             `json:"maxOutputTokens,omitempty"`
+	Seed             *int64          `json:"seed,omitempty"`
+	ThinkingConfig   *thinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+type thinkingConfig struct {
+	ThinkingBudget int `json:"thinkingBudget"`
+}
+
+// thinkingBudgets translates reasoning efforts into thinking budgets, in
+// tokens, mirroring Gemini's OpenAI-compatible endpoint.
+var thinkingBudgets = map[config.ReasoningEffort]int{
+	config.ReasoningEffortMinimal: 512,
+	config.ReasoningEffortLow:     1024,
+	config.ReasoningEffortMedium:  8192,
+	config.ReasoningEffortHigh:    24576,
+}
+
+// supportsThinking reports whether model accepts a thinking budget. Only
+// models older than Gemini 2.5 do not.
+func supportsThinking(model string) bool {
+	m := strings.ToLower(model)
+	return !strings.HasPrefix(m, "gemini-1.") && !strings.HasPrefix(m, "gemini-2.0")
 }
 
 type requestPayload struct {
@@ -154,13 +190,19 @@ type requestPayload struct {
 // { "candidates": [ { "content": {"parts": [ {"text": "..."} ] } } ] }
 
 type geminiResponse struct {
-	Candidates []struct {
-		Content struct {
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-		} `json:"content"`
-	} `json:"candidates"`
+	Candidates    []candidate   `json:"candidates"`
+	UsageMetadata usageMetadata `json:"usageMetadata"`
+}
+
+type candidate struct {
+	Content content `json:"content"`
+}
+
+type usageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	// ThoughtsTokenCount is billed as output.
+	ThoughtsTokenCount int `json:"thoughtsTokenCount"`
 }
 
 type geminiErrorResponse struct {
@@ -168,38 +210,83 @@ type geminiErrorResponse struct {
 		Code    int    `json:"code"`
 		Message string `json:"message"`
 		Status  string `json:"status"`
+		Details []struct {
+			Type       string `json:"@type"`
+			RetryDelay string `json:"retryDelay,omitempty"`
+		} `json:"details,omitempty"`
 	} `json:"error"`
 }
 
+// retryDelay returns the delay suggested by a google.rpc.RetryInfo detail,
+// or zero when the error carries none.
+func (e geminiErrorResponse) retryDelay() time.Duration {
+	for _, d := range e.Err.Details {
+		if strings.HasSuffix(d.Type, "google.rpc.RetryInfo") && d.RetryDelay != "" {
+			if v, err := time.ParseDuration(d.RetryDelay); err == nil {
+				return v
+			}
+		}
+	}
+	return 0
+}
+
 func (e geminiErrorResponse) Error() string {
 	return fmt.Sprintf("Gemini API error (%d %s): %s", e.Err.Code, e.Err.Status, e.Err.Message)
 }
 
-func buildRequest(systemMessage, userMessage string, schema interface{}) ([]byte, error) {
-	if userMessage == "" {
+// userTurn wraps a single user message into a history.
+func userTurn(userMessage string) []payload.Message {
+	return []payload.Message{{Role: payload.RoleUser, Content: userMessage}}
+}
+
+// buildRequest renders history as Gemini contents. The system message is
+// prepended to the first user turn; assistant turns use the "model" role.
+//
+// The parameters of gen that model does not support are dropped with a
+// warning.
+func buildRequest(systemMessage string, history []payload.Message, schema interface{}, model string, gen config.GenerationParams) ([]byte, error) {
+	if len(history) == 0 || history[0].Content == "" {
 		return nil, errors.New("gemini: user message must not be empty")
 	}
 
+	contents := make([]content, 0, len(history))
+	for i, m := range history {
+		role, text := "user", m.Content
+		if m.Role == payload.RoleAssistant {
+			role = "model"
+		}
+		if i == 0 {
+			text = systemMessage + "\n\n" + text
+		}
+		contents = append(contents, content{Role: role, Parts: []part{{Text: text}}})
+	}
+
 	r := requestPayload{
-		Contents: []content{
-			{
-				Role:  "user",
-				Parts: []part{{Text: systemMessage + "\n\n" + userMessage}},
-			},
-		},
+		Contents: contents,
 		GenerationConfig: generationConfig{
 			ResponseMimeType: "application/json",
 			ResponseSchema:   schema,
+			Temperature:      gen.Temperature,
+			TopP:             gen.TopP,
+			MaxOutputTokens:  gen.MaxOutputTokens,
+			Seed:             gen.Seed,
 		},
 	}
+	if gen.ReasoningEffort != "" {
+		if supportsThinking(model) {
+			r.GenerationConfig.ThinkingConfig = &thinkingConfig{ThinkingBudget: thinkingBudgets[gen.ReasoningEffort]}
+		} else {
+			fmt.Printf("warning: gemini model %s does not support reasoningEffort, ignoring\n", model)
+		}
+	}
 
 	return json.Marshal(r)
 }
 
-func callGemini(systemMessage, userMessage string, schema interface{}, model string) (*geminiResponse, error) {
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		return nil, errors.New("GEMINI_API_KEY is not set")
+func callGemini(ctx context.Context, systemMessage string, history []payload.Message, schema interface{}, model string, params map[string]any, gen config.GenerationParams) (*geminiResponse, error) {
+	apiKey, err := credentials.APIKey(ctx, "gemini", "GEMINI_API_KEY")
+	if err != nil {
+		return nil, err
 	}
 
 	if model == "" {
@@ -207,63 +294,120 @@ func callGemini(systemMessage, userMessage string, schema interface{}, model str
 	}
 
 	// Build request body.
-	bodyBytes, err := buildRequest(systemMessage, userMessage, schema)
+	bodyBytes, err := buildRequest(systemMessage, history, schema, model, gen)
 	if err != nil {
 		return nil, err
 	}
+	if bodyBytes, err = transport.MergeParams(bodyBytes, params); err != nil {
+		return nil, err
+	}
 
-	// Compose endpoint URL.
-	url := fmt.Sprintf("%s"+generateContentTmpl, baseEndpoint, model, apiKey)
+	// Compose endpoint URL. Streamed responses report their progress as
+	// they are received.
+	stream := progress.Enabled(ctx)
+	tmpl := generateContentTmpl
+	if stream {
+		tmpl = streamGenerateContentTmpl
+	}
+	url := fmt.Sprintf("%s"+tmpl, endpoint(), model)
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(bodyBytes))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bodyBytes))
 	if err != nil {
 		return nil, fmt.Errorf("gemini: failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	tracker := progress.Start(ctx, "gemini "+model)
+	resp, err := transport.Client(ctx).Do(req)
 	if err != nil {
+		tracker.Done()
+		transcript.Write(ctx, transcript.Entry{Provider: "gemini", URL: url, Request: bodyBytes, Error: err.Error()}, apiKey)
 		return nil, fmt.Errorf("gemini: request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
-	respBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("gemini: failed to read response body: %w", err)
+	var out *geminiResponse
+	var respBytes []byte
+	if resp.StatusCode == http.StatusOK && transport.IsEventStream(resp) {
+		out, err = readStream(resp.Body, tracker)
+		tracker.Done()
+		if err != nil {
+			transcript.Write(ctx, transcript.Entry{Provider: "gemini", URL: url, Status: resp.StatusCode, Request: bodyBytes, Error: err.Error()}, apiKey)
+			return nil, transport.NewStreamError(resp, err)
+		}
+		// the transcript holds the response as assembled from the stream.
+		respBytes, _ = json.Marshal(out)
+	} else {
+		respBytes, err = io.ReadAll(resp.Body)
+		tracker.Done()
+		if err != nil {
+			transcript.Write(ctx, transcript.Entry{Provider: "gemini", URL: url, Status: resp.StatusCode, Request: bodyBytes, Error: err.Error()}, apiKey)
+			return nil, fmt.Errorf("gemini: failed to read response body: %w", err)
+		}
 	}
 
-	// ---------------------------------------------------------------------
-	// Persist request/response pair for debugging – same approach as OpenAI.
-	// ---------------------------------------------------------------------
-	logEntry := struct {
-		Request  json.RawMessage `json:"request"`
-		Response json.RawMessage `json:"response"`
-	}{
+	transcript.Write(ctx, transcript.Entry{
+		Provider: "gemini",
+		URL:      url,
+		Status:   resp.StatusCode,
 		Request:  bodyBytes,
-		Response: respBytes,
-	}
-
-	if logBytes, err := json.MarshalIndent(logEntry, "", "  "); err == nil {
-		if f, err := os.CreateTemp("", "vyb-gemini-*.json"); err == nil {
-			if _, wErr := f.Write(logBytes); wErr == nil {
-				_ = f.Close()
-			}
-		}
-	}
+		Response: transcript.Body(respBytes),
+	}, apiKey)
 
 	if resp.StatusCode != http.StatusOK {
 		// Try to decode structured error first.
 		var gErr geminiErrorResponse
 		if jsonErr := json.Unmarshal(respBytes, &gErr); jsonErr == nil && gErr.Err.Message != "" {
-			return nil, gErr
+			apiErr := transport.NewError(resp, gErr)
+			if apiErr.RetryAfter == 0 {
+				apiErr.RetryAfter = gErr.retryDelay()
+			}
+			return nil, apiErr
 		}
-		return nil, fmt.Errorf("gemini: http %d – %s", resp.StatusCode, string(respBytes))
+		return nil, transport.NewError(resp, fmt.Errorf("gemini: http %d – %s", resp.StatusCode, string(respBytes)))
 	}
 
-	var out geminiResponse
-	if err := json.Unmarshal(respBytes, &out); err != nil {
-		return nil, fmt.Errorf("gemini: failed to unmarshal response: %w", err)
+	if out == nil {
+		out = &geminiResponse{}
+		if err := json.Unmarshal(respBytes, out); err != nil {
+			return nil, fmt.Errorf("gemini: failed to unmarshal response: %w", err)
+		}
 	}
+	u := out.UsageMetadata
+	usage.Report(ctx, "gemini", model, u.PromptTokenCount, u.CandidatesTokenCount+u.ThoughtsTokenCount)
 
+	return out, nil
+}
+
+// readStream assembles a streamed response into the one generateContent
+// would have returned, reporting every chunk to tracker. Each event is a
+// partial response; the text of their first candidates is concatenated,
+// and the usage is taken from the last event that carries it.
+func readStream(body io.Reader, tracker *progress.Tracker) (*geminiResponse, error) {
+	var out geminiResponse
+	var text strings.Builder
+	err := transport.ReadEvents(body, func(data []byte) error {
+		var chunk geminiResponse
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("gemini: invalid stream event: %w", err)
+		}
+		if chunk.UsageMetadata != (usageMetadata{}) {
+			out.UsageMetadata = chunk.UsageMetadata
+		}
+		if len(chunk.Candidates) > 0 {
+			for _, p := range chunk.Candidates[0].Content.Parts {
+				text.WriteString(p.Text)
+				tracker.Add(p.Text)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if text.Len() > 0 {
+		out.Candidates = []candidate{{Content: content{Role: "model", Parts: []part{{Text: text.String()}}}}}
+	}
 	return &out, nil
 }
@@ -1,19 +1,33 @@
 package gemini
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/internal/transport"
+	"github.com/vybdev/vyb/llm/internal/validate"
 	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/llm/progress"
+	"github.com/vybdev/vyb/llm/transcript"
+	"github.com/vybdev/vyb/llm/usage"
 )
 
 func TestGetModuleContext(t *testing.T) {
 	// Dummy server returning minimal module context JSON.
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-goog-api-key") != "x" || r.URL.Query().Get("key") != "" {
+			t.Errorf("the API key must be sent in the x-goog-api-key header only, got %q", r.URL.String())
+		}
 		resp := map[string]any{
 			"candidates": []any{
 				map[string]any{
@@ -31,6 +45,7 @@ func TestGetModuleContext(t *testing.T) {
 	}))
 	defer srv.Close()
 
+	t.Setenv("GEMINI_BASE_URL", "")
 	oldBase := baseEndpoint
 	baseEndpoint = srv.URL
 	defer func() { baseEndpoint = oldBase }()
@@ -38,7 +53,7 @@ func TestGetModuleContext(t *testing.T) {
 	os.Setenv("GEMINI_API_KEY", "x")
 	defer os.Unsetenv("GEMINI_API_KEY")
 
-	got, err := GetModuleContext("sys", "usr")
+	got, err := GetModuleContext(context.Background(), "gemini-2.5-flash", nil, "sys", "usr")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -67,6 +82,7 @@ func TestGetModuleExternalContexts(t *testing.T) {
 	}))
 	defer srv.Close()
 
+	t.Setenv("GEMINI_BASE_URL", "")
 	oldBase := baseEndpoint
 	baseEndpoint = srv.URL
 	defer func() { baseEndpoint = oldBase }()
@@ -74,7 +90,7 @@ func TestGetModuleExternalContexts(t *testing.T) {
 	os.Setenv("GEMINI_API_KEY", "x")
 	defer os.Unsetenv("GEMINI_API_KEY")
 
-	got, err := GetModuleExternalContexts("sys", "usr")
+	got, err := GetModuleExternalContexts(context.Background(), "gemini-2.5-flash", nil, "sys", "usr")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -83,3 +99,222 @@ func TestGetModuleExternalContexts(t *testing.T) {
 		t.Fatalf("unexpected ext ctx: %+v", got)
 	}
 }
+
+func TestCallGemini_RetryInfo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":{"code":429,"status":"RESOURCE_EXHAUSTED","message":"slow down","details":[{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"37s"}]}}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("GEMINI_BASE_URL", "")
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+
+	os.Setenv("GEMINI_API_KEY", "x")
+	defer os.Unsetenv("GEMINI_API_KEY")
+
+	_, err := GetModuleContext(context.Background(), "gemini-2.5-flash", nil, "sys", "usr")
+	var apiErr *transport.Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *transport.Error, got %T: %v", err, err)
+	}
+	if apiErr.StatusCode != http.StatusTooManyRequests || apiErr.RetryAfter != 37*time.Second {
+		t.Fatalf("unexpected error metadata: status=%d retryAfter=%s", apiErr.StatusCode, apiErr.RetryAfter)
+	}
+	var gErr geminiErrorResponse
+	if !errors.As(err, &gErr) || gErr.Err.Status != "RESOURCE_EXHAUSTED" {
+		t.Fatalf("expected wrapped geminiErrorResponse, got %v", err)
+	}
+}
+
+func TestCallGemini_ReportsUsage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{
+			"candidates":[{"content":{"parts":[{"text":"{\"internal_context\":\"i\",\"public_context\":\"p\"}"}]}}],
+			"usageMetadata":{"promptTokenCount":100,"candidatesTokenCount":20,"thoughtsTokenCount":30}
+		}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("GEMINI_BASE_URL", "")
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+
+	t.Setenv("GEMINI_API_KEY", "x")
+
+	root := t.TempDir()
+	ctx := usage.WithModule(usage.WithLedger(context.Background(), usage.NewLedger(root, nil)), "mod")
+	if _, err := GetModuleContext(ctx, "gemini-2.5-flash", nil, "sys", "usr"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	records, err := usage.Load(usage.Path(root))
+	if err != nil || len(records) != 1 {
+		t.Fatalf("expected one usage record, got %v (err %v)", records, err)
+	}
+	r := records[0]
+	if r.Provider != "gemini" || r.Module != "mod" || r.InputTokens != 100 || r.OutputTokens != 50 || r.Cost == 0 {
+		t.Fatalf("unexpected usage record: %+v", r)
+	}
+}
+
+func TestGetWorkspaceChangeProposals_Streaming(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.RequestURI()
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":"{\"summary\":\"s\",\"description\":\"d\","}]}}],"usageMetadata":{"promptTokenCount":100}}`,
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":"\"proposals\":[{\"file_name\":\"a.go\",\"content\":\"package a\",\"delete\":false}]}"}]}}],"usageMetadata":{"promptTokenCount":100,"candidatesTokenCount":20,"thoughtsTokenCount":30}}`,
+		} {
+			_, _ = w.Write([]byte("data: " + event + "\r\n\r\n"))
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("GEMINI_BASE_URL", "")
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+
+	t.Setenv("GEMINI_API_KEY", "x")
+
+	root := t.TempDir()
+	var out strings.Builder
+	ctx := progress.WithWriter(usage.WithLedger(context.Background(), usage.NewLedger(root, nil)), &out)
+	proposal, err := GetWorkspaceChangeProposals(ctx, "gemini-2.5-pro", nil, config.GenerationParams{}, "sys", "usr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/models/gemini-2.5-pro:streamGenerateContent?alt=sse" {
+		t.Fatalf("unexpected path %q", path)
+	}
+	want := &payload.WorkspaceChangeProposal{Summary: "s", Description: "d", Proposals: []payload.FileChangeProposal{{FileName: "a.go", Content: "package a"}}}
+	if !reflect.DeepEqual(proposal, want) {
+		t.Fatalf("unexpected proposal: %+v", proposal)
+	}
+	if !strings.Contains(out.String(), "gemini gemini-2.5-pro: ~") || !strings.Contains(out.String(), "writing a.go") {
+		t.Errorf("unexpected progress output %q", out.String())
+	}
+	records, err := usage.Load(usage.Path(root))
+	if err != nil || len(records) != 1 || records[0].InputTokens != 100 || records[0].OutputTokens != 50 {
+		t.Fatalf("expected the usage of the last event to be recorded, got %v (err %v)", records, err)
+	}
+}
+
+func TestCallGemini_InterruptedStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		// the connection drops in the middle of an event.
+		_, _ = w.Write([]byte(`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"{\"summ`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("GEMINI_BASE_URL", "")
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+	t.Setenv("GEMINI_API_KEY", "x")
+
+	ctx := progress.WithWriter(context.Background(), io.Discard)
+	_, err := GetWorkspaceChangeProposals(ctx, "gemini-2.5-pro", nil, config.GenerationParams{}, "sys", "usr")
+	var apiErr *transport.Error
+	if !errors.As(err, &apiErr) || !apiErr.Interrupted {
+		t.Fatalf("expected a retryable transport error, got %#v", err)
+	}
+}
+
+func TestCallGemini_TranscriptOnRequestFailure(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	t.Setenv("GEMINI_BASE_URL", "")
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+	t.Setenv("GEMINI_API_KEY", "x")
+
+	dir := t.TempDir()
+	ctx := transcript.WithLogger(context.Background(), transcript.NewLogger(dir, 10))
+	if _, err := GetModuleContext(ctx, "gemini-2.5-flash", nil, "sys", "usr"); err == nil {
+		t.Fatalf("expected the request to fail")
+	}
+	if infos, err := transcript.List(dir); err != nil || len(infos) != 1 {
+		t.Fatalf("expected the failed request to be logged, got %v (err %v)", infos, err)
+	}
+}
+
+func TestGetWorkspaceChangeProposals_RejectsEmptyFileName(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := map[string]any{
+			"candidates": []any{
+				map[string]any{
+					"content": map[string]any{
+						"parts": []any{
+							map[string]any{
+								"text": `{"summary":"s","description":"d","proposals":[{"file_name":"","content":"x","delete":false}]}`,
+							},
+						},
+					},
+				},
+			},
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	t.Setenv("GEMINI_BASE_URL", "")
+	oldBase := baseEndpoint
+	baseEndpoint = srv.URL
+	defer func() { baseEndpoint = oldBase }()
+
+	os.Setenv("GEMINI_API_KEY", "x")
+	defer os.Unsetenv("GEMINI_API_KEY")
+
+	_, err := GetWorkspaceChangeProposals(context.Background(), "gemini-2.5-pro", nil, config.GenerationParams{}, "sys", "usr")
+	var verr *validate.Error
+	if !errors.As(err, &verr) || len(verr.Violations) != 1 || verr.Violations[0].Field != "proposals[0].file_name" {
+		t.Fatalf("expected a validation error naming proposals[0].file_name, got %v", err)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	t.Setenv("GEMINI_BASE_URL", "")
+	if got := endpoint(); got != baseEndpoint {
+		t.Errorf("endpoint() = %q, want %q", got, baseEndpoint)
+	}
+	t.Setenv("GEMINI_BASE_URL", "http://127.0.0.1:8080/v1beta/")
+	if got := endpoint(); got != "http://127.0.0.1:8080/v1beta" {
+		t.Errorf("expected the environment to override the default endpoint, got %q", got)
+	}
+}
+
+func TestBuildRequest_GenerationParams(t *testing.T) {
+	temp, seed := 1.2, int64(3)
+	gen := config.GenerationParams{Temperature: &temp, ReasoningEffort: config.ReasoningEffortLow, MaxOutputTokens: 4096, Seed: &seed}
+
+	var got requestPayload
+	body, err := buildRequest("sys", userTurn("usr"), nil, "gemini-2.5-flash", gen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = json.Unmarshal(body, &got)
+	gc := got.GenerationConfig
+	if gc.Temperature == nil || *gc.Temperature != 1.2 || gc.MaxOutputTokens != 4096 || gc.Seed == nil || *gc.Seed != 3 || gc.TopP != nil {
+		t.Errorf("unexpected generation config: %s", body)
+	}
+	if gc.ThinkingConfig == nil || gc.ThinkingConfig.ThinkingBudget != 1024 {
+		t.Errorf("expected a thinking budget of 1024 tokens, got %s", body)
+	}
+
+	// models without thinking support ignore the reasoning effort.
+	got = requestPayload{}
+	body, _ = buildRequest("sys", userTurn("usr"), nil, "gemini-2.0-flash", gen)
+	_ = json.Unmarshal(body, &got)
+	if got.GenerationConfig.ThinkingConfig != nil || got.GenerationConfig.MaxOutputTokens != 4096 {
+		t.Errorf("expected only the thinking config to be dropped, got %s", body)
+	}
+}
@@ -1,26 +1,27 @@
 package schema
 
 import (
-	"embed"
 	"encoding/json"
-)
 
-//go:embed schemas/*
-var embedded embed.FS
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
 
-// StructuredOutputSchema mirrors the structure used by the OpenAI provider so
-// we can reuse the same JSON schema files. Only the `Schema` field is used by
-// the Gemini client – the wrapper itself is kept for parity and potential
-// future needs.
+// StructuredOutputSchema mirrors the structure used by the OpenAI provider.
+// Only the `Schema` field is used by the Gemini client – the wrapper itself
+// is kept for parity and potential future needs.
 type StructuredOutputSchema struct {
 	Schema JSONSchema `json:"schema,omitempty"`
 	Name   string     `json:"name,omitempty"`
 	Strict bool       `json:"strict,omitempty"`
 }
 
+// JSONSchema is the subset of the OpenAPI schema understood by Gemini.
+// Format must be "enum" for Gemini to honour Enum: getSchema sets it.
 type JSONSchema struct {
 	Description string                 `json:"description,omitempty"`
 	Type        string                 `json:"type,omitempty"`
+	Format      string                 `json:"format,omitempty"`
+	Enum        []string               `json:"enum,omitempty"`
 	Properties  map[string]*JSONSchema `json:"properties,omitempty"`
 	Items       *JSONSchema            `json:"items,omitempty"`
 	//Required             []string               `json:"required,omitempty"`
@@ -30,24 +31,44 @@ type JSONSchema struct {
 // GetWorkspaceChangeProposalSchema parses and returns the schema definition
 // for workspace change proposals.
 func GetWorkspaceChangeProposalSchema() JSONSchema {
-	return getSchema("schemas/workspace_change_proposal_schema.json")
+	return getSchema(schemas.WorkspaceChangeProposal)
 }
 
 // GetModuleContextSchema returns the schema definition for module context
 // generation.
 func GetModuleContextSchema() JSONSchema {
-	return getSchema("schemas/module_selfcontained_context_schema.json")
+	return getSchema(schemas.ModuleContext)
 }
 
 // GetModuleExternalContextSchema returns the schema definition used when
 // requesting external contexts in bulk.
 func GetModuleExternalContextSchema() JSONSchema {
-	return getSchema("schemas/module_external_context_schema.json")
+	return getSchema(schemas.ModuleExternalContext)
+}
+
+// GetChatReplySchema returns the schema definition of a `vyb chat` reply.
+func GetChatReplySchema() JSONSchema {
+	return getSchema(schemas.ChatReply)
 }
 
-func getSchema(path string) JSONSchema {
-	data, _ := embedded.ReadFile(path)
+// getSchema derives a schema from the canonical one, leaving out the
+// keywords Gemini does not accept.
+func getSchema(file string) JSONSchema {
 	var s JSONSchema
-	_ = json.Unmarshal(data, &s) // the embedded asset is trusted
+	_ = json.Unmarshal(schemas.Get(file).Schema, &s) // the embedded asset is trusted
+	s.setEnumFormat()
 	return s
 }
+
+// setEnumFormat marks every string enum of s with the "enum" format.
+func (s *JSONSchema) setEnumFormat() {
+	if len(s.Enum) > 0 {
+		s.Format = "enum"
+	}
+	for _, p := range s.Properties {
+		p.setEnumFormat()
+	}
+	if s.Items != nil {
+		s.Items.setEnumFormat()
+	}
+}
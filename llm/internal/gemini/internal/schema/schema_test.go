@@ -0,0 +1,19 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
+
+func TestSchemasMatchCanonical(t *testing.T) {
+	for _, file := range schemas.All {
+		derived, _ := json.Marshal(getSchema(file))
+		// Gemini accepts neither required nor additionalProperties, and
+		// needs a format for enums.
+		if d := schemas.Diff(schemas.Get(file).Schema, derived, "minLength", "required", "additionalProperties", "format"); d != "" {
+			t.Errorf("%s diverges from the canonical schema: %s", file, d)
+		}
+	}
+}
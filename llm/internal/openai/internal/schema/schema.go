@@ -1,34 +1,40 @@
 package schema
 
 import (
-	"embed"
 	"encoding/json"
-)
 
-//go:embed schemas/*
-var embedded embed.FS
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
 
-// GetWorkspaceChangeProposalSchema reads configuration files from the embedded directory and parses the JSON schema.
+// GetWorkspaceChangeProposalSchema derives the structured output schema of workspace change proposals from the canonical one.
 func GetWorkspaceChangeProposalSchema() StructuredOutputSchema {
-	return getSchema("schemas/workspace_change_proposal_schema.json")
+	return getSchema(schemas.WorkspaceChangeProposal)
 }
 
-// GetModuleContextSchema retrieves the structured output schema for the module context from an embedded JSON file.
+// GetModuleContextSchema derives the structured output schema for the module context from the canonical one.
 func GetModuleContextSchema() StructuredOutputSchema {
-	return getSchema("schemas/module_selfcontained_context_schema.json")
+	return getSchema(schemas.ModuleContext)
 }
 
-// GetModuleExternalContextSchema retrieves the structured output schema for
-// module external context generation from an embedded JSON file.
+// GetModuleExternalContextSchema derives the structured output schema for
+// module external context generation from the canonical one.
 func GetModuleExternalContextSchema() StructuredOutputSchema {
-	return getSchema("schemas/module_external_context_schema.json")
+	return getSchema(schemas.ModuleExternalContext)
+}
+
+// GetChatReplySchema derives the structured output schema of a `vyb chat`
+// reply from the canonical one.
+func GetChatReplySchema() StructuredOutputSchema {
+	return getSchema(schemas.ChatReply)
 }
 
-func getSchema(schemaName string) StructuredOutputSchema {
-	data, _ := embedded.ReadFile(schemaName)
-	var resp StructuredOutputSchema
-	// this file is embedded, so ignore the error
-	_ = json.Unmarshal(data, &resp)
+// getSchema renders a canonical schema in strict mode, where every
+// property is required.
+func getSchema(file string) StructuredOutputSchema {
+	s := schemas.Get(file)
+	resp := StructuredOutputSchema{Name: s.Name, Strict: true}
+	// the canonical schemas are embedded, so ignore the error
+	_ = json.Unmarshal(s.Strict(), &resp.Schema)
 	return resp
 }
 
@@ -41,6 +47,7 @@ type StructuredOutputSchema struct {
 type JSONSchema struct {
 	Description          string                 `json:"description,omitempty"`
 	Type                 string                 `json:"type,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
 	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
 	Items                *JSONSchema            `json:"items,omitempty"`
 	Required             []string               `json:"required,omitempty"`
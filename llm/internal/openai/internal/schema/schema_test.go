@@ -2,7 +2,10 @@ package schema
 
 import (
 	"encoding/json"
+	"reflect"
 	"testing"
+
+	"github.com/vybdev/vyb/llm/internal/schemas"
 )
 
 // TestGetResponseSchema loads the JSON schema from the embedded workspace_change_proposal_schema.json
@@ -20,3 +23,20 @@ func TestGetResponseSchema(t *testing.T) {
 	}
 	t.Logf("Loaded JSON Schema:\n%s", string(b))
 }
+
+func TestGetChatReplySchema_ToolNames(t *testing.T) {
+	name := GetChatReplySchema().Schema.Properties["tool_calls"].Items.Properties["name"]
+	if want := []string{"read_file", "list_dir", "grep"}; !reflect.DeepEqual(name.Enum, want) {
+		t.Fatalf("tool name enum = %v, want %v", name.Enum, want)
+	}
+}
+
+func TestSchemasMatchCanonical(t *testing.T) {
+	for _, file := range schemas.All {
+		derived, _ := json.Marshal(getSchema(file).Schema)
+		// OpenAI's strict mode does not accept minLength.
+		if d := schemas.Diff(schemas.Get(file).Strict(), derived, "minLength"); d != "" {
+			t.Errorf("%s diverges from the canonical schema: %s", file, d)
+		}
+	}
+}
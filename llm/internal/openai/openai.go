@@ -2,17 +2,26 @@ package openai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/internal/credentials"
 	"github.com/vybdev/vyb/llm/internal/openai/internal/schema"
+	"github.com/vybdev/vyb/llm/internal/transport"
+	"github.com/vybdev/vyb/llm/internal/validate"
+	"github.com/vybdev/vyb/llm/progress"
+	"github.com/vybdev/vyb/llm/transcript"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/vybdev/vyb/llm/payload"
-	"time"
+	"github.com/vybdev/vyb/llm/usage"
 )
 
 // message represents a single message in the chat conversation.
@@ -23,21 +32,59 @@ type message struct {
 
 // request defines the request payload sent to the OpenAI API.
 type request struct {
-	Model          string         `json:"model"`
-	Messages       []message      `json:"messages"`
-	ResponseFormat responseFormat `json:"response_format"`
+	Model               string         `json:"model"`
+	Messages            []message      `json:"messages"`
+	ResponseFormat      responseFormat `json:"response_format"`
+	Temperature         *float64       `json:"temperature,omitempty"`
+	TopP                *float64       `json:"top_p,omitempty"`
+	ReasoningEffort     string         `json:"reasoning_effort,omitempty"`
+	MaxCompletionTokens int            `json:"max_completion_tokens,omitempty"`
+	Seed                *int64         `json:"seed,omitempty"`
+	Stream              bool           `json:"stream,omitempty"`
+	StreamOptions       *streamOptions `json:"stream_options,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type responseFormat struct {
-	Type       string                        `json:"type"`
-	JSONSchema schema.StructuredOutputSchema `json:"json_schema"`
+	Type       string                         `json:"type"`
+	JSONSchema *schema.StructuredOutputSchema `json:"json_schema,omitempty"`
 }
 
 // openaiResponse defines the expected response structure from the OpenAI API.
 type openaiResponse struct {
+	Model   string     `json:"model"`
+	Choices []choice   `json:"choices"`
+	Usage   tokenUsage `json:"usage"`
+}
+
+type choice struct {
+	Message message `json:"message"`
+}
+
+type tokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// openaiChunk is a single event of a streamed response. Only the last one
+// carries the usage, and only when it was requested in stream_options.
+type openaiChunk struct {
+	Model   string `json:"model"`
 	Choices []struct {
-		Message message `json:"message"`
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
 	} `json:"choices"`
+	Usage *tokenUsage `json:"usage"`
+	// Error is set when the request fails once the stream has started.
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+	} `json:"error"`
 }
 
 type openaiErrorResponse struct {
@@ -53,194 +100,402 @@ func (o openaiErrorResponse) Error() string {
 	return fmt.Sprintf("OpenAI API error: %s", o.OpenAIError.Message)
 }
 
-// -----------------------------------------------------------------------------
-//
-//	Model resolver
-//
-// -----------------------------------------------------------------------------
-// mapModel converts a generic (family,size) pair into a concrete OpenAI model
-// string.  The mapping is local to this provider so business-level code never
-// depends on provider-specific identifiers.
-func mapModel(fam config.ModelFamily, sz config.ModelSize) (string, error) {
-	switch fam {
-	case config.ModelFamilyGPT:
-		switch sz {
-		case config.ModelSizeLarge:
-			return "GPT-4.1", nil
-		case config.ModelSizeSmall:
-			return "GPT-4.1-mini", nil
+// defaultBaseURL is the API root used when neither the configuration nor
+// the baseURLEnv environment variable point to a different
+// OpenAI-compatible server.
+const defaultBaseURL = "https://api.openai.com/v1"
+
+// baseURLEnv names the environment variable that overrides the default API
+// root, as in the official SDKs.
+const baseURLEnv = "OPENAI_BASE_URL"
+
+// defaultAPIKeyEnv is the environment variable consulted for the API key
+// when the configuration does not name a different one.
+const defaultAPIKeyEnv = "OPENAI_API_KEY"
+
+// defaultAzureAPIVersion is sent as the api-version query parameter when
+// the Azure configuration does not name one.
+const defaultAzureAPIVersion = "2024-10-21"
+
+// defaultAzureAPIKeyEnv is the environment variable consulted for the key
+// of an Azure OpenAI resource when the configuration does not name a
+// different one.
+const defaultAzureAPIKeyEnv = "AZURE_OPENAI_API_KEY"
+
+// jsonSchemaUnsupported remembers the endpoints of servers that rejected
+// the `json_schema` response format, so subsequent calls go straight to
+// the `json_object` fallback instead of paying for a failed request.
+var jsonSchemaUnsupported sync.Map
+
+func baseURL(cfg *config.OpenAIConfig) string {
+	if cfg != nil && cfg.BaseURL != "" {
+		return strings.TrimSuffix(cfg.BaseURL, "/")
+	}
+	if env := os.Getenv(baseURLEnv); env != "" {
+		return strings.TrimSuffix(env, "/")
+	}
+	return defaultBaseURL
+}
+
+// azure returns the Azure OpenAI configuration, or nil when cfg targets an
+// OpenAI-compatible server.
+func azure(cfg *config.OpenAIConfig) *config.AzureOpenAIConfig {
+	if cfg == nil {
+		return nil
+	}
+	return cfg.Azure
+}
+
+// chatCompletionsURL returns the URL model is requested from. On Azure,
+// model is the name of a deployment.
+func chatCompletionsURL(cfg *config.OpenAIConfig, model string) (string, error) {
+	az := azure(cfg)
+	if az == nil {
+		return baseURL(cfg) + "/chat/completions", nil
+	}
+	if az.Endpoint == "" {
+		return "", errors.New("openai.azure.endpoint is not set")
+	}
+	version := az.APIVersion
+	if version == "" {
+		version = defaultAzureAPIVersion
+	}
+	return strings.TrimSuffix(az.Endpoint, "/") + "/openai/deployments/" + url.PathEscape(model) +
+		"/chat/completions?api-version=" + url.QueryEscape(version), nil
+}
+
+// apiKey resolves the API key according to cfg and to the credential
+// sources attached to ctx. An empty key with a nil error means the request
+// should be sent without authentication.
+func apiKey(ctx context.Context, cfg *config.OpenAIConfig) (string, error) {
+	if az := azure(cfg); az != nil {
+		envName := defaultAzureAPIKeyEnv
+		if az.APIKeyEnv != "" {
+			envName = az.APIKeyEnv
 		}
-	case config.ModelFamilyReasoning:
-		switch sz {
-		case config.ModelSizeLarge:
-			return "o3", nil
-		case config.ModelSizeSmall:
-			return "o4-mini", nil
+		return credentials.APIKey(ctx, "openai", envName)
+	}
+
+	envName := defaultAPIKeyEnv
+	if cfg != nil && cfg.APIKeyEnv != "" {
+		envName = cfg.APIKeyEnv
+	}
+	key, err := credentials.APIKey(ctx, "openai", envName)
+	// Self-hosted servers usually don't require a key – only insist on one
+	// when talking to the public API or when a source was configured
+	// explicitly.
+	if errors.Is(err, credentials.ErrNotFound) && baseURL(cfg) != defaultBaseURL && (cfg == nil || cfg.APIKeyEnv == "") && !credentials.Configured(ctx, "openai") {
+		return "", nil
+	}
+	return key, err
+}
+
+// isReasoningModel reports whether model is one of OpenAI's reasoning
+// models, which reject the sampling parameters of the other models.
+func isReasoningModel(model string) bool {
+	m := strings.ToLower(model)
+	for _, prefix := range []string{"o1", "o3", "o4", "gpt-5"} {
+		if strings.HasPrefix(m, prefix) {
+			return true
 		}
 	}
-	return "", fmt.Errorf("openai: unsupported model mapping for family=%s size=%s", fam, sz)
+	return false
+}
+
+// supportedGeneration returns the parameters of gen that model accepts,
+// warning about the ones it drops: reasoning models reject temperature
+// and top_p, and the other models reject reasoning_effort.
+func supportedGeneration(model string, gen config.GenerationParams) config.GenerationParams {
+	var dropped []string
+	if isReasoningModel(model) {
+		if gen.Temperature != nil {
+			dropped = append(dropped, "temperature")
+			gen.Temperature = nil
+		}
+		if gen.TopP != nil {
+			dropped = append(dropped, "topP")
+			gen.TopP = nil
+		}
+		// only the gpt-5 family accepts "minimal".
+		if gen.ReasoningEffort == config.ReasoningEffortMinimal && !strings.HasPrefix(strings.ToLower(model), "gpt-5") {
+			gen.ReasoningEffort = config.ReasoningEffortLow
+		}
+	} else if gen.ReasoningEffort != "" {
+		dropped = append(dropped, "reasoningEffort")
+		gen.ReasoningEffort = ""
+	}
+	if len(dropped) > 0 {
+		fmt.Printf("warning: openai model %s does not support %s, ignoring\n", model, strings.Join(dropped, ", "))
+	}
+	return gen
+}
+
+// isJSONSchemaUnsupported reports whether err is the server rejecting the
+// `json_schema` response format.
+func isJSONSchemaUnsupported(err error) bool {
+	var apiErr openaiErrorResponse
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	e := apiErr.OpenAIError
+	text := strings.ToLower(e.Param + " " + e.Message)
+	return strings.Contains(text, "response_format") || strings.Contains(text, "json_schema")
+}
+
+// schemaInstructions renders the structured output schema as prompt text,
+// used when the server cannot enforce it natively.
+func schemaInstructions(structuredOutput schema.StructuredOutputSchema) string {
+	b, _ := json.MarshalIndent(structuredOutput.Schema, "", "  ")
+	return "\n\n# Output Format\n" +
+		"Respond ONLY with a single JSON object, without any surrounding text or code fences. " +
+		"The JSON object MUST conform to the following JSON schema:\n" +
+		string(b) + "\n"
 }
 
 // GetModuleContext calls the LLM and returns a parsed ModuleSelfContainedContext
-// value using the model derived from family/size.
-func GetModuleContext(systemMessage, userMessage string) (*payload.ModuleSelfContainedContext, error) {
-	model := "o4-mini"
-	openaiResp, err := callOpenAI(systemMessage, userMessage, schema.GetModuleContextSchema(), model)
+// value. params are merged into the request body.
+func GetModuleContext(ctx context.Context, cfg *config.OpenAIConfig, model string, params map[string]any, systemMessage, userMessage string) (*payload.ModuleSelfContainedContext, error) {
+	openaiResp, err := callOpenAI(ctx, cfg, systemMessage, userTurn(userMessage), schema.GetModuleContextSchema(), model, params, config.GenerationParams{})
 	if err != nil {
-		var openAIErrResp openaiErrorResponse
-		if errors.As(err, &openAIErrResp) {
-			if openAIErrResp.OpenAIError.Code == "rate_limit_exceeded" {
-				fmt.Printf("Rate limit exceeded, retrying after 30s\n")
-				<-time.After(30 * time.Second)
-				return GetModuleContext(systemMessage, userMessage)
-			}
-		}
 		return nil, err
 	}
-	var ctx payload.ModuleSelfContainedContext
-	if err := json.Unmarshal([]byte(openaiResp.Choices[0].Message.Content), &ctx); err != nil {
+	var moduleCtx payload.ModuleSelfContainedContext
+	if err := validate.Decode("openai", validate.ModuleContext, []byte(openaiResp.Choices[0].Message.Content), &moduleCtx); err != nil {
 		return nil, err
 	}
-	return &ctx, nil
+	return &moduleCtx, nil
 }
 
 // GetWorkspaceChangeProposals sends the given messages to the OpenAI API and
-// returns the structured workspace change proposal.
-func GetWorkspaceChangeProposals(fam config.ModelFamily, sz config.ModelSize, systemMessage, userMessage string) (*payload.WorkspaceChangeProposal, error) {
-	model, err := mapModel(fam, sz)
+// returns the structured workspace change proposal. The parameters of gen
+// that model does not support are dropped with a warning.
+func GetWorkspaceChangeProposals(ctx context.Context, cfg *config.OpenAIConfig, model string, params map[string]any, gen config.GenerationParams, systemMessage, userMessage string) (*payload.WorkspaceChangeProposal, error) {
+	openaiResp, err := callOpenAI(ctx, cfg, systemMessage, userTurn(userMessage), schema.GetWorkspaceChangeProposalSchema(), model, params, gen)
 	if err != nil {
 		return nil, err
 	}
 
-	openaiResp, err := callOpenAI(systemMessage, userMessage, schema.GetWorkspaceChangeProposalSchema(), model)
+	var proposal payload.WorkspaceChangeProposal
+	if err := validate.Decode("openai", validate.WorkspaceChangeProposal, []byte(openaiResp.Choices[0].Message.Content), &proposal); err != nil {
+		return nil, err
+	}
+	return &proposal, nil
+}
+
+// GetChatReply continues the conversation in history, whose last message
+// is the user's, and returns the parsed assistant reply.
+func GetChatReply(ctx context.Context, cfg *config.OpenAIConfig, model string, params map[string]any, gen config.GenerationParams, systemMessage string, history []payload.Message) (*payload.ChatReply, error) {
+	openaiResp, err := callOpenAI(ctx, cfg, systemMessage, history, schema.GetChatReplySchema(), model, params, gen)
 	if err != nil {
 		return nil, err
 	}
 
-	var proposal payload.WorkspaceChangeProposal
-	if err := json.Unmarshal([]byte(openaiResp.Choices[0].Message.Content), &proposal); err != nil {
+	var reply payload.ChatReply
+	if err := validate.Decode("openai", validate.ChatReply, []byte(openaiResp.Choices[0].Message.Content), &reply); err != nil {
 		return nil, err
 	}
-	return &proposal, nil
+	return &reply, nil
+}
+
+// userTurn wraps a single user message into a history.
+func userTurn(userMessage string) []payload.Message {
+	return []payload.Message{{Role: payload.RoleUser, Content: userMessage}}
 }
 
-// callOpenAI sends a request to OpenAI, returns the parsed response, and logs
-// the request/response pair to a uniquely-named JSON file in the OS temp dir.
-func callOpenAI(systemMessage, userMessage string, structuredOutput schema.StructuredOutputSchema, model string) (*openaiResponse, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return nil, errors.New("OPENAI_API_KEY is not set")
+// callOpenAI sends a request to OpenAI (or the OpenAI-compatible server
+// configured in cfg) and returns the parsed response. Every request/response
+// pair is handed to the transcript logger attached to ctx, if any.
+//
+// Servers that do not support the `json_schema` response format are
+// retried once with `json_object` and the schema embedded in the prompt.
+func callOpenAI(ctx context.Context, cfg *config.OpenAIConfig, systemMessage string, history []payload.Message, structuredOutput schema.StructuredOutputSchema, model string, params map[string]any, gen config.GenerationParams) (*openaiResponse, error) {
+	url, err := chatCompletionsURL(cfg, model)
+	if err != nil {
+		return nil, err
+	}
+	gen = supportedGeneration(model, gen)
+	if _, unsupported := jsonSchemaUnsupported.Load(url); unsupported {
+		return sendOpenAI(ctx, cfg, systemMessage+schemaInstructions(structuredOutput), history, responseFormat{Type: "json_object"}, model, params, gen)
+	}
+
+	resp, err := sendOpenAI(ctx, cfg, systemMessage, history, responseFormat{Type: "json_schema", JSONSchema: &structuredOutput}, model, params, gen)
+	if err != nil && isJSONSchemaUnsupported(err) {
+		fmt.Printf("%s does not support json_schema response formats, falling back to json_object\n", url)
+		jsonSchemaUnsupported.Store(url, struct{}{})
+		return sendOpenAI(ctx, cfg, systemMessage+schemaInstructions(structuredOutput), history, responseFormat{Type: "json_object"}, model, params, gen)
+	}
+	return resp, err
+}
+
+// sendOpenAI performs a single chat-completions request.
+func sendOpenAI(ctx context.Context, cfg *config.OpenAIConfig, systemMessage string, history []payload.Message, format responseFormat, model string, params map[string]any, gen config.GenerationParams) (*openaiResponse, error) {
+	apiKey, err := apiKey(ctx, cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	// Construct request payload.
+	messages := []message{{Role: "system", Content: systemMessage}}
+	for _, m := range history {
+		messages = append(messages, message{Role: string(m.Role), Content: m.Content})
+	}
 	reqPayload := request{
-		Model: model,
-		Messages: []message{
-			{
-				Role:    "system",
-				Content: systemMessage,
-			},
-			{
-				Role:    "user",
-				Content: userMessage,
-			},
-		},
-		ResponseFormat: responseFormat{
-			Type:       "json_schema",
-			JSONSchema: structuredOutput,
-		},
+		Model:               model,
+		Messages:            messages,
+		ResponseFormat:      format,
+		Temperature:         gen.Temperature,
+		TopP:                gen.TopP,
+		ReasoningEffort:     string(gen.ReasoningEffort),
+		MaxCompletionTokens: gen.MaxOutputTokens,
+		Seed:                gen.Seed,
+	}
+	// Streamed responses report their progress as they are received.
+	if progress.Enabled(ctx) {
+		reqPayload.Stream = true
+		reqPayload.StreamOptions = &streamOptions{IncludeUsage: true}
 	}
 
 	reqBytes, err := json.MarshalIndent(reqPayload, "", "  ")
 	if err != nil {
 		return nil, err
 	}
+	if reqBytes, err = transport.MergeParams(reqBytes, params); err != nil {
+		return nil, err
+	}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(reqBytes))
+	endpoint, err := chatCompletionsURL(cfg, model)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(reqBytes))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
-
-	fmt.Printf("About to call OpenAI\n")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	fmt.Printf("Fininshed calling OpenAI\n")
+	if azure(cfg) != nil {
+		req.Header.Set("api-key", apiKey)
+	} else if apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	}
 
+	tracker := progress.Start(ctx, "openai "+model)
+	resp, err := transport.Client(ctx).Do(req)
 	if err != nil {
-		fmt.Printf("Got an error back %v\n", err)
+		tracker.Done()
+		transcript.Write(ctx, transcript.Entry{Provider: "openai", URL: req.URL.String(), Request: reqBytes, Error: err.Error()}, apiKey)
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
+	var openaiResp *openaiResponse
+	var respBytes []byte
+	if resp.StatusCode == http.StatusOK && transport.IsEventStream(resp) {
+		openaiResp, err = readStream(resp.Body, tracker)
+		tracker.Done()
+		if err != nil {
+			transcript.Write(ctx, transcript.Entry{Provider: "openai", URL: req.URL.String(), Status: resp.StatusCode, Request: reqBytes, Error: err.Error()}, apiKey)
+			streamErr := transport.NewStreamError(resp, err)
+			var errorResp openaiErrorResponse
+			streamErr.QuotaExhausted = errors.As(err, &errorResp) && errorResp.OpenAIError.Code == "insufficient_quota"
+			return nil, streamErr
+		}
+		// the transcript holds the response as assembled from the stream.
+		respBytes, _ = json.Marshal(openaiResp)
+	} else {
+		respBytes, err = io.ReadAll(resp.Body)
+		tracker.Done()
+		if err != nil {
+			transcript.Write(ctx, transcript.Entry{Provider: "openai", URL: req.URL.String(), Status: resp.StatusCode, Request: reqBytes, Error: err.Error()}, apiKey)
+			return nil, err
+		}
+	}
+	transcript.Write(ctx, transcript.Entry{
+		Provider: "openai",
+		URL:      req.URL.String(),
+		Status:   resp.StatusCode,
+		Request:  reqBytes,
+		Response: transcript.Body(respBytes),
+	}, apiKey)
 
+	if resp.StatusCode != http.StatusOK {
 		var errorResp openaiErrorResponse
-		if err := json.Unmarshal(bodyBytes, &errorResp); err != nil {
-			fmt.Printf("Response code %d, aborting\nOpenAI API error: %s\n", resp.StatusCode, string(bodyBytes))
-			return nil, fmt.Errorf("OpenAI API error: %s", string(bodyBytes))
+		if err := json.Unmarshal(respBytes, &errorResp); err != nil {
+			fmt.Printf("Response code %d, aborting\nOpenAI API error: %s\n", resp.StatusCode, string(respBytes))
+			return nil, transport.NewError(resp, fmt.Errorf("OpenAI API error: %s", string(respBytes)))
 		}
 
-		return nil, errorResp
+		apiErr := transport.NewError(resp, errorResp)
+		apiErr.QuotaExhausted = errorResp.OpenAIError.Code == "insufficient_quota"
+		return nil, apiErr
 	}
 
-	respBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error when reading response body%v\n", err)
-		return nil, err
-	}
-
-	var openaiResp openaiResponse
-	if err := json.Unmarshal(respBytes, &openaiResp); err != nil {
-		return nil, err
+	if openaiResp == nil {
+		openaiResp = &openaiResponse{}
+		if err := json.Unmarshal(respBytes, openaiResp); err != nil {
+			return nil, err
+		}
 	}
 
 	if len(openaiResp.Choices) == 0 {
 		return nil, errors.New("no choices returned from OpenAI")
 	}
+	// Deployment names mean nothing to the price table: on Azure, record
+	// the model that actually served the request.
+	reported := model
+	if azure(cfg) != nil && openaiResp.Model != "" {
+		reported = openaiResp.Model
+	}
+	usage.Report(ctx, "openai", reported, openaiResp.Usage.PromptTokens, openaiResp.Usage.CompletionTokens)
 
-	// ------------------------------------------------------------
-	// Persist request and response to a unique temp-file for debug.
-	// ------------------------------------------------------------
-	logEntry := struct {
-		Request  json.RawMessage `json:"request"`
-		Response json.RawMessage `json:"response"`
-	}{
-		Request:  reqBytes,
-		Response: respBytes,
-	}
-
-	if logBytes, err := json.MarshalIndent(logEntry, "", "  "); err == nil {
-		if f, err := os.CreateTemp("", "vyb-openai-*.json"); err == nil {
-			if _, wErr := f.Write(logBytes); wErr == nil {
-				_ = f.Close()
-			} else {
-				fmt.Printf("error writing OpenAI log file: %v\n", wErr)
-			}
-			fmt.Printf("Wrote OpenAI log file to %s\n", f.Name())
-		} else {
-			fmt.Printf("error creating OpenAI log file: %v\n", err)
+	return openaiResp, nil
+}
+
+// readStream assembles a streamed chat completion into the response the
+// non-streaming API would have returned, reporting every chunk to tracker.
+func readStream(body io.Reader, tracker *progress.Tracker) (*openaiResponse, error) {
+	var out openaiResponse
+	var content strings.Builder
+	err := transport.ReadEvents(body, func(data []byte) error {
+		var chunk openaiChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("openai: invalid stream event: %w", err)
 		}
-	} else {
-		fmt.Printf("error marshalling OpenAI log entry: %v\n", err)
+		if e := chunk.Error; e != nil {
+			var apiErr openaiErrorResponse
+			apiErr.OpenAIError.Message, apiErr.OpenAIError.Type, apiErr.OpenAIError.Code = e.Message, e.Type, e.Code
+			return apiErr
+		}
+		if chunk.Model != "" {
+			out.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			out.Usage = *chunk.Usage
+		}
+		if len(chunk.Choices) > 0 {
+			content.WriteString(chunk.Choices[0].Delta.Content)
+			tracker.Add(chunk.Choices[0].Delta.Content)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	return &openaiResp, nil
+	if content.Len() > 0 {
+		out.Choices = []choice{{Message: message{Role: "assistant", Content: content.String()}}}
+	}
+	return &out, nil
 }
 
 // GetModuleExternalContexts calls the LLM and returns a list of external
 // context strings – one per module.
-func GetModuleExternalContexts(systemMessage, userMessage string) (*payload.ModuleExternalContextResponse, error) {
-	model := "o4-mini"
-	openaiResp, err := callOpenAI(systemMessage, userMessage, schema.GetModuleExternalContextSchema(), model)
+func GetModuleExternalContexts(ctx context.Context, cfg *config.OpenAIConfig, model string, params map[string]any, systemMessage, userMessage string) (*payload.ModuleExternalContextResponse, error) {
+	openaiResp, err := callOpenAI(ctx, cfg, systemMessage, userTurn(userMessage), schema.GetModuleExternalContextSchema(), model, params, config.GenerationParams{})
 	if err != nil {
 		return nil, err
 	}
 
 	var resp payload.ModuleExternalContextResponse
-	if err := json.Unmarshal([]byte(openaiResp.Choices[0].Message.Content), &resp); err != nil {
+	if err := validate.Decode("openai", validate.ModuleExternalContext, []byte(openaiResp.Choices[0].Message.Content), &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
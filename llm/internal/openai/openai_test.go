@@ -0,0 +1,398 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/internal/credentials"
+	"github.com/vybdev/vyb/llm/internal/transport"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/llm/progress"
+	"github.com/vybdev/vyb/llm/usage"
+)
+
+// completion wraps content in a minimal chat-completions response body.
+func completion(content string) map[string]any {
+	return map[string]any{
+		"choices": []any{
+			map[string]any{
+				"message": map[string]any{"role": "assistant", "content": content},
+			},
+		},
+	}
+}
+
+func TestGetModuleContext_CompatibleServer(t *testing.T) {
+	var got request
+	var raw map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("expected no Authorization header, got %q", auth)
+		}
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &got)
+		_ = json.Unmarshal(body, &raw)
+		_ = json.NewEncoder(w).Encode(completion(`{"internal_context":"i","public_context":"p"}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("OPENAI_API_KEY", "")
+	cfg := &config.OpenAIConfig{BaseURL: srv.URL + "/v1/"}
+
+	ctx, err := GetModuleContext(context.Background(), cfg, "qwen3:8b", map[string]any{"temperature": 0.2}, "sys", "usr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &payload.ModuleSelfContainedContext{InternalContext: "i", PublicContext: "p"}
+	if !reflect.DeepEqual(ctx, want) {
+		t.Fatalf("unexpected ctx: %+v", ctx)
+	}
+	if got.Model != "qwen3:8b" {
+		t.Fatalf("expected model qwen3:8b, got %q", got.Model)
+	}
+	if raw["temperature"] != 0.2 {
+		t.Fatalf("expected model params to be merged into the request, got %v", raw)
+	}
+	if got.ResponseFormat.Type != "json_schema" || got.ResponseFormat.JSONSchema == nil {
+		t.Fatalf("expected json_schema response format, got %+v", got.ResponseFormat)
+	}
+}
+
+func TestGetChatReply_SendsHistory(t *testing.T) {
+	var got request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &got)
+		_ = json.NewEncoder(w).Encode(completion(`{"answer":"kept","summary":"","description":"","proposals":[]}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("OPENAI_API_KEY", "")
+	cfg := &config.OpenAIConfig{BaseURL: srv.URL + "/v1/"}
+	history := []payload.Message{
+		{Role: payload.RoleUser, Content: "change the signature"},
+		{Role: payload.RoleAssistant, Content: `{"answer":"done"}`},
+		{Role: payload.RoleUser, Content: "no, keep the old signature"},
+	}
+
+	reply, err := GetChatReply(context.Background(), cfg, "o3", nil, config.GenerationParams{}, "sys", history)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reply.Answer != "kept" || reply.Proposal() != nil {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+	want := []message{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "change the signature"},
+		{Role: "assistant", Content: `{"answer":"done"}`},
+		{Role: "user", Content: "no, keep the old signature"},
+	}
+	if !reflect.DeepEqual(got.Messages, want) {
+		t.Fatalf("unexpected messages: %+v", got.Messages)
+	}
+	if got.ResponseFormat.JSONSchema == nil || got.ResponseFormat.JSONSchema.Name != "chat_reply" {
+		t.Fatalf("expected the chat_reply schema, got %+v", got.ResponseFormat)
+	}
+}
+
+func TestGetWorkspaceChangeProposals_JSONObjectFallback(t *testing.T) {
+	var formats []string
+	var lastSystem string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		formats = append(formats, req.ResponseFormat.Type)
+		lastSystem = req.Messages[0].Content
+		if req.ResponseFormat.Type == "json_schema" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"message":"response_format json_schema is not supported","type":"invalid_request_error","param":"response_format"}}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(completion(`{"summary":"s","description":"d","proposals":[]}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("LOCAL_KEY", "secret")
+	cfg := &config.OpenAIConfig{BaseURL: srv.URL, APIKeyEnv: "LOCAL_KEY"}
+
+	for i := 0; i < 2; i++ {
+		prop, err := GetWorkspaceChangeProposals(context.Background(), cfg, "gpt-4.1-mini", nil, config.GenerationParams{}, "sys", "usr")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if prop.Summary != "s" {
+			t.Fatalf("unexpected proposal: %+v", prop)
+		}
+	}
+
+	// The first call discovers the missing support, the second one goes
+	// straight to json_object.
+	want := []string{"json_schema", "json_object", "json_object"}
+	if !reflect.DeepEqual(formats, want) {
+		t.Fatalf("response formats = %v, want %v", formats, want)
+	}
+	if !strings.Contains(lastSystem, `"proposals"`) {
+		t.Fatalf("expected schema to be embedded in the system message, got:\n%s", lastSystem)
+	}
+}
+
+func TestGenerationParams(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = nil
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &got)
+		_ = json.NewEncoder(w).Encode(completion(`{"summary":"s","description":"d","proposals":[]}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("OPENAI_API_KEY", "")
+	cfg := &config.OpenAIConfig{BaseURL: srv.URL + "/v1/"}
+	temp, topP, seed := 0.2, 0.9, int64(7)
+	gen := config.GenerationParams{Temperature: &temp, TopP: &topP, ReasoningEffort: config.ReasoningEffortHigh, MaxOutputTokens: 2048, Seed: &seed}
+
+	tests := []struct {
+		model   string
+		want    map[string]any
+		missing []string
+	}{
+		{
+			model:   "gpt-4.1",
+			want:    map[string]any{"temperature": 0.2, "top_p": 0.9, "max_completion_tokens": 2048.0, "seed": 7.0},
+			missing: []string{"reasoning_effort"},
+		},
+		{
+			model:   "o4-mini",
+			want:    map[string]any{"reasoning_effort": "high", "max_completion_tokens": 2048.0, "seed": 7.0},
+			missing: []string{"temperature", "top_p"},
+		},
+	}
+	for _, tc := range tests {
+		if _, err := GetWorkspaceChangeProposals(context.Background(), cfg, tc.model, nil, gen, "sys", "usr"); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.model, err)
+		}
+		for k, v := range tc.want {
+			if got[k] != v {
+				t.Errorf("%s: expected %s=%v, got %v", tc.model, k, v, got[k])
+			}
+		}
+		for _, k := range tc.missing {
+			if _, ok := got[k]; ok {
+				t.Errorf("%s: expected %s to be dropped, got %v", tc.model, k, got[k])
+			}
+		}
+	}
+
+	// unset parameters are left to the server.
+	if _, err := GetWorkspaceChangeProposals(context.Background(), cfg, "gpt-4.1", nil, config.GenerationParams{}, "sys", "usr"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, k := range []string{"temperature", "top_p", "reasoning_effort", "max_completion_tokens", "seed"} {
+		if _, ok := got[k]; ok {
+			t.Errorf("expected %s to be omitted, got %v", k, got[k])
+		}
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	t.Setenv("OPENAI_BASE_URL", "")
+	if got := baseURL(nil); got != defaultBaseURL {
+		t.Errorf("baseURL(nil) = %q, want %q", got, defaultBaseURL)
+	}
+	t.Setenv("OPENAI_BASE_URL", "http://127.0.0.1:8080/v1/")
+	if got := baseURL(nil); got != "http://127.0.0.1:8080/v1" {
+		t.Errorf("expected the environment to override the default, got %q", got)
+	}
+	if got := baseURL(&config.OpenAIConfig{BaseURL: "http://localhost:11434/v1"}); got != "http://localhost:11434/v1" {
+		t.Errorf("expected the configuration to take precedence over the environment, got %q", got)
+	}
+	if key, err := apiKey(context.Background(), nil); err != nil || key != "" {
+		t.Errorf("expected unauthenticated access for a base URL set in the environment, got key=%q err=%v", key, err)
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("OPENAI_BASE_URL", "")
+	t.Setenv("LOCAL_KEY", "")
+
+	if _, err := apiKey(context.Background(), nil); err == nil {
+		t.Fatalf("expected error when OPENAI_API_KEY is missing for the public API")
+	}
+	if key, err := apiKey(context.Background(), &config.OpenAIConfig{BaseURL: "http://localhost"}); err != nil || key != "" {
+		t.Fatalf("expected unauthenticated access for custom base URL, got key=%q err=%v", key, err)
+	}
+	if _, err := apiKey(context.Background(), &config.OpenAIConfig{BaseURL: "http://localhost", APIKeyEnv: "LOCAL_KEY"}); err == nil || !strings.Contains(err.Error(), "LOCAL_KEY") {
+		t.Fatalf("expected error naming LOCAL_KEY, got %v", err)
+	}
+	// Configured credential sources must yield a key, even for custom base
+	// URLs.
+	ctx := credentials.WithSources(context.Background(), map[string]*config.CredentialConfig{"openai": {APIKeyFile: "/nonexistent/openai.key"}})
+	if _, err := apiKey(ctx, &config.OpenAIConfig{BaseURL: "http://localhost"}); err == nil || !strings.Contains(err.Error(), "openai.key") {
+		t.Fatalf("expected error naming the key file, got %v", err)
+	}
+	// The sources tried name the variable actually consulted.
+	if _, err := apiKey(ctx, &config.OpenAIConfig{APIKeyEnv: "LOCAL_KEY"}); err == nil || !strings.Contains(err.Error(), "env LOCAL_KEY (not set)") {
+		t.Fatalf("expected error naming LOCAL_KEY, got %v", err)
+	}
+	t.Setenv("AZURE_OPENAI_API_KEY", "")
+	if _, err := apiKey(ctx, &config.OpenAIConfig{APIKeyEnv: "LOCAL_KEY", Azure: &config.AzureOpenAIConfig{Endpoint: "https://x"}}); err == nil || !strings.Contains(err.Error(), "env AZURE_OPENAI_API_KEY (not set)") {
+		t.Fatalf("expected error naming AZURE_OPENAI_API_KEY, got %v", err)
+	}
+}
+
+func TestSendOpenAI_ReportsUsage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := completion(`{"internal_context":"i","public_context":"p"}`)
+		resp["usage"] = map[string]any{"prompt_tokens": 1200, "completion_tokens": 300}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	ctx := usage.WithCommand(usage.WithLedger(context.Background(), usage.NewLedger(root, nil)), "init")
+	if _, err := GetModuleContext(ctx, &config.OpenAIConfig{BaseURL: srv.URL}, "o4-mini", nil, "sys", "usr"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	records, err := usage.Load(usage.Path(root))
+	if err != nil || len(records) != 1 {
+		t.Fatalf("expected one usage record, got %v (err %v)", records, err)
+	}
+	r := records[0]
+	if r.Provider != "openai" || r.Command != "init" || r.Model != "o4-mini" || r.InputTokens != 1200 || r.OutputTokens != 300 {
+		t.Fatalf("unexpected usage record: %+v", r)
+	}
+}
+
+func TestGetWorkspaceChangeProposals_Streaming(t *testing.T) {
+	var got request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &got)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`{"model":"o3-2025","choices":[{"delta":{"role":"assistant","content":""}}]}`,
+			`{"choices":[{"delta":{"content":"{\"summary\":\"s\",\"description\":\"d\","}}]}`,
+			`{"choices":[{"delta":{"content":"\"proposals\":[{\"file_name\":\"a.go\",\"content\":\"package a\",\"delete\":false}]}"}}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":1200,"completion_tokens":300}}`,
+			`[DONE]`,
+		} {
+			_, _ = io.WriteString(w, "data: "+event+"\n\n")
+		}
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	var out strings.Builder
+	ctx := progress.WithWriter(usage.WithLedger(context.Background(), usage.NewLedger(root, nil)), &out)
+	proposal, err := GetWorkspaceChangeProposals(ctx, &config.OpenAIConfig{BaseURL: srv.URL}, "o3", nil, config.GenerationParams{}, "sys", "usr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !got.Stream || got.StreamOptions == nil || !got.StreamOptions.IncludeUsage {
+		t.Fatalf("expected a streaming request, got %+v", got)
+	}
+	want := &payload.WorkspaceChangeProposal{Summary: "s", Description: "d", Proposals: []payload.FileChangeProposal{{FileName: "a.go", Content: "package a"}}}
+	if !reflect.DeepEqual(proposal, want) {
+		t.Fatalf("unexpected proposal: %+v", proposal)
+	}
+	if !strings.Contains(out.String(), "openai o3: ~") || !strings.Contains(out.String(), "writing a.go") {
+		t.Errorf("unexpected progress output %q", out.String())
+	}
+	records, err := usage.Load(usage.Path(root))
+	if err != nil || len(records) != 1 || records[0].InputTokens != 1200 || records[0].OutputTokens != 300 {
+		t.Fatalf("expected the usage of the last event to be recorded, got %v (err %v)", records, err)
+	}
+}
+
+func TestGetChatReply_StreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"{\\\"answer\"}}]}\n\n")
+		_, _ = io.WriteString(w, "data: {\"error\":{\"message\":\"server overloaded\",\"type\":\"server_error\"}}\n\n")
+	}))
+	defer srv.Close()
+
+	ctx := progress.WithWriter(context.Background(), io.Discard)
+	_, err := GetChatReply(ctx, &config.OpenAIConfig{BaseURL: srv.URL}, "gpt-4.1", nil, config.GenerationParams{}, "sys", userTurn("hi"))
+	if err == nil || !strings.Contains(err.Error(), "server overloaded") {
+		t.Fatalf("expected the stream error, got %v", err)
+	}
+	var apiErr *transport.Error
+	if !errors.As(err, &apiErr) || !apiErr.Interrupted {
+		t.Fatalf("expected a retryable transport error, got %#v", err)
+	}
+}
+
+func TestAzure_DeploymentRequest(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		resp := completion(`{"internal_context":"i","public_context":"p"}`)
+		resp["model"] = "o4-mini-2025-04-16"
+		resp["usage"] = map[string]any{"prompt_tokens": 10, "completion_tokens": 5}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	t.Setenv("OPENAI_API_KEY", "public-key")
+	t.Setenv("AZURE_OPENAI_API_KEY", "azure-key")
+	root := t.TempDir()
+	ctx := usage.WithLedger(context.Background(), usage.NewLedger(root, nil))
+	cfg := &config.OpenAIConfig{Azure: &config.AzureOpenAIConfig{Endpoint: srv.URL + "/", APIVersion: "2025-01-01-preview"}}
+
+	if _, err := GetModuleContext(ctx, cfg, "mini prod", nil, "sys", "usr"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.URL.Path != "/openai/deployments/mini prod/chat/completions" {
+		t.Errorf("unexpected path %q", got.URL.Path)
+	}
+	if v := got.URL.Query().Get("api-version"); v != "2025-01-01-preview" {
+		t.Errorf("api-version = %q", v)
+	}
+	if k := got.Header.Get("api-key"); k != "azure-key" {
+		t.Errorf("api-key header = %q, want the Azure key", k)
+	}
+	if auth := got.Header.Get("Authorization"); auth != "" {
+		t.Errorf("expected no Authorization header, got %q", auth)
+	}
+
+	records, err := usage.Load(usage.Path(root))
+	if err != nil || len(records) != 1 || records[0].Model != "o4-mini-2025-04-16" {
+		t.Fatalf("expected usage to be recorded under the served model, got %v (err %v)", records, err)
+	}
+}
+
+func TestAzure_Defaults(t *testing.T) {
+	t.Setenv("AZURE_OPENAI_API_KEY", "")
+	t.Setenv("TENANT_KEY", "k")
+
+	az := &config.OpenAIConfig{Azure: &config.AzureOpenAIConfig{Endpoint: "https://r.openai.azure.com"}}
+	got, err := chatCompletionsURL(az, "o3")
+	if err != nil || got != "https://r.openai.azure.com/openai/deployments/o3/chat/completions?api-version="+defaultAzureAPIVersion {
+		t.Fatalf("chatCompletionsURL() = %q, %v", got, err)
+	}
+	if _, err := apiKey(context.Background(), az); err == nil || !strings.Contains(err.Error(), "AZURE_OPENAI_API_KEY") {
+		t.Fatalf("expected error naming AZURE_OPENAI_API_KEY, got %v", err)
+	}
+	az.Azure.APIKeyEnv = "TENANT_KEY"
+	if key, err := apiKey(context.Background(), az); err != nil || key != "k" {
+		t.Fatalf("apiKey() = %q, %v", key, err)
+	}
+	if _, err := chatCompletionsURL(&config.OpenAIConfig{Azure: &config.AzureOpenAIConfig{}}, "o3"); err == nil {
+		t.Fatalf("expected an error without endpoint")
+	}
+}
@@ -0,0 +1,44 @@
+package schema
+
+import (
+	"encoding/json"
+
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
+
+// Schema is the JSON schema a plugin result must conform to. It is sent
+// verbatim to the plugin, which decides how to enforce it.
+type Schema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+// GetWorkspaceChangeProposalSchema returns the schema of workspace change
+// proposals.
+func GetWorkspaceChangeProposalSchema() Schema {
+	return getSchema(schemas.WorkspaceChangeProposal)
+}
+
+// GetModuleContextSchema returns the schema of the internal and public
+// context of a module.
+func GetModuleContextSchema() Schema {
+	return getSchema(schemas.ModuleContext)
+}
+
+// GetModuleExternalContextSchema returns the schema of external contexts
+// generated in bulk.
+func GetModuleExternalContextSchema() Schema {
+	return getSchema(schemas.ModuleExternalContext)
+}
+
+// GetChatReplySchema returns the schema of a `vyb chat` reply.
+func GetChatReplySchema() Schema {
+	return getSchema(schemas.ChatReply)
+}
+
+// getSchema returns the canonical schema itself: results are validated
+// against it, whatever the plugin does with it.
+func getSchema(file string) Schema {
+	s := schemas.Get(file)
+	return Schema{Name: s.Name, Schema: s.Schema}
+}
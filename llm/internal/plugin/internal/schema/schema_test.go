@@ -0,0 +1,19 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
+
+func TestSchemasMatchCanonical(t *testing.T) {
+	for _, file := range schemas.All {
+		s := getSchema(file)
+		if s.Name != schemas.Get(file).Name {
+			t.Errorf("%s: name %q, want %q", file, s.Name, schemas.Get(file).Name)
+		}
+		if d := schemas.Diff(schemas.Get(file).Schema, s.Schema); d != "" {
+			t.Errorf("%s diverges from the canonical schema: %s", file, d)
+		}
+	}
+}
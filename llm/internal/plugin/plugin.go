@@ -0,0 +1,260 @@
+// Package plugin implements the exec plugin provider: an executable,
+// configured under `plugins` in .vyb/config.yaml, that answers LLM requests
+// on behalf of vyb.
+//
+// Every call starts the executable once, writes a single JSON Request to
+// its stdin and reads a single JSON Response from its stdout. Anything the
+// plugin writes to stderr is only surfaced when the call fails. The result
+// must conform to the JSON schema sent with the request, exactly as the
+// structured output of the built-in providers does.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/internal/plugin/internal/schema"
+	"github.com/vybdev/vyb/llm/internal/transport"
+	"github.com/vybdev/vyb/llm/internal/validate"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/llm/transcript"
+	"github.com/vybdev/vyb/llm/usage"
+)
+
+// ProtocolVersion is sent with every request. It is bumped whenever the
+// protocol changes in a way existing plugins cannot ignore.
+const ProtocolVersion = 1
+
+// Methods mirror the operations of the llm provider interface.
+const (
+	MethodWorkspaceChangeProposals = "workspace_change_proposals"
+	MethodModuleContext            = "module_context"
+	MethodModuleExternalContexts   = "module_external_contexts"
+	MethodChat                     = "chat"
+)
+
+// Error types a plugin can report. They drive the retry and fallback
+// decisions exactly like the equivalent HTTP statuses of the built-in
+// providers; any other type fails the call as is.
+const (
+	ErrorRateLimited    = "rate_limited"
+	ErrorUnavailable    = "unavailable"
+	ErrorUnauthorized   = "unauthorized"
+	ErrorQuotaExhausted = "quota_exhausted"
+	ErrorInvalidRequest = "invalid_request"
+)
+
+// Model describes the model vyb asks for. ID and Params are only set when
+// the user configured them under `models.<plugin>`; a plugin is free to
+// map Family and Size on its own otherwise. Generation holds the
+// parameters requested by the command, if any; plugins apply those their
+// model supports.
+type Model struct {
+	Family     string                   `json:"family"`
+	Size       string                   `json:"size"`
+	ID         string                   `json:"id,omitempty"`
+	Params     map[string]any           `json:"params,omitempty"`
+	Generation *config.GenerationParams `json:"generation,omitempty"`
+}
+
+// Request is the document written to the plugin's stdin.
+type Request struct {
+	Version int    `json:"version"`
+	Method  string `json:"method"`
+	Model   Model  `json:"model"`
+	System  string `json:"system"`
+	// User is the user message of single-turn methods.
+	User string `json:"user,omitempty"`
+	// Messages is the conversation so far, for the chat method. Its last
+	// message is the user's.
+	Messages []payload.Message `json:"messages,omitempty"`
+	// Schema is the JSON schema Response.Result must conform to.
+	Schema schema.Schema `json:"schema"`
+}
+
+// Response is the document the plugin writes to its stdout. Exactly one of
+// Result and Error must be set.
+type Response struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+	// Usage is optional; when present the call is recorded in the usage
+	// ledger under the plugin name.
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// Error is a failure reported by the plugin.
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	// RetryAfter is the number of seconds to wait before retrying, when
+	// Type is rate_limited or unavailable.
+	RetryAfter float64 `json:"retryAfter,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+// Usage reports the tokens consumed by the call.
+type Usage struct {
+	Model        string `json:"model"`
+	InputTokens  int    `json:"inputTokens"`
+	OutputTokens int    `json:"outputTokens"`
+}
+
+// GetWorkspaceChangeProposals asks the plugin for a set of file changes.
+func GetWorkspaceChangeProposals(ctx context.Context, name string, cfg *config.PluginConfig, model Model, systemMessage, userMessage string) (*payload.WorkspaceChangeProposal, error) {
+	raw, err := call(ctx, name, cfg, Request{Method: MethodWorkspaceChangeProposals, Model: model, System: systemMessage, User: userMessage, Schema: schema.GetWorkspaceChangeProposalSchema()})
+	if err != nil {
+		return nil, err
+	}
+	var proposal payload.WorkspaceChangeProposal
+	if err := validate.Decode(name, validate.WorkspaceChangeProposal, raw, &proposal); err != nil {
+		return nil, err
+	}
+	return &proposal, nil
+}
+
+// GetModuleContext asks the plugin for the internal and public context of
+// a module.
+func GetModuleContext(ctx context.Context, name string, cfg *config.PluginConfig, model Model, systemMessage, userMessage string) (*payload.ModuleSelfContainedContext, error) {
+	raw, err := call(ctx, name, cfg, Request{Method: MethodModuleContext, Model: model, System: systemMessage, User: userMessage, Schema: schema.GetModuleContextSchema()})
+	if err != nil {
+		return nil, err
+	}
+	var moduleCtx payload.ModuleSelfContainedContext
+	if err := validate.Decode(name, validate.ModuleContext, raw, &moduleCtx); err != nil {
+		return nil, err
+	}
+	return &moduleCtx, nil
+}
+
+// GetModuleExternalContexts asks the plugin for the external context of
+// every module described in userMessage.
+func GetModuleExternalContexts(ctx context.Context, name string, cfg *config.PluginConfig, model Model, systemMessage, userMessage string) (*payload.ModuleExternalContextResponse, error) {
+	raw, err := call(ctx, name, cfg, Request{Method: MethodModuleExternalContexts, Model: model, System: systemMessage, User: userMessage, Schema: schema.GetModuleExternalContextSchema()})
+	if err != nil {
+		return nil, err
+	}
+	var ext payload.ModuleExternalContextResponse
+	if err := validate.Decode(name, validate.ModuleExternalContext, raw, &ext); err != nil {
+		return nil, err
+	}
+	return &ext, nil
+}
+
+// GetChatReply asks the plugin to continue the conversation in history,
+// whose last message is the user's.
+func GetChatReply(ctx context.Context, name string, cfg *config.PluginConfig, model Model, systemMessage string, history []payload.Message) (*payload.ChatReply, error) {
+	raw, err := call(ctx, name, cfg, Request{Method: MethodChat, Model: model, System: systemMessage, Messages: history, Schema: schema.GetChatReplySchema()})
+	if err != nil {
+		return nil, err
+	}
+	var reply payload.ChatReply
+	if err := validate.Decode(name, validate.ChatReply, raw, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// call runs the plugin once with req and returns the raw result it
+// produced.
+func call(ctx context.Context, name string, cfg *config.PluginConfig, req Request) (json.RawMessage, error) {
+	if cfg == nil || cfg.Command == "" {
+		return nil, fmt.Errorf("plugin %s: no command configured", name)
+	}
+	if req.User == "" && len(req.Messages) == 0 {
+		return nil, fmt.Errorf("plugin %s: user message must not be empty", name)
+	}
+
+	req.Version = ProtocolVersion
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cfg.Command, cfg.Args...)
+	cmd.Env = os.Environ()
+	for k, v := range cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdin = bytes.NewReader(reqBytes)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+	entry := transcript.Entry{Provider: name, URL: cfg.Command, Request: reqBytes, Response: transcript.Body(stdout.Bytes())}
+	if runErr != nil {
+		entry.Error = strings.TrimSpace(runErr.Error() + stderrSuffix(&stderr))
+	}
+	transcript.Write(ctx, entry)
+
+	if err := runErr; err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("plugin %s: %w", name, ctx.Err())
+		}
+		return nil, fmt.Errorf("plugin %s: %w%s", name, err, stderrSuffix(&stderr))
+	}
+
+	var resp Response
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("plugin %s: invalid response: %w%s", name, err, stderrSuffix(&stderr))
+	}
+	if resp.Error != nil {
+		return nil, toTransportError(name, resp.Error)
+	}
+	if len(resp.Result) == 0 {
+		return nil, fmt.Errorf("plugin %s: response has neither result nor error", name)
+	}
+	if resp.Usage != nil {
+		m := resp.Usage.Model
+		if m == "" {
+			m = req.Model.ID
+		}
+		usage.Report(ctx, name, m, resp.Usage.InputTokens, resp.Usage.OutputTokens)
+	}
+	return resp.Result, nil
+}
+
+// toTransportError maps the error types of the protocol onto the HTTP
+// metadata the retry and fallback policies understand.
+func toTransportError(name string, e *Error) error {
+	err := fmt.Errorf("plugin %s: %w", name, e)
+	te := &transport.Error{
+		RetryAfter: time.Duration(e.RetryAfter * float64(time.Second)),
+		Err:        err,
+	}
+	switch e.Type {
+	case ErrorRateLimited:
+		te.StatusCode = http.StatusTooManyRequests
+	case ErrorQuotaExhausted:
+		te.StatusCode = http.StatusTooManyRequests
+		te.QuotaExhausted = true
+	case ErrorUnavailable:
+		te.StatusCode = http.StatusServiceUnavailable
+	case ErrorUnauthorized:
+		te.StatusCode = http.StatusUnauthorized
+	case ErrorInvalidRequest:
+		te.StatusCode = http.StatusBadRequest
+	default:
+		return err
+	}
+	return te
+}
+
+func stderrSuffix(stderr *bytes.Buffer) string {
+	s := strings.TrimSpace(stderr.String())
+	if s == "" {
+		return ""
+	}
+	return ": " + s
+}
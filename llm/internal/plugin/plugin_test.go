@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vybdev/vyb/config"
+	"github.com/vybdev/vyb/llm/internal/transport"
+	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/llm/usage"
+)
+
+// echoPath is the reference plugin under testdata/echo, built once by
+// TestMain.
+var echoPath string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "vyb-plugin-*")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	echoPath = filepath.Join(dir, "echo")
+	if out, err := exec.Command("go", "build", "-o", echoPath, "./testdata/echo").CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "building the echo plugin: %v\n%s", err, out)
+		os.Exit(1)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func echoPlugin(t *testing.T, env map[string]string) *config.PluginConfig {
+	t.Helper()
+	return &config.PluginConfig{Command: echoPath, Env: env}
+}
+
+func TestGetWorkspaceChangeProposals(t *testing.T) {
+	cfg := echoPlugin(t, nil)
+	model := Model{Family: "gpt", Size: "large", ID: "gw-large"}
+
+	got, err := GetWorkspaceChangeProposals(context.Background(), "echo", cfg, model, "sys", "hello")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Description != "gpt/large/gw-large" || got.Summary != "workspace_change_proposal" {
+		t.Errorf("request not forwarded as expected: %+v", got)
+	}
+	if len(got.Proposals) != 1 || got.Proposals[0].FileName != "echo.txt" || got.Proposals[0].Content != "hello" {
+		t.Errorf("unexpected proposals: %+v", got.Proposals)
+	}
+}
+
+func TestGetModuleContexts(t *testing.T) {
+	cfg := echoPlugin(t, nil)
+	model := Model{Family: "reasoning", Size: "small"}
+
+	moduleCtx, err := GetModuleContext(context.Background(), "echo", cfg, model, "sys", "usr")
+	if err != nil {
+		t.Fatalf("GetModuleContext: %v", err)
+	}
+	if moduleCtx.InternalContext != "sys" || moduleCtx.PublicContext != "usr" {
+		t.Errorf("unexpected module context: %+v", moduleCtx)
+	}
+
+	ext, err := GetModuleExternalContexts(context.Background(), "echo", cfg, model, "sys", "usr")
+	if err != nil {
+		t.Fatalf("GetModuleExternalContexts: %v", err)
+	}
+	if len(ext.Modules) != 1 || ext.Modules[0].ExternalContext != "usr" {
+		t.Errorf("unexpected external contexts: %+v", ext)
+	}
+}
+
+func TestCall_ErrorTypes(t *testing.T) {
+	cases := []struct {
+		errType string
+		status  int
+		quota   bool
+	}{
+		{ErrorRateLimited, http.StatusTooManyRequests, false},
+		{ErrorQuotaExhausted, http.StatusTooManyRequests, true},
+		{ErrorUnavailable, http.StatusServiceUnavailable, false},
+		{ErrorUnauthorized, http.StatusUnauthorized, false},
+		{ErrorInvalidRequest, http.StatusBadRequest, false},
+	}
+	for _, c := range cases {
+		cfg := echoPlugin(t, map[string]string{"ECHO_ERROR": c.errType})
+		_, err := GetModuleContext(context.Background(), "echo", cfg, Model{}, "sys", "usr")
+		var te *transport.Error
+		if !errors.As(err, &te) {
+			t.Errorf("%s: expected a transport error, got %v", c.errType, err)
+			continue
+		}
+		if te.StatusCode != c.status || te.QuotaExhausted != c.quota || te.RetryAfter <= 0 {
+			t.Errorf("%s: unexpected mapping %+v", c.errType, te)
+		}
+	}
+
+	cfg := echoPlugin(t, map[string]string{"ECHO_ERROR": "something_else"})
+	_, err := GetModuleContext(context.Background(), "echo", cfg, Model{}, "sys", "usr")
+	var te *transport.Error
+	if err == nil || errors.As(err, &te) {
+		t.Errorf("unknown error types should not be retryable, got %v", err)
+	}
+}
+
+func TestCall_CrashIncludesStderr(t *testing.T) {
+	cfg := echoPlugin(t, map[string]string{"ECHO_CRASH": "1"})
+	_, err := GetModuleContext(context.Background(), "echo", cfg, Model{}, "sys", "usr")
+	if err == nil || !strings.Contains(err.Error(), "crashing as requested") {
+		t.Fatalf("expected the plugin stderr in the error, got %v", err)
+	}
+}
+
+func TestCall_ReportsUsage(t *testing.T) {
+	root := t.TempDir()
+	ctx := usage.WithLedger(context.Background(), usage.NewLedger(root, nil))
+
+	if _, err := GetModuleContext(ctx, "gateway", echoPlugin(t, nil), Model{}, "sys", "usr"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	records, err := usage.Load(usage.Path(root))
+	if err != nil {
+		t.Fatalf("loading usage: %v", err)
+	}
+	if len(records) != 1 || records[0].Provider != "gateway" || records[0].Model != "echo-1" || records[0].InputTokens != 3 {
+		t.Fatalf("unexpected usage records: %+v", records)
+	}
+}
+
+func TestGetChatReply(t *testing.T) {
+	history := []payload.Message{
+		{Role: payload.RoleUser, Content: "rename Foo"},
+		{Role: payload.RoleAssistant, Content: `{"answer":"done"}`},
+		{Role: payload.RoleUser, Content: "no, keep the old signature"},
+	}
+	got, err := GetChatReply(context.Background(), "echo", echoPlugin(t, nil), Model{}, "sys", history)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Answer != "3 messages, last: no, keep the old signature" || got.Proposal() != nil {
+		t.Fatalf("unexpected reply: %+v", got)
+	}
+}
@@ -0,0 +1,88 @@
+// Command echo is the reference vyb exec plugin used by the tests. It
+// answers every method with a canned result built from the request, so
+// the tests can check what vyb sent.
+//
+// Setting ECHO_ERROR makes it report a protocol error of that type and
+// ECHO_CRASH makes it exit with a failure status.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type request struct {
+	Version int    `json:"version"`
+	Method  string `json:"method"`
+	Model   struct {
+		Family string `json:"family"`
+		Size   string `json:"size"`
+		ID     string `json:"id"`
+	} `json:"model"`
+	System   string `json:"system"`
+	User     string `json:"user"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+	Schema struct {
+		Name string `json:"name"`
+	} `json:"schema"`
+}
+
+func main() {
+	if os.Getenv("ECHO_CRASH") != "" {
+		fmt.Fprintln(os.Stderr, "echo: crashing as requested")
+		os.Exit(3)
+	}
+
+	var req request
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		fmt.Fprintf(os.Stderr, "echo: bad request: %v\n", err)
+		os.Exit(1)
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	if t := os.Getenv("ECHO_ERROR"); t != "" {
+		_ = out.Encode(map[string]any{
+			"error": map[string]any{"type": t, "message": "echo failed as requested", "retryAfter": 0.01},
+		})
+		return
+	}
+
+	var result any
+	switch req.Method {
+	case "workspace_change_proposals":
+		result = map[string]any{
+			"description": fmt.Sprintf("%s/%s/%s", req.Model.Family, req.Model.Size, req.Model.ID),
+			"summary":     req.Schema.Name,
+			"proposals": []any{
+				map[string]any{"file_name": "echo.txt", "content": req.User, "delete": false},
+			},
+		}
+	case "module_context":
+		result = map[string]any{"internal_context": req.System, "public_context": req.User}
+	case "module_external_contexts":
+		result = map[string]any{
+			"modules": []any{map[string]any{"name": "echo", "external_context": req.User}},
+		}
+	case "chat":
+		last := req.Messages[len(req.Messages)-1]
+		result = map[string]any{
+			"answer":      fmt.Sprintf("%d messages, last: %s", len(req.Messages), last.Content),
+			"summary":     "",
+			"description": "",
+			"proposals":   []any{},
+		}
+	default:
+		_ = out.Encode(map[string]any{
+			"error": map[string]any{"type": "invalid_request", "message": "unknown method " + req.Method},
+		})
+		return
+	}
+	_ = out.Encode(map[string]any{
+		"result": result,
+		"usage":  map[string]any{"model": "echo-1", "inputTokens": len(req.User), "outputTokens": 1},
+	})
+}
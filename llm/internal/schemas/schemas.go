@@ -0,0 +1,171 @@
+// Package schemas holds the canonical JSON schemas of the structured output
+// requested from providers. It is the only copy: every provider package
+// derives the form its API expects from it, and validate checks responses
+// against it.
+//
+// The canonical schemas only list the fields a response cannot do without
+// as required, and carry constraints (e.g. minLength) that not every
+// provider API accepts. Provider packages drop the keywords they do not
+// support; Strict renders the form expected by OpenAI's strict mode.
+package schemas
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+//go:embed *.json
+var embedded embed.FS
+
+// Files of the canonical schemas.
+const (
+	WorkspaceChangeProposal = "workspace_change_proposal_schema.json"
+	ModuleContext           = "module_selfcontained_context_schema.json"
+	ModuleExternalContext   = "module_external_context_schema.json"
+	ChatReply               = "chat_reply_schema.json"
+)
+
+// All lists every canonical schema.
+var All = []string{WorkspaceChangeProposal, ModuleContext, ModuleExternalContext, ChatReply}
+
+// Schema is a canonical schema, along with the name and description under
+// which it is sent.
+type Schema struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Schema      json.RawMessage `json:"schema"`
+}
+
+// Get returns the canonical schema stored in file.
+func Get(file string) Schema {
+	data, _ := embedded.ReadFile(file)
+	var s Schema
+	_ = json.Unmarshal(data, &s) // the embedded asset is trusted
+	return s
+}
+
+// Strict returns the schema with every property of every object required,
+// as OpenAI's strict mode demands. Optional fields are then sent empty.
+func (s Schema) Strict() json.RawMessage {
+	var doc any
+	_ = json.Unmarshal(s.Schema, &doc)
+	requireAll(doc)
+	out, _ := json.Marshal(doc)
+	return out
+}
+
+func requireAll(v any) {
+	switch val := v.(type) {
+	case map[string]any:
+		if props, ok := val["properties"].(map[string]any); ok {
+			required := map[string]bool{}
+			var names []string
+			if list, ok := val["required"].([]any); ok {
+				for _, name := range list {
+					required[name.(string)] = true
+					names = append(names, name.(string))
+				}
+			}
+			var rest []string
+			for name := range props {
+				if !required[name] {
+					rest = append(rest, name)
+				}
+			}
+			sort.Strings(rest)
+			all := make([]any, 0, len(props))
+			for _, name := range append(names, rest...) {
+				all = append(all, name)
+			}
+			val["required"] = all
+		}
+		for _, child := range val {
+			requireAll(child)
+		}
+	case []any:
+		for _, child := range val {
+			requireAll(child)
+		}
+	}
+}
+
+// Diff compares a schema derived by a provider package with the canonical
+// one, ignoring the keywords in ignored, and describes the first difference
+// found. It returns "" when they match. A false additionalProperties is
+// ignored on nodes that are not objects, as it has no meaning there.
+func Diff(canonical, derived json.RawMessage, ignored ...string) string {
+	var want, got any
+	if err := json.Unmarshal(canonical, &want); err != nil {
+		return err.Error()
+	}
+	if err := json.Unmarshal(derived, &got); err != nil {
+		return err.Error()
+	}
+	drop := map[string]bool{}
+	for _, k := range ignored {
+		drop[k] = true
+	}
+	return diff("", strip(want, drop), strip(got, drop))
+}
+
+func strip(v any, drop map[string]bool) any {
+	switch val := v.(type) {
+	case map[string]any:
+		out := map[string]any{}
+		for k, child := range val {
+			if drop[k] || (k == "additionalProperties" && child == false && val["type"] != "object") {
+				continue
+			}
+			// the keys of properties are field names, not keywords.
+			if props, ok := child.(map[string]any); ok && k == "properties" {
+				fields := map[string]any{}
+				for name, prop := range props {
+					fields[name] = strip(prop, drop)
+				}
+				out[k] = fields
+				continue
+			}
+			out[k] = strip(child, drop)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, child := range val {
+			out[i] = strip(child, drop)
+		}
+		return out
+	}
+	return v
+}
+
+func diff(path string, want, got any) string {
+	wm, wok := want.(map[string]any)
+	gm, gok := got.(map[string]any)
+	if !wok || !gok {
+		if !reflect.DeepEqual(want, got) {
+			return fmt.Sprintf("%s: got %v, want %v", path, got, want)
+		}
+		return ""
+	}
+	keys := map[string]bool{}
+	for k := range wm {
+		keys[k] = true
+	}
+	for k := range gm {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		if d := diff(path+"/"+k, wm[k], gm[k]); d != "" {
+			return d
+		}
+	}
+	return ""
+}
@@ -0,0 +1,47 @@
+package schemas
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	for _, file := range All {
+		s := Get(file)
+		if s.Name == "" || s.Description == "" || !json.Valid(s.Schema) {
+			t.Errorf("%s: incomplete schema %+v", file, s)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	var doc struct {
+		Required   []string `json:"required"`
+		Properties map[string]struct {
+			Items struct {
+				Required []string `json:"required"`
+			} `json:"items"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(Get(ChatReply).Strict(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"answer", "proposals", "summary", "description", "tool_calls"}; !reflect.DeepEqual(doc.Required, want) {
+		t.Errorf("required = %v, want %v", doc.Required, want)
+	}
+	if want := []string{"file_name", "content", "delete", "diff", "edits"}; !reflect.DeepEqual(doc.Properties["proposals"].Items.Required, want) {
+		t.Errorf("proposals[].required = %v, want %v", doc.Properties["proposals"].Items.Required, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	canonical := json.RawMessage(`{"type":"object","properties":{"name":{"type":"string","enum":["a"],"minLength":1}},"additionalProperties":false}`)
+	if d := Diff(canonical, json.RawMessage(`{"type":"object","properties":{"name":{"type":"string","enum":["a"],"additionalProperties":false}},"additionalProperties":false}`), "minLength"); d != "" {
+		t.Errorf("unexpected difference: %s", d)
+	}
+	if d := Diff(canonical, json.RawMessage(`{"type":"object","properties":{"name":{"type":"string"}},"additionalProperties":false}`), "minLength"); !strings.Contains(d, "/properties/name/enum") {
+		t.Errorf("expected the dropped enum to be reported, got %q", d)
+	}
+}
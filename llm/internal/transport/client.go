@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/vybdev/vyb/config"
+)
+
+// NewClient returns the HTTP client configured by cfg. A nil cfg yields a
+// client equivalent to http.DefaultClient, with its own transport.
+func NewClient(cfg *config.HTTPConfig) (*http.Client, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg == nil {
+		return &http.Client{Transport: base}, nil
+	}
+
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("http.proxy: invalid URL %q", cfg.Proxy)
+		}
+		base.Proxy = http.ProxyURL(u)
+	}
+
+	if cfg.CAFile != "" || cfg.CertFile != "" || cfg.KeyFile != "" {
+		tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.CAFile != "" {
+			pem, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("http.caFile: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("http.caFile: no certificate found in %s", cfg.CAFile)
+			}
+			tlsCfg.RootCAs = pool
+		}
+		if cfg.CertFile != "" || cfg.KeyFile != "" {
+			if cfg.CertFile == "" || cfg.KeyFile == "" {
+				return nil, errors.New("http.certFile and http.keyFile must be set together")
+			}
+			cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("http client certificate: %w", err)
+			}
+			tlsCfg.Certificates = []tls.Certificate{cert}
+		}
+		base.TLSClientConfig = tlsCfg
+	}
+
+	var rt http.RoundTripper = base
+	if len(cfg.Headers) > 0 || cfg.UserAgent != "" {
+		rt = &headerTransport{base: base, headers: cfg.Headers, userAgent: cfg.UserAgent}
+	}
+	return &http.Client{Transport: rt}, nil
+}
+
+// headerTransport adds static headers to every request.
+type headerTransport struct {
+	base      http.RoundTripper
+	headers   map[string]string
+	userAgent string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
+
+type clientKey struct{}
+
+// WithClient returns a copy of ctx whose provider requests are sent with
+// c. A nil c leaves ctx untouched.
+func WithClient(ctx context.Context, c *http.Client) context.Context {
+	if c == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// Client returns the HTTP client attached to ctx, or http.DefaultClient.
+// Providers must send every request through it.
+func Client(ctx context.Context) *http.Client {
+	if c, ok := ctx.Value(clientKey{}).(*http.Client); ok {
+		return c
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,138 @@
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vybdev/vyb/config"
+)
+
+// writePEM writes a PEM block of the given type to a new file in dir.
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewClient_CustomCAAndClientCertificate(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) != 1 || r.TLS.PeerCertificates[0].Subject.CommonName != "vyb-client" {
+			t.Errorf("expected the client certificate, got %v", r.TLS.PeerCertificates)
+		}
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caFile := writePEM(t, dir, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+
+	// Without the CA, the test server is not trusted.
+	plain, _ := NewClient(nil)
+	if _, err := plain.Get(srv.URL); err == nil {
+		t.Fatalf("expected an unknown authority error")
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vyb-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	c, err := NewClient(&config.HTTPConfig{
+		CAFile:   caFile,
+		CertFile: writePEM(t, dir, "client.pem", "CERTIFICATE", der),
+		KeyFile:  writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER),
+	})
+	if err != nil {
+		t.Fatalf("NewClient() = %v", err)
+	}
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewClient_ProxyAndHeaders(t *testing.T) {
+	var got *http.Request
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer proxy.Close()
+
+	c, err := NewClient(&config.HTTPConfig{
+		Proxy:     proxy.URL,
+		UserAgent: "vyb-test",
+		Headers:   map[string]string{"X-Team": "platform", "Authorization": "static"},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() = %v", err)
+	}
+	req, _ := http.NewRequest("GET", "http://llm.internal.example/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer provider")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if got == nil || got.Host != "llm.internal.example" {
+		t.Fatalf("expected the request to go through the proxy, got %v", got)
+	}
+	if got.Header.Get("User-Agent") != "vyb-test" || got.Header.Get("X-Team") != "platform" {
+		t.Errorf("missing static headers: %v", got.Header)
+	}
+	if got.Header.Get("Authorization") != "Bearer provider" {
+		t.Errorf("static headers must not replace the provider's, got %q", got.Header.Get("Authorization"))
+	}
+	if req.Header.Get("X-Team") != "" {
+		t.Errorf("the caller's request was modified")
+	}
+}
+
+func TestNewClient_Errors(t *testing.T) {
+	for _, cfg := range []*config.HTTPConfig{
+		{Proxy: "::not a url"},
+		{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		{CertFile: "client.pem"},
+	} {
+		if _, err := NewClient(cfg); err == nil {
+			t.Errorf("NewClient(%+v) succeeded, want an error", cfg)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	if Client(context.Background()) != http.DefaultClient {
+		t.Fatalf("expected http.DefaultClient without a client in the context")
+	}
+	c := &http.Client{}
+	if Client(WithClient(context.Background(), c)) != c {
+		t.Fatalf("expected the attached client")
+	}
+}
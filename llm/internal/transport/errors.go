@@ -0,0 +1,75 @@
+// Package transport holds the HTTP plumbing shared by every provider
+// implementation.
+package transport
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error decorates a provider error with the HTTP metadata needed to decide
+// whether (and when) the call may be retried. The provider-specific error
+// stays reachable through errors.As / errors.Unwrap.
+type Error struct {
+	// StatusCode is the HTTP status returned by the provider.
+	StatusCode int
+	// RetryAfter is the delay requested by the provider before the next
+	// attempt, or zero when none was given.
+	RetryAfter time.Duration
+	// QuotaExhausted is set when the provider reported that the account ran
+	// out of quota or credit – retrying the same provider will not help.
+	QuotaExhausted bool
+	// Interrupted is set when a streamed response broke off, or reported
+	// an error, after the provider accepted the request. Like a 5xx, the
+	// call is worth retrying.
+	Interrupted bool
+	Err         error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// NewError wraps err with the status code and Retry-After information of
+// resp.
+func NewError(resp *http.Response, err error) *Error {
+	return &Error{
+		StatusCode: resp.StatusCode,
+		RetryAfter: ParseRetryAfter(resp.Header, time.Now()),
+		Err:        err,
+	}
+}
+
+// NewStreamError wraps err, which interrupted the stream of resp.
+func NewStreamError(resp *http.Response, err error) *Error {
+	e := NewError(resp, err)
+	e.Interrupted = true
+	return e
+}
+
+// ParseRetryAfter reads the delay requested by the server. Both the
+// standard Retry-After header (delta-seconds or HTTP date) and the
+// millisecond variant used by OpenAI are supported. A zero duration is
+// returned when no usable header is present.
+func ParseRetryAfter(h http.Header, now time.Time) time.Duration {
+	if ms := h.Get("Retry-After-Ms"); ms != "" {
+		if v, err := strconv.ParseFloat(ms, 64); err == nil && v > 0 {
+			return time.Duration(v * float64(time.Millisecond))
+		}
+	}
+	ra := h.Get("Retry-After")
+	if ra == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(ra, 64); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs * float64(time.Second))
+	}
+	if at, err := http.ParseTime(ra); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// IsEventStream reports whether resp is a server-sent event stream, as
+// returned by the streaming variants of the provider APIs. Servers that
+// ignore the streaming flag answer with a regular JSON document instead.
+func IsEventStream(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// ReadEvents calls fn with the data of every server-sent event read from r,
+// until r is exhausted, fn fails or the OpenAI "[DONE]" sentinel is read.
+// Multi-line data fields are joined with newlines; other fields (event,
+// id, retry) and comments are ignored.
+func ReadEvents(r io.Reader, fn func(data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	// a single event carries a whole chunk of the response.
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	var data []byte
+	dispatch := func() error {
+		if data == nil {
+			return nil
+		}
+		d := data
+		data = nil
+		return fn(d)
+	}
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			if err := dispatch(); err != nil {
+				return err
+			}
+			continue
+		}
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		if string(field) != "data" {
+			continue
+		}
+		value = bytes.TrimPrefix(value, []byte(" "))
+		if string(value) == "[DONE]" {
+			return nil
+		}
+		if data != nil {
+			data = append(data, '\n')
+		}
+		data = append(data, value...)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return dispatch()
+}
@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadEvents(t *testing.T) {
+	stream := ": keep-alive\n\n" +
+		"data: {\"a\":1}\n\n" +
+		"event: message\nid: 2\ndata: {\"b\":\ndata: 2}\n\n" +
+		"data:{\"c\":3}\n\n" +
+		"data: [DONE]\n\n" +
+		"data: {\"ignored\":true}\n\n"
+	var got []string
+	err := ReadEvents(strings.NewReader(stream), func(data []byte) error {
+		got = append(got, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{`{"a":1}`, "{\"b\":\n2}", `{"c":3}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+
+	// a stream cut short still dispatches its last event, and errors of fn
+	// end the stream.
+	got = nil
+	boom := errors.New("boom")
+	err = ReadEvents(strings.NewReader("data: 1\n\ndata: 2\n\ndata: 3"), func(data []byte) error {
+		got = append(got, string(data))
+		if len(got) == 2 {
+			return boom
+		}
+		return nil
+	})
+	if !errors.Is(err, boom) || !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestIsEventStream(t *testing.T) {
+	for ct, want := range map[string]bool{
+		"text/event-stream":                true,
+		"text/event-stream; charset=utf-8": true,
+		"application/json":                 false,
+		"":                                 false,
+	} {
+		resp := &http.Response{Header: http.Header{"Content-Type": []string{ct}}}
+		if got := IsEventStream(resp); got != want {
+			t.Errorf("IsEventStream(%q) = %v, want %v", ct, got, want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package transport
+
+import "encoding/json"
+
+// MergeParams deep-merges params into the JSON object body and returns the
+// result. Nested objects are merged key by key; any other value in params
+// replaces the one in body. body is returned untouched when params is
+// empty.
+func MergeParams(body []byte, params map[string]any) ([]byte, error) {
+	if len(params) == 0 {
+		return body, nil
+	}
+	var m map[string]any
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	mergeInto(m, params)
+	return json.Marshal(m)
+}
+
+func mergeInto(dst, src map[string]any) {
+	for k, v := range src {
+		srcMap, srcIsMap := asMap(v)
+		dstMap, dstIsMap := asMap(dst[k])
+		if srcIsMap && dstIsMap {
+			mergeInto(dstMap, srcMap)
+			dst[k] = dstMap
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// asMap accepts both JSON-decoded objects and the map[string]any values
+// produced by the YAML decoder.
+func asMap(v any) (map[string]any, bool) {
+	m, ok := v.(map[string]any)
+	return m, ok
+}
@@ -0,0 +1,40 @@
+package transport
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergeParams(t *testing.T) {
+	body := []byte(`{"model":"m","generationConfig":{"responseMimeType":"application/json","temperature":1}}`)
+	params := map[string]any{
+		"generationConfig": map[string]any{"temperature": 0.2, "thinkingConfig": map[string]any{"thinkingBudget": 128}},
+		"reasoning_effort": "high",
+	}
+
+	out, err := MergeParams(body, params)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	want := map[string]any{
+		"model": "m",
+		"generationConfig": map[string]any{
+			"responseMimeType": "application/json",
+			"temperature":      0.2,
+			"thinkingConfig":   map[string]any{"thinkingBudget": float64(128)},
+		},
+		"reasoning_effort": "high",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("MergeParams = %v, want %v", got, want)
+	}
+
+	if out, _ := MergeParams(body, nil); string(out) != string(body) {
+		t.Fatalf("expected body to be untouched without params")
+	}
+}
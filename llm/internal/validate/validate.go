@@ -0,0 +1,222 @@
+// Package validate checks the structured output returned by a provider
+// against the JSON schema it was requested with, before it is unmarshalled
+// into the payload types.
+//
+// Providers enforce the schemas with varying rigour: a response can be
+// valid JSON and still lack required fields or carry empty values that
+// would be applied to the workspace as is. Responses are checked against
+// the canonical schemas, from which the ones sent to every provider are
+// derived, with the extra constraints (e.g. minLength) that not every
+// provider API accepts.
+//
+// Only the subset of JSON schema used by vyb is supported: type,
+// properties, required, additionalProperties, items, enum and minLength.
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vybdev/vyb/llm/internal/schemas"
+)
+
+// Names of the schemas structured output can be validated against.
+const (
+	WorkspaceChangeProposal = "workspace_change_proposal"
+	ModuleContext           = "module_context"
+	ModuleExternalContext   = "module_external_context"
+	ChatReply               = "chat_reply"
+)
+
+var files = map[string]string{
+	WorkspaceChangeProposal: schemas.WorkspaceChangeProposal,
+	ModuleContext:           schemas.ModuleContext,
+	ModuleExternalContext:   schemas.ModuleExternalContext,
+	ChatReply:               schemas.ChatReply,
+}
+
+// Violation is a single way in which a response does not match its schema.
+type Violation struct {
+	// Field is the path of the offending value, e.g.
+	// "proposals[0].file_name". It is empty for the document itself.
+	Field   string
+	Problem string
+}
+
+func (v Violation) String() string {
+	if v.Field == "" {
+		return v.Problem
+	}
+	return v.Field + ": " + v.Problem
+}
+
+// Error reports a response that does not match its schema.
+type Error struct {
+	Provider   string
+	Schema     string
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	problems := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		problems[i] = v.String()
+	}
+	return fmt.Sprintf("%s: response does not match the %s schema: %s", e.Provider, e.Schema, strings.Join(problems, "; "))
+}
+
+// Decode validates raw against the named schema and unmarshals it into
+// out. Any failure, including malformed JSON, is returned as an *Error
+// attributed to provider.
+func Decode(provider, schemaName string, raw []byte, out any) error {
+	violations := Validate(schemaName, raw)
+	if len(violations) == 0 {
+		if err := json.Unmarshal(raw, out); err != nil {
+			violations = []Violation{{Problem: err.Error()}}
+		}
+	}
+	if len(violations) > 0 {
+		return &Error{Provider: provider, Schema: schemaName, Violations: violations}
+	}
+	return nil
+}
+
+// Validate returns every violation of the named schema found in raw.
+func Validate(schemaName string, raw []byte) []Violation {
+	s, err := load(schemaName)
+	if err != nil {
+		return []Violation{{Problem: err.Error()}}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return []Violation{{Problem: "invalid JSON: " + err.Error()}}
+	}
+	if dec.More() {
+		return []Violation{{Problem: "invalid JSON: unexpected data after the top-level value"}}
+	}
+
+	var out []Violation
+	s.check("", doc, &out)
+	return out
+}
+
+type node struct {
+	Type                 string           `json:"type"`
+	Properties           map[string]*node `json:"properties"`
+	Required             []string         `json:"required"`
+	AdditionalProperties *bool            `json:"additionalProperties"`
+	Items                *node            `json:"items"`
+	MinLength            *int             `json:"minLength"`
+	Enum                 []any            `json:"enum"`
+}
+
+func load(schemaName string) (*node, error) {
+	file, ok := files[schemaName]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %q", schemaName)
+	}
+	var n node
+	// the embedded asset is trusted
+	_ = json.Unmarshal(schemas.Get(file).Schema, &n)
+	return &n, nil
+}
+
+func (n *node) check(path string, v any, out *[]Violation) {
+	if got := typeOf(v); n.Type != "" && got != n.Type && !(n.Type == "number" && got == "integer") {
+		*out = append(*out, Violation{Field: path, Problem: fmt.Sprintf("expected %s, got %s", n.Type, got)})
+		return
+	}
+	if len(n.Enum) > 0 && !n.allows(v) {
+		*out = append(*out, Violation{Field: path, Problem: "must be one of " + n.enumList()})
+		return
+	}
+	switch val := v.(type) {
+	case map[string]any:
+		for _, name := range n.Required {
+			if _, ok := val[name]; !ok {
+				*out = append(*out, Violation{Field: join(path, name), Problem: "is required"})
+			}
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			prop, ok := n.Properties[k]
+			if !ok {
+				if n.AdditionalProperties != nil && !*n.AdditionalProperties {
+					*out = append(*out, Violation{Field: join(path, k), Problem: "is not allowed"})
+				}
+				continue
+			}
+			prop.check(join(path, k), val[k], out)
+		}
+	case []any:
+		if n.Items != nil {
+			for i, item := range val {
+				n.Items.check(fmt.Sprintf("%s[%d]", path, i), item, out)
+			}
+		}
+	case string:
+		if n.MinLength != nil && len([]rune(val)) < *n.MinLength {
+			problem := fmt.Sprintf("must be at least %d characters long", *n.MinLength)
+			if *n.MinLength == 1 {
+				problem = "must not be empty"
+			}
+			*out = append(*out, Violation{Field: path, Problem: problem})
+		}
+	}
+}
+
+// allows tells whether v is one of the values of the enum of n.
+func (n *node) allows(v any) bool {
+	for _, e := range n.Enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) && typeOf(e) == typeOf(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *node) enumList() string {
+	values := make([]string, len(n.Enum))
+	for i, e := range n.Enum {
+		values[i] = fmt.Sprint(e)
+	}
+	return strings.Join(values, ", ")
+}
+
+func typeOf(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := val.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,100 @@
+package validate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vybdev/vyb/llm/payload"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		raw    string
+		want   []Violation
+	}{
+		{
+			name:   "valid proposal",
+			schema: WorkspaceChangeProposal,
+			raw:    `{"summary":"s","description":"d","proposals":[{"file_name":"a.go","content":"package a","delete":false}]}`,
+		},
+		{
+			name:   "empty file name and missing field",
+			schema: WorkspaceChangeProposal,
+			raw:    `{"summary":"s","description":"d","proposals":[{"file_name":"a.go","content":"","delete":true},{"file_name":"","content":"x"}]}`,
+			want: []Violation{
+				{Field: "proposals[1].delete", Problem: "is required"},
+				{Field: "proposals[1].file_name", Problem: "must not be empty"},
+			},
+		},
+		{
+			name:   "wrong types and unknown field",
+			schema: WorkspaceChangeProposal,
+			raw:    `{"summary":1,"description":"d","proposals":{},"extra":true}`,
+			want: []Violation{
+				{Field: "extra", Problem: "is not allowed"},
+				{Field: "proposals", Problem: "expected array, got object"},
+				{Field: "summary", Problem: "expected string, got integer"},
+			},
+		},
+		{
+			name:   "module context",
+			schema: ModuleContext,
+			raw:    `{"internal_context":"i"}`,
+			want:   []Violation{{Field: "public_context", Problem: "is required"}},
+		},
+		{
+			name:   "external contexts",
+			schema: ModuleExternalContext,
+			raw:    `{"modules":[{"name":"","external_context":"e"}]}`,
+			want:   []Violation{{Field: "modules[0].name", Problem: "must not be empty"}},
+		},
+		{
+			name:   "chat reply",
+			schema: ChatReply,
+			raw:    `{"answer":"a","summary":"","description":"","proposals":[{"file_name":"","content":"","delete":true}]}`,
+			want:   []Violation{{Field: "proposals[0].file_name", Problem: "must not be empty"}},
+		},
+		{
+			name:   "unknown tool",
+			schema: ChatReply,
+			raw:    `{"answer":"","summary":"","description":"","proposals":[],"tool_calls":[{"name":"grep","path":".","pattern":"x"},{"name":"rm","path":".","pattern":""}]}`,
+			want:   []Violation{{Field: "tool_calls[1].name", Problem: "must be one of read_file, list_dir, grep"}},
+		},
+		{
+			name:   "malformed",
+			schema: ModuleContext,
+			raw:    `{"internal_context":`,
+			want:   []Violation{{Problem: "invalid JSON: unexpected EOF"}},
+		},
+	}
+
+	for _, c := range cases {
+		if got := Validate(c.schema, []byte(c.raw)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: Validate() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var ok payload.ModuleSelfContainedContext
+	if err := Decode("openai", ModuleContext, []byte(`{"internal_context":"i","public_context":"p"}`), &ok); err != nil || ok.PublicContext != "p" {
+		t.Fatalf("Decode() = %+v, %v", ok, err)
+	}
+
+	var out payload.WorkspaceChangeProposal
+	err := Decode("gemini", WorkspaceChangeProposal, []byte(`{"summary":"s","description":"d","proposals":[{"file_name":"","content":"","delete":false}]}`), &out)
+	var verr *Error
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if verr.Provider != "gemini" || len(verr.Violations) != 1 || verr.Violations[0].Field != "proposals[0].file_name" {
+		t.Fatalf("unexpected error: %+v", verr)
+	}
+	want := "gemini: response does not match the workspace_change_proposal schema: proposals[0].file_name: must not be empty"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,103 @@
+package llm
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+
+    "github.com/vybdev/vyb/config"
+)
+
+// Module annotations are always generated with this (family,size) pair.
+const (
+    annotationFamily = config.ModelFamilyReasoning
+    annotationSize   = config.ModelSizeSmall
+)
+
+// defaultModels maps, per provider, every built-in (family,size) pair to a
+// concrete model. Entries can be overridden, and new families added,
+// through the models section of .vyb/config.yaml.
+var defaultModels = map[string]map[config.ModelFamily]map[config.ModelSize]string{
+    "openai": {
+        config.ModelFamilyGPT: {
+            config.ModelSizeLarge: "GPT-4.1",
+            config.ModelSizeSmall: "GPT-4.1-mini",
+        },
+        config.ModelFamilyReasoning: {
+            config.ModelSizeLarge: "o3",
+            config.ModelSizeSmall: "o4-mini",
+        },
+    },
+    "gemini": {
+        config.ModelFamilyGPT: {
+            config.ModelSizeLarge: "gemini-2.5-pro-preview-06-05",
+            config.ModelSizeSmall: "gemini-2.5-flash-preview-05-20",
+        },
+        config.ModelFamilyReasoning: {
+            config.ModelSizeLarge: "gemini-2.5-pro-preview-06-05",
+            config.ModelSizeSmall: "gemini-2.5-flash-preview-05-20",
+        },
+    },
+    "anthropic": {
+        config.ModelFamilyGPT: {
+            config.ModelSizeLarge: "claude-sonnet-4-5",
+            config.ModelSizeSmall: "claude-haiku-4-5",
+        },
+        config.ModelFamilyReasoning: {
+            config.ModelSizeLarge: "claude-opus-4-1",
+            config.ModelSizeSmall: "claude-sonnet-4-5",
+        },
+    },
+}
+
+// resolvedModel is a concrete model and the extra request parameters
+// configured for it.
+type resolvedModel struct {
+    ID     string
+    Params map[string]any
+}
+
+// resolveModel translates the (family,size) pair into the model used by
+// providerName, looking at the user configuration before the built-in
+// table.
+func resolveModel(cfg *config.Config, providerName string, fam config.ModelFamily, sz config.ModelSize) (resolvedModel, error) {
+    providerName = strings.ToLower(providerName)
+    id := cfg.ModelOverride(providerName, fam, sz)
+    if id == "" {
+        id = defaultModels[providerName][fam][sz]
+    }
+    if id == "" {
+        return resolvedModel{}, fmt.Errorf("%s: no model configured for family=%s size=%s (known families: %s)", providerName, fam, sz, strings.Join(knownFamilies(cfg, providerName), ", "))
+    }
+    return resolvedModel{ID: id, Params: cfg.ModelParams(providerName, id)}, nil
+}
+
+// knownFamilies lists the families providerName can resolve, for error
+// messages.
+func knownFamilies(cfg *config.Config, providerName string) []string {
+    seen := map[string]bool{}
+    for fam := range defaultModels[providerName] {
+        seen[string(fam)] = true
+    }
+    if pm := cfg.ModelsFor(providerName); pm != nil {
+        for fam := range pm.Map {
+            seen[string(fam)] = true
+        }
+    }
+    if providerName == "openai" && cfg.OpenAI != nil {
+        for fam := range cfg.OpenAI.Models {
+            seen[string(fam)] = true
+        }
+        if cfg.OpenAI.Azure != nil {
+            for fam := range cfg.OpenAI.Azure.Deployments {
+                seen[string(fam)] = true
+            }
+        }
+    }
+    var out []string
+    for fam := range seen {
+        out = append(out, fam)
+    }
+    sort.Strings(out)
+    return out
+}
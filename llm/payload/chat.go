@@ -0,0 +1,59 @@
+package payload
+
+import "encoding/json"
+
+// Role identifies the author of a chat message.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// Message is a single turn of a conversation. The system message is kept
+// apart from the history, as every provider transmits it differently.
+type Message struct {
+	Role    Role   `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatReply is an assistant turn of `vyb chat`. The model either answers in
+// prose (Answer) or proposes workspace changes, in which case Proposals,
+// Summary and Description are set like in a WorkspaceChangeProposal.
+//
+// When tools are enabled, the model may instead ask for ToolCalls to be
+// run, and reply once their results are sent back.
+type ChatReply struct {
+	Answer      string               `json:"answer"`
+	Summary     string               `json:"summary"`
+	Description string               `json:"description"`
+	Proposals   []FileChangeProposal `json:"proposals"`
+	ToolCalls   []ToolCall           `json:"tool_calls,omitempty"`
+}
+
+// ToolCall is a request, made by the model, to run one of the read-only
+// workspace tools.
+type ToolCall struct {
+	// Name is read_file, list_dir or grep.
+	Name string `json:"name"`
+	// Path is relative to the project root.
+	Path string `json:"path"`
+	// Pattern is the regular expression searched by grep.
+	Pattern string `json:"pattern"`
+}
+
+// Proposal returns the workspace changes carried by the reply, or nil when
+// the reply is prose only.
+func (r *ChatReply) Proposal() *WorkspaceChangeProposal {
+	if len(r.Proposals) == 0 {
+		return nil
+	}
+	return &WorkspaceChangeProposal{Summary: r.Summary, Description: r.Description, Proposals: r.Proposals}
+}
+
+// AsMessage renders the reply as the assistant turn to keep in the
+// history, in the same JSON form the model produced it.
+func (r *ChatReply) AsMessage() Message {
+	b, _ := json.Marshal(r)
+	return Message{Role: RoleAssistant, Content: string(b)}
+}
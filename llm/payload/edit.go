@@ -0,0 +1,198 @@
+package payload
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IsPatch reports whether p changes the current content of its file, with
+// Edits or a Diff, rather than replacing or deleting it.
+func (p FileChangeProposal) IsPatch() bool {
+	return len(p.Edits) > 0 || p.Diff != ""
+}
+
+// Apply returns the content of the file once p is applied to current, its
+// content so far. Whole-file proposals return Content.
+//
+// Patches are applied strictly: the search text of every edit must appear
+// exactly once in the file, and the context and removed lines of every
+// diff hunk must match it exactly. Anything else is an error naming the
+// file and the edit or hunk that does not apply.
+func (p FileChangeProposal) Apply(current string) (string, error) {
+	if !p.IsPatch() {
+		return p.Content, nil
+	}
+	switch {
+	case p.Delete:
+		return "", fmt.Errorf("%s: a deleted file cannot be edited", p.FileName)
+	case p.Content != "":
+		return "", fmt.Errorf("%s: the proposal sets both the content and edits of the file", p.FileName)
+	case len(p.Edits) > 0 && p.Diff != "":
+		return "", fmt.Errorf("%s: the proposal sets both edits and a diff", p.FileName)
+	}
+	var err error
+	if p.Diff != "" {
+		current, err = applyDiff(current, p.Diff)
+	} else {
+		current, err = applyEdits(current, p.Edits)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", p.FileName, err)
+	}
+	return current, nil
+}
+
+// applyEdits applies edits in order, each to the result of the previous.
+func applyEdits(content string, edits []Edit) (string, error) {
+	for i, e := range edits {
+		if e.Search == "" {
+			return "", fmt.Errorf("edit %d: the search text is empty", i+1)
+		}
+		switch n := strings.Count(content, e.Search); n {
+		case 0:
+			return "", fmt.Errorf("edit %d: the search text was not found:\n%s", i+1, e.Search)
+		case 1:
+			content = strings.Replace(content, e.Search, e.Replace, 1)
+		default:
+			return "", fmt.Errorf("edit %d: the search text was found %d times, it must be unique:\n%s", i+1, n, e.Search)
+		}
+	}
+	return content, nil
+}
+
+// hunk is a hunk of a unified diff: the lines it expects in the file, and
+// the lines replacing them.
+type hunk struct {
+	header string
+	// start is the first line of old in the file, counted from 0, or -1
+	// when the header does not tell.
+	start    int
+	old, new []string
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+\d+(?:,\d+)? @@`)
+
+// parseDiff parses the hunks of a unified diff of a single file. File
+// headers (---, +++) and anything before the first hunk are ignored.
+func parseDiff(diff string) ([]hunk, error) {
+	var hunks []hunk
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	for n, line := range lines {
+		if strings.HasPrefix(line, "@@") {
+			h := hunk{header: line, start: -1}
+			if m := hunkHeader.FindStringSubmatch(line); m != nil {
+				start, _ := strconv.Atoi(m[1])
+				// an empty old range starts after the given line.
+				h.start = max(start-1, 0)
+				if strings.HasPrefix(line, "@@ -"+m[1]+",0 ") {
+					h.start = start
+				}
+			}
+			hunks = append(hunks, h)
+			continue
+		}
+		if len(hunks) == 0 {
+			continue
+		}
+		h := &hunks[len(hunks)-1]
+		switch {
+		case line == "":
+			// blank context lines often lose their leading space.
+			h.old = append(h.old, "")
+			h.new = append(h.new, "")
+		case line[0] == ' ':
+			h.old = append(h.old, line[1:])
+			h.new = append(h.new, line[1:])
+		case line[0] == '-':
+			h.old = append(h.old, line[1:])
+		case line[0] == '+':
+			h.new = append(h.new, line[1:])
+		case line[0] == '\\':
+			// "\ No newline at end of file": the file keeps its final newline, or lack thereof.
+		default:
+			return nil, fmt.Errorf("line %d of the diff is not part of a hunk: %q", n+1, line)
+		}
+	}
+	if len(hunks) == 0 {
+		return nil, fmt.Errorf("the diff has no hunks")
+	}
+	return hunks, nil
+}
+
+// applyDiff applies the hunks of diff in order. Each hunk must match
+// exactly one place in the file after the previous hunk; when it matches
+// several, the one at the line given by its header is used.
+func applyDiff(content, diff string) (string, error) {
+	hunks, err := parseDiff(diff)
+	if err != nil {
+		return "", err
+	}
+	trailingNewline := strings.HasSuffix(content, "\n")
+	var lines []string
+	if content != "" {
+		lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+
+	// from is the first line the next hunk may match; shift is how many
+	// lines the previous hunks added.
+	from, shift := 0, 0
+	for i, h := range hunks {
+		hint := -1
+		if h.start >= 0 {
+			hint = h.start + shift
+		}
+		at, err := locate(lines, h.old, from, hint)
+		if err != nil {
+			return "", fmt.Errorf("hunk %d (%s): %w", i+1, h.header, err)
+		}
+		lines = append(lines[:at], append(append([]string{}, h.new...), lines[at+len(h.old):]...)...)
+		from = at + len(h.new)
+		shift += len(h.new) - len(h.old)
+	}
+
+	out := strings.Join(lines, "\n")
+	if len(lines) > 0 && (trailingNewline || content == "") {
+		out += "\n"
+	}
+	return out, nil
+}
+
+// locate returns where old appears in lines, at or after from.
+func locate(lines, old []string, from, hint int) (int, error) {
+	if len(old) == 0 {
+		// a pure insertion needs its position from the header.
+		if hint < from || hint > len(lines) {
+			return 0, fmt.Errorf("the hunk has no context lines and no valid position")
+		}
+		return hint, nil
+	}
+	var matches []int
+	for at := from; at+len(old) <= len(lines); at++ {
+		if equal(lines[at:at+len(old)], old) {
+			matches = append(matches, at)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("the context and removed lines do not match the file:\n%s", strings.Join(old, "\n"))
+	case 1:
+		return matches[0], nil
+	}
+	for _, at := range matches {
+		if at == hint {
+			return at, nil
+		}
+	}
+	return 0, fmt.Errorf("the context and removed lines match %d places in the file, add context to make them unique", len(matches))
+}
+
+func equal(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package payload
+
+import (
+	"strings"
+	"testing"
+)
+
+const goFile = `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello")
+}
+
+func helper() {
+	fmt.Println("hello")
+}
+`
+
+func TestFileChangeProposal_Apply(t *testing.T) {
+	tests := []struct {
+		name string
+		prop FileChangeProposal
+		want string
+	}{
+		{
+			name: "whole file",
+			prop: FileChangeProposal{FileName: "main.go", Content: "package main\n"},
+			want: "package main\n",
+		},
+		{
+			name: "edits",
+			prop: FileChangeProposal{FileName: "main.go", Edits: []Edit{
+				{Search: "func main() {\n\tfmt.Println(\"hello\")", Replace: "func main() {\n\tfmt.Println(\"hi\")"},
+				{Search: "func helper()", Replace: "func greet()"},
+			}},
+			want: strings.Replace(strings.Replace(goFile, "\"hello\"", "\"hi\"", 1), "helper", "greet", 1),
+		},
+		{
+			name: "diff",
+			prop: FileChangeProposal{FileName: "main.go", Diff: `--- a/main.go
++++ b/main.go
+@@ -5,3 +5,4 @@
+ func main() {
+-	fmt.Println("hello")
++	fmt.Println("hi")
++	helper()
+ }
+@@ -9,3 +10,3 @@ func main() {
+ func helper() {
+-	fmt.Println("hello")
++	fmt.Println("bye")
+ }
+`},
+			want: strings.Replace(strings.Replace(goFile, "\"hello\")\n}", "\"hi\")\n\thelper()\n}", 1), "\"hello\"", "\"bye\"", 1),
+		},
+		{
+			// the same lines appear twice: the header tells which.
+			name: "ambiguous hunk",
+			prop: FileChangeProposal{FileName: "main.go", Diff: "@@ -10,1 +10,1 @@\n-\tfmt.Println(\"hello\")\n+\tfmt.Println(\"bye\")\n"},
+			want: goFile[:strings.LastIndex(goFile, "hello")] + "bye\")\n}\n",
+		},
+		{
+			name: "insertion",
+			prop: FileChangeProposal{FileName: "main.go", Diff: "@@ -11,0 +12,2 @@\n+\n+// end\n"},
+			want: goFile + "\n// end\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.prop.Apply(goFile)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFileChangeProposal_Apply_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		prop FileChangeProposal
+		want string
+	}{
+		{
+			name: "search text not found",
+			prop: FileChangeProposal{FileName: "main.go", Edits: []Edit{{Search: "func main() {}", Replace: ""}}},
+			want: "main.go: edit 1: the search text was not found",
+		},
+		{
+			name: "search text not unique",
+			prop: FileChangeProposal{FileName: "main.go", Edits: []Edit{{Search: "fmt.Println(\"hello\")", Replace: ""}}},
+			want: "main.go: edit 1: the search text was found 2 times",
+		},
+		{
+			name: "hunk does not match",
+			prop: FileChangeProposal{FileName: "main.go", Diff: "@@ -5,2 +5,2 @@\n func main() {\n-\tfmt.Println(\"bye\")\n+\tfmt.Println(\"hi\")\n"},
+			want: "main.go: hunk 1 (@@ -5,2 +5,2 @@): the context and removed lines do not match the file",
+		},
+		{
+			name: "hunk not unique",
+			prop: FileChangeProposal{FileName: "main.go", Diff: "@@ -1,1 +1,1 @@\n-\tfmt.Println(\"hello\")\n+\tfmt.Println(\"bye\")\n"},
+			want: "match 2 places in the file",
+		},
+		{
+			name: "not a diff",
+			prop: FileChangeProposal{FileName: "main.go", Diff: "replace hello with hi"},
+			want: "main.go: the diff has no hunks",
+		},
+		{
+			name: "content and edits",
+			prop: FileChangeProposal{FileName: "main.go", Content: "package main\n", Edits: []Edit{{Search: "main", Replace: "app"}}},
+			want: "sets both the content and edits",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.prop.Apply(goFile)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package payload
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// FileEncoding selects how the content of every file is delimited in a
+// payload.
+type FileEncoding string
+
+const (
+	// EncodingMarkdown renders every file as a "### path" header followed
+	// by a fenced code block (the default). The fence is longer than any
+	// run of backticks in the file, so that fences inside the file, as in
+	// most Markdown documents, never close the block.
+	EncodingMarkdown FileEncoding = "markdown"
+	// EncodingXML renders every file as a <file path="..."> element. The
+	// content is written verbatim, unless it contains the closing tag (or
+	// starts like a CDATA section): it is then wrapped in a CDATA section.
+	EncodingXML FileEncoding = "xml"
+)
+
+// Validate checks that e is a known encoding. The zero value stands for
+// EncodingMarkdown.
+func (e FileEncoding) Validate() error {
+	switch e {
+	case "", EncodingMarkdown, EncodingXML:
+		return nil
+	}
+	return fmt.Errorf("fileEncoding must be markdown or xml, got %q", e)
+}
+
+func writeFile(sb *strings.Builder, filepath, content string, enc FileEncoding) {
+	if sb == nil {
+		return
+	}
+	// Ensure a trailing newline before closing the block.
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	lang := getLanguageFromFilename(filepath)
+	if enc == EncodingXML {
+		writeXMLFile(sb, filepath, lang, content)
+		return
+	}
+	fence := fenceFor(content)
+	sb.WriteString(fmt.Sprintf("### %s\n", filepath))
+	sb.WriteString(fmt.Sprintf("%s%s\n", fence, lang))
+	sb.WriteString(content)
+	sb.WriteString(fence + "\n\n")
+}
+
+// fenceFor returns a backtick fence longer than any run of backticks in
+// content, and at least three backticks long. A fenced block is only
+// closed by a fence at least as long as the one that opened it.
+func fenceFor(content string) string {
+	longest, run := 0, 0
+	for i := 0; i < len(content); i++ {
+		if content[i] == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+const (
+	xmlFileClose = "</file>"
+	cdataOpen    = "<![CDATA["
+	cdataClose   = "]]>"
+)
+
+var xmlAttrEscaper = strings.NewReplacer(`&`, "&amp;", `"`, "&quot;", `<`, "&lt;", `>`, "&gt;")
+
+func writeXMLFile(sb *strings.Builder, filepath, lang, content string) {
+	sb.WriteString(fmt.Sprintf(`<file path="%s"`, xmlAttrEscaper.Replace(filepath)))
+	if lang != "" {
+		sb.WriteString(fmt.Sprintf(` language="%s"`, lang))
+	}
+	sb.WriteString(">\n")
+	// verbatim content must not end the element, nor look like a CDATA
+	// section.
+	if strings.Contains(content, xmlFileClose) || strings.HasPrefix(content, cdataOpen) {
+		// "]]>" would end the section: it is split across two sections.
+		sb.WriteString(cdataOpen)
+		sb.WriteString(strings.ReplaceAll(content, cdataClose, "]]"+cdataClose+cdataOpen+">"))
+		sb.WriteString(cdataClose + "\n")
+	} else {
+		sb.WriteString(content)
+	}
+	sb.WriteString(xmlFileClose + "\n\n")
+}
+
+// languages maps file extensions, lowercased, to the language identifiers
+// commonly used to tag code blocks.
+var languages = map[string]string{
+	".go":         "go",
+	".md":         "markdown",
+	".mdx":        "mdx",
+	".markdown":   "markdown",
+	".rst":        "rst",
+	".adoc":       "asciidoc",
+	".txt":        "text",
+	".json":       "json",
+	".jsonc":      "jsonc",
+	".yaml":       "yaml",
+	".yml":        "yaml",
+	".toml":       "toml",
+	".ini":        "ini",
+	".cfg":        "ini",
+	".conf":       "ini",
+	".properties": "properties",
+	".env":        "dotenv",
+	".xml":        "xml",
+	".xsd":        "xml",
+	".svg":        "xml",
+	".html":       "html",
+	".htm":        "html",
+	".css":        "css",
+	".scss":       "scss",
+	".sass":       "sass",
+	".less":       "less",
+	".js":         "javascript",
+	".mjs":        "javascript",
+	".cjs":        "javascript",
+	".jsx":        "jsx",
+	".ts":         "typescript",
+	".mts":        "typescript",
+	".cts":        "typescript",
+	".tsx":        "tsx",
+	".vue":        "vue",
+	".svelte":     "svelte",
+	".py":         "python",
+	".pyi":        "python",
+	".rb":         "ruby",
+	".php":        "php",
+	".java":       "java",
+	".kt":         "kotlin",
+	".kts":        "kotlin",
+	".scala":      "scala",
+	".groovy":     "groovy",
+	".gradle":     "groovy",
+	".c":          "c",
+	".h":          "c",
+	".cc":         "cpp",
+	".cpp":        "cpp",
+	".cxx":        "cpp",
+	".hh":         "cpp",
+	".hpp":        "cpp",
+	".cs":         "csharp",
+	".fs":         "fsharp",
+	".m":          "objectivec",
+	".mm":         "objectivec",
+	".swift":      "swift",
+	".rs":         "rust",
+	".zig":        "zig",
+	".dart":       "dart",
+	".lua":        "lua",
+	".pl":         "perl",
+	".r":          "r",
+	".jl":         "julia",
+	".ex":         "elixir",
+	".exs":        "elixir",
+	".erl":        "erlang",
+	".hs":         "haskell",
+	".ml":         "ocaml",
+	".clj":        "clojure",
+	".elm":        "elm",
+	".sh":         "bash",
+	".bash":       "bash",
+	".zsh":        "zsh",
+	".fish":       "fish",
+	".ps1":        "powershell",
+	".bat":        "batch",
+	".sql":        "sql",
+	".graphql":    "graphql",
+	".gql":        "graphql",
+	".proto":      "protobuf",
+	".tf":         "hcl",
+	".hcl":        "hcl",
+	".nix":        "nix",
+	".dockerfile": "dockerfile",
+	".mk":         "makefile",
+	".cmake":      "cmake",
+	".tex":        "latex",
+	".csv":        "csv",
+	".diff":       "diff",
+	".patch":      "diff",
+	".mustache":   "handlebars",
+	".tmpl":       "gotemplate",
+	".vyb":        "yaml",
+}
+
+// filenames maps well-known file names, which carry no (or a misleading)
+// extension, to their language.
+var filenames = map[string]string{
+	"dockerfile":     "dockerfile",
+	"containerfile":  "dockerfile",
+	"makefile":       "makefile",
+	"gnumakefile":    "makefile",
+	"cmakelists.txt": "cmake",
+	"go.mod":         "go",
+	"go.sum":         "text",
+	"gemfile":        "ruby",
+	"rakefile":       "ruby",
+	"jenkinsfile":    "groovy",
+	"vagrantfile":    "ruby",
+	".bashrc":        "bash",
+	".zshrc":         "zsh",
+	".gitignore":     "gitignore",
+	".dockerignore":  "gitignore",
+	".editorconfig":  "ini",
+}
+
+// getLanguageFromFilename returns a language identifier based on the file
+// name or extension.
+func getLanguageFromFilename(filename string) string {
+	base := strings.ToLower(path.Base(filename))
+	if lang, ok := filenames[base]; ok {
+		return lang
+	}
+	if lang, ok := languages[path.Ext(base)]; ok {
+		return lang
+	}
+	// Dockerfile.dev, Makefile.local and the like.
+	for _, prefix := range []string{"dockerfile", "makefile"} {
+		if strings.HasPrefix(base, prefix+".") {
+			return filenames[prefix]
+		}
+	}
+	// Default: no language specified.
+	return ""
+}
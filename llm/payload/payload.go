@@ -13,9 +13,9 @@ type fileEntry struct {
 	Content string
 }
 
-// BuildUserMessage constructs a Markdown-formatted string that includes the content of all files in scope.
+// BuildUserMessage constructs a Markdown-formatted string that includes the content of all files in scope, each delimited as enc says.
 // projectRoot represents the base directory for this project, and all file paths in the given filePaths parameter are relative to projectRoot.
-func BuildUserMessage(projectRoot fs.FS, filePaths []string) (string, error) {
+func BuildUserMessage(projectRoot fs.FS, filePaths []string, enc FileEncoding) (string, error) {
 	var files []fileEntry
 	for _, path := range filePaths {
 		data, err := fs.ReadFile(projectRoot, path)
@@ -27,7 +27,7 @@ func BuildUserMessage(projectRoot fs.FS, filePaths []string) (string, error) {
 			Content: string(data),
 		})
 	}
-	markdown := buildPayload(files)
+	markdown := buildPayload(files, enc)
 	return markdown, nil
 }
 
@@ -44,10 +44,21 @@ type WorkspaceChangeProposal struct {
 }
 
 // FileChangeProposal represents a single file modification.
+//
+// A file is either deleted, written with Content, or patched with Edits or
+// a Diff. Patches only apply to existing files; see Apply.
 type FileChangeProposal struct {
 	FileName string `json:"file_name"`
 	Content  string `json:"content"`
 	Delete   bool   `json:"delete"`
+	Edits    []Edit `json:"edits,omitempty"`
+	Diff     string `json:"diff,omitempty"`
+}
+
+// Edit replaces the only occurrence of Search in a file with Replace.
+type Edit struct {
+	Search  string `json:"search"`
+	Replace string `json:"replace"`
 }
 
 // ModuleSelfContainedContext captures the context of a module and its sub-modules.
@@ -132,7 +143,7 @@ func BuildModuleContextUserMessage(projectRoot fs.FS, request *ModuleSelfContain
 		if err != nil {
 			return "", fmt.Errorf("failed to read file %s: %w", fullPath, err)
 		}
-		writeFile(&sb, fullPath, string(data))
+		writeFile(&sb, fullPath, string(data), EncodingMarkdown)
 	}
 
 	// -----------------------------
@@ -159,11 +170,11 @@ func BuildModuleContextUserMessage(projectRoot fs.FS, request *ModuleSelfContain
 }
 
 // buildPayload constructs a Markdown payload from a slice of fileEntry.
-// Each file is represented with an H1 header for its relative path, followed by a code block.
-func buildPayload(files []fileEntry) string {
+// Each file is represented with an H3 header for its relative path, followed by a code block, or as a <file> element.
+func buildPayload(files []fileEntry, enc FileEncoding) string {
 	var sb strings.Builder
 	for _, f := range files {
-		writeFile(&sb, f.Path, f.Content)
+		writeFile(&sb, f.Path, f.Content, enc)
 	}
 	return sb.String()
 }
@@ -192,36 +203,6 @@ func writeModule(sb *strings.Builder, path string, context *ModuleSelfContainedC
 	}
 }
 
-func writeFile(sb *strings.Builder, filepath, content string) {
-	if sb == nil {
-		return
-	}
-	lang := getLanguageFromFilename(filepath)
-	sb.WriteString(fmt.Sprintf("### %s\n", filepath))
-	sb.WriteString(fmt.Sprintf("```%s\n", lang))
-	sb.WriteString(content)
-	// Ensure a trailing newline before closing the code block.
-	if !strings.HasSuffix(content, "\n") {
-		sb.WriteString("\n")
-	}
-	sb.WriteString("```\n\n")
-}
-
-// getLanguageFromFilename returns a language identifier based on file extension.
-func getLanguageFromFilename(filename string) string {
-	if strings.HasSuffix(filename, ".go") {
-		return "go"
-	} else if strings.HasSuffix(filename, ".md") {
-		return "markdown"
-	} else if strings.HasSuffix(filename, ".json") {
-		return "json"
-	} else if strings.HasSuffix(filename, ".txt") {
-		return "text"
-	}
-	// Default: no language specified.
-	return ""
-}
-
 // ModuleExternalContextResponse captures the LLM response when generating
 // external contexts for a set of modules.
 type ModuleExternalContextResponse struct {
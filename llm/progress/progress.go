@@ -0,0 +1,151 @@
+// Package progress reports the progress of LLM responses while they are
+// streamed.
+//
+// Commands that keep the user waiting on a single request attach a writer
+// to the context they pass to the llm package. Providers that support it
+// then use the streaming variant of their API and feed every chunk of the
+// response to a Tracker, which keeps a status line up to date: tokens
+// received, elapsed time and the file the model is currently writing.
+// Without a writer, providers send regular requests.
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ctxKey struct{}
+
+// WithWriter returns a copy of ctx whose responses are streamed, with
+// their progress reported on w. A nil w leaves ctx untouched.
+func WithWriter(ctx context.Context, w io.Writer) context.Context {
+	if w == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, w)
+}
+
+// Enabled reports whether responses requested with ctx should be
+// streamed.
+func Enabled(ctx context.Context) bool {
+	_, ok := ctx.Value(ctxKey{}).(io.Writer)
+	return ok
+}
+
+// interval is how often the status line is refreshed. Reasoning models
+// may not send a single token for minutes, so it is refreshed even when
+// nothing was received.
+var interval = time.Second
+
+// fileName matches the file_name fields of workspace change proposals, as
+// the model writes them.
+var fileName = regexp.MustCompile(`"file_name"\s*:\s*("(?:[^"\\]|\\.)*")`)
+
+// Tracker keeps the status line of a single response. A nil Tracker, as
+// returned by Start when progress is not enabled, ignores every call.
+type Tracker struct {
+	w     io.Writer
+	label string
+	start time.Time
+	stop  chan struct{}
+	wg    sync.WaitGroup
+
+	mu sync.Mutex
+	// received is the size of the response so far, in bytes.
+	received int
+	file     string
+	// pending is the tail of the response not matched against fileName
+	// yet; a field may be split across chunks.
+	pending string
+	// width is the length of the last status line, cleared by the next.
+	width int
+}
+
+// Start begins tracking a response, described by label (e.g. the provider
+// and the model), and refreshes its status line until Done is called.
+func Start(ctx context.Context, label string) *Tracker {
+	w, ok := ctx.Value(ctxKey{}).(io.Writer)
+	if !ok {
+		return nil
+	}
+	t := &Tracker{w: w, label: label, start: time.Now(), stop: make(chan struct{})}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.mu.Lock()
+				t.print("")
+				t.mu.Unlock()
+			}
+		}
+	}()
+	return t
+}
+
+// Add records a chunk of the response text.
+func (t *Tracker) Add(chunk string) {
+	if t == nil || chunk == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.received += len(chunk)
+	t.pending += chunk
+	if m := fileName.FindAllStringSubmatchIndex(t.pending, -1); len(m) > 0 {
+		last := m[len(m)-1]
+		var name string
+		if err := json.Unmarshal([]byte(t.pending[last[2]:last[3]]), &name); err == nil {
+			t.file = name
+		}
+		t.pending = t.pending[last[1]:]
+	}
+	// a partial field is never longer than a path, give or take.
+	if n := len(t.pending); n > 1024 {
+		t.pending = t.pending[n-1024:]
+	}
+}
+
+// Done stops refreshing the status line, and ends it.
+func (t *Tracker) Done() {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	t.wg.Wait()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.print("\n")
+}
+
+// print rewrites the status line, followed by end. t.mu must be held.
+func (t *Tracker) print(end string) {
+	elapsed := time.Since(t.start).Round(time.Second)
+	var line string
+	if t.received == 0 {
+		line = fmt.Sprintf("%s: waiting for the response, %s elapsed", t.label, elapsed)
+	} else {
+		// four bytes per token, as the rate limiter estimates requests.
+		line = fmt.Sprintf("%s: ~%d tokens received, %s elapsed", t.label, (t.received+3)/4, elapsed)
+	}
+	if t.file != "" {
+		line += ", writing " + t.file
+	}
+	pad := ""
+	if n := t.width - len(line); n > 0 {
+		pad = strings.Repeat(" ", n)
+	}
+	t.width = len(line)
+	fmt.Fprintf(t.w, "\r%s%s%s", line, pad, end)
+}
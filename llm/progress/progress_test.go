@@ -0,0 +1,53 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStart_Disabled(t *testing.T) {
+	ctx := context.Background()
+	if Enabled(ctx) || Enabled(WithWriter(ctx, nil)) {
+		t.Fatalf("expected progress to be disabled")
+	}
+	tr := Start(ctx, "openai o3")
+	if tr != nil {
+		t.Fatalf("expected a nil Tracker, got %+v", tr)
+	}
+	// a nil Tracker is usable.
+	tr.Add("chunk")
+	tr.Done()
+}
+
+func TestTracker(t *testing.T) {
+	old := interval
+	interval = 10 * time.Millisecond
+	defer func() { interval = old }()
+
+	var out bytes.Buffer
+	ctx := WithWriter(context.Background(), &out)
+	if !Enabled(ctx) {
+		t.Fatalf("expected progress to be enabled")
+	}
+	tr := Start(ctx, "openai o3")
+	time.Sleep(25 * time.Millisecond)
+
+	// the field is split across chunks, and its value is JSON-escaped.
+	for _, chunk := range []string{`{"summary":"s","proposals":[{"file_na`, `me": "cmd/a.go","content":"pack`, `age a"},{"file_name":"cmd/\"b\".go"`} {
+		tr.Add(chunk)
+	}
+	tr.Done()
+
+	got := out.String()
+	if !strings.Contains(got, "\ropenai o3: waiting for the response, 0s elapsed") {
+		t.Errorf("expected a heartbeat before the first chunk, got %q", got)
+	}
+	lines := strings.Split(got, "\r")
+	last := lines[len(lines)-1]
+	if !strings.HasPrefix(last, "openai o3: ~26 tokens received, 0s elapsed, writing cmd/\"b\".go") || !strings.HasSuffix(last, "\n") {
+		t.Errorf("unexpected final status %q", last)
+	}
+}
@@ -1,13 +1,88 @@
 package llm
 
-// SupportedProviders returns the list of LLM providers that can be chosen
-// when initialising a new vyb project.  The slice is a copy – callers may
-// modify it without affecting the package-level data.
+import (
+    "fmt"
+    "strings"
+    "sync"
+
+    "github.com/vybdev/vyb/config"
+)
+
+// Factory creates a provider from the project configuration. It is called
+// once per resolution, i.e. for every high-level call of the llm façade.
+type Factory func(cfg *config.Config) (Provider, error)
+
+// registry holds the factories of every provider, keyed by name, in
+// registration order.
+var registry = struct {
+    sync.RWMutex
+    names     []string
+    factories map[string]Factory
+}{factories: map[string]Factory{}}
+
+// The built-in providers register themselves like any other backend.
+func init() {
+    RegisterProvider("openai", func(cfg *config.Config) (Provider, error) {
+        return &openAIProvider{cfg: cfg}, nil
+    })
+    RegisterProvider("gemini", func(cfg *config.Config) (Provider, error) {
+        return &geminiProvider{cfg: cfg}, nil
+    })
+    RegisterProvider("anthropic", func(cfg *config.Config) (Provider, error) {
+        return &anthropicProvider{cfg: cfg}, nil
+    })
+}
+
+// RegisterProvider makes the provider created by factory available under
+// name, which can then be set as `provider` (or listed under `fallback`)
+// in .vyb/config.yaml and is offered by `vyb init`. Names are case
+// insensitive.
+//
+// Registered providers are decorated like the built-in ones: retries,
+// timeouts, rate limits, schema repair, fallbacks, the response cache and
+// cassettes all apply. RegisterProvider is meant to be called from an
+// init function; it panics when name is empty or already registered, or
+// when factory is nil.
+func RegisterProvider(name string, factory Factory) {
+    key := strings.ToLower(name)
+    if key == "" {
+        panic("llm: RegisterProvider called with an empty name")
+    }
+    if factory == nil {
+        panic("llm: RegisterProvider called with a nil factory for " + name)
+    }
+    registry.Lock()
+    defer registry.Unlock()
+    if _, dup := registry.factories[key]; dup {
+        panic("llm: RegisterProvider called twice for " + name)
+    }
+    registry.names = append(registry.names, key)
+    registry.factories[key] = factory
+}
+
+// SupportedProviders returns the names of the registered providers, in
+// registration order: the built-in ones first. These are the providers
+// that can be chosen when initialising a new vyb project.  The slice is a
+// copy – callers may modify it without affecting the registry.
 func SupportedProviders() []string {
-    return append([]string(nil), supportedProviders...) // defensive copy
+    registry.RLock()
+    defer registry.RUnlock()
+    return append([]string(nil), registry.names...) // defensive copy
 }
 
-// supportedProviders holds the hard-coded list of providers until dynamic
-// registration lands.  Keep the strings in lowercase as they are written
-// verbatim to .vyb/config.yaml.
-var supportedProviders = []string{"openai", "gemini"}
+// newProvider instantiates the backend called name, using the provider
+// settings found in cfg. Names that are not registered are looked up in
+// the plugins section.
+func newProvider(name string, cfg *config.Config) (Provider, error) {
+    key := strings.ToLower(name)
+    registry.RLock()
+    factory := registry.factories[key]
+    registry.RUnlock()
+    if factory != nil {
+        return factory(cfg)
+    }
+    if cfg.Plugin(name) != nil {
+        return &pluginProvider{name: key, cfg: cfg}, nil
+    }
+    return nil, fmt.Errorf("unknown provider: %s", name)
+}
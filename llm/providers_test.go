@@ -1,6 +1,13 @@
 package llm
 
-import "testing"
+import (
+    "context"
+    "reflect"
+    "testing"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/payload"
+)
 
 func TestSupportedProvidersContainsGemini(t *testing.T) {
     providers := SupportedProviders()
@@ -15,3 +22,71 @@ func TestSupportedProvidersContainsGemini(t *testing.T) {
         t.Fatalf("SupportedProviders() = %v, want to contain 'gemini'", providers)
     }
 }
+
+func TestSupportedProvidersInstantiate(t *testing.T) {
+    for _, p := range SupportedProviders() {
+        if _, err := newProvider(p, &config.Config{}); err != nil {
+            t.Fatalf("newProvider(%q) returned unexpected error: %v", p, err)
+        }
+    }
+    if _, err := newProvider("fooai", &config.Config{}); err == nil {
+        t.Fatalf("expected error for unknown provider, got nil")
+    }
+}
+
+func TestNewProvider_Plugin(t *testing.T) {
+    cfg := &config.Config{Plugins: map[string]*config.PluginConfig{"gateway": {Command: "vyb-gw"}}}
+    p, err := newProvider("Gateway", cfg)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    pp, ok := p.(*pluginProvider)
+    if !ok || pp.name != "gateway" {
+        t.Fatalf("expected a plugin provider named gateway, got %#v", p)
+    }
+}
+
+func TestRegisterProvider(t *testing.T) {
+    fake := &fakeProvider{reply: &payload.ChatReply{Answer: "from the fake"}}
+    var got *config.Config
+    RegisterProvider("Registry-Test", func(cfg *config.Config) (Provider, error) {
+        got = cfg
+        return fake, nil
+    })
+
+    names := SupportedProviders()
+    if !reflect.DeepEqual(names[:3], []string{"openai", "gemini", "anthropic"}) || names[len(names)-1] != "registry-test" {
+        t.Fatalf("SupportedProviders() = %v, want the built-in providers first, then registry-test", names)
+    }
+
+    cfg := &config.Config{Provider: "registry-test"}
+    history := []payload.Message{{Role: payload.RoleUser, Content: "hi"}}
+    reply, err := GetChatReply(context.Background(), cfg, config.ModelFamilyGPT, config.ModelSizeSmall, config.GenerationParams{}, "sys", history)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if reply.Answer != "from the fake" || fake.calls != 1 || got != cfg {
+        t.Fatalf("expected the registered provider to answer, got %+v after %d calls", reply, fake.calls)
+    }
+    // registered providers are decorated like the built-in ones.
+    if p, err := resolveProvider(cfg); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    } else if _, ok := p.(*cachingProvider); !ok {
+        t.Fatalf("expected a decorated provider, got %T", p)
+    }
+
+    for name, factory := range map[string]Factory{
+        "REGISTRY-TEST": func(*config.Config) (Provider, error) { return fake, nil },
+        "":              func(*config.Config) (Provider, error) { return fake, nil },
+        "nil-factory":   nil,
+    } {
+        func() {
+            defer func() {
+                if recover() == nil {
+                    t.Errorf("RegisterProvider(%q) did not panic", name)
+                }
+            }()
+            RegisterProvider(name, factory)
+        }()
+    }
+}
@@ -0,0 +1,154 @@
+package llm
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/payload"
+)
+
+// Rate limits are enforced by retryingProvider, which waits for the
+// requests-per-minute and tokens-per-minute budgets configured for its
+// provider to allow every attempt through, retries included.
+//
+// The budgets are token buckets that refill continuously: a limit of 60
+// requests per minute admits a burst of 60 requests, then one request per
+// second. Token usage is estimated from the size of the messages before
+// the request is sent, as the real count is only known once it was paid
+// for.
+
+// estimateTokens approximates the number of tokens of msgs, at four bytes
+// per token.
+func estimateTokens(msgs ...string) int {
+    n := 0
+    for _, m := range msgs {
+        n += len(m)
+    }
+    return (n + 3) / 4
+}
+
+// historyTokens estimates the tokens of a conversation.
+func historyTokens(sysMsg string, history []payload.Message) int {
+    msgs := []string{sysMsg}
+    for _, m := range history {
+        msgs = append(msgs, m.Content)
+    }
+    return estimateTokens(msgs...)
+}
+
+// limiters holds the rate limiter of every provider, so the budgets are
+// shared by all the calls made by the process, whichever command or
+// goroutine makes them.
+var limiters = struct {
+    sync.Mutex
+    m map[string]*rateLimiter
+}{m: map[string]*rateLimiter{}}
+
+// limiterFor returns the process-wide limiter enforcing cfg for the named
+// provider, or nil when cfg sets no limit.
+func limiterFor(name string, cfg *config.RateLimitConfig) *rateLimiter {
+    if cfg == nil || (cfg.RequestsPerMinute <= 0 && cfg.TokensPerMinute <= 0) {
+        return nil
+    }
+    key := fmt.Sprintf("%s/%d/%d", strings.ToLower(name), cfg.RequestsPerMinute, cfg.TokensPerMinute)
+    limiters.Lock()
+    defer limiters.Unlock()
+    l, ok := limiters.m[key]
+    if !ok {
+        l = newRateLimiter(cfg.RequestsPerMinute, cfg.TokensPerMinute)
+        limiters.m[key] = l
+    }
+    return l
+}
+
+// rateLimiter enforces a requests-per-minute and a tokens-per-minute
+// budget. It is safe for concurrent use.
+type rateLimiter struct {
+    mu       sync.Mutex
+    requests *bucket
+    tokens   *bucket
+    // now and sleep are replaced in tests to avoid real waits.
+    now   func() time.Time
+    sleep func(context.Context, time.Duration) error
+}
+
+func newRateLimiter(rpm, tpm int) *rateLimiter {
+    l := &rateLimiter{now: time.Now, sleep: sleep}
+    start := l.now()
+    if rpm > 0 {
+        l.requests = newBucket(rpm, start)
+    }
+    if tpm > 0 {
+        l.tokens = newBucket(tpm, start)
+    }
+    return l
+}
+
+// wait blocks until a request of the given number of tokens fits in the
+// budgets, and takes it out of them. It fails only when ctx is done. A nil
+// limiter never waits.
+func (l *rateLimiter) wait(ctx context.Context, tokens int) error {
+    if l == nil {
+        return nil
+    }
+    for {
+        l.mu.Lock()
+        now := l.now()
+        delay := max(l.requests.delay(1, now), l.tokens.delay(tokens, now))
+        if delay == 0 {
+            l.requests.take(1)
+            l.tokens.take(tokens)
+            l.mu.Unlock()
+            return nil
+        }
+        l.mu.Unlock()
+        if err := l.sleep(ctx, delay); err != nil {
+            return err
+        }
+    }
+}
+
+// bucket is a token bucket holding up to one minute worth of budget. All
+// methods are safe to call on a nil receiver, which never limits.
+type bucket struct {
+    capacity float64
+    level    float64
+    last     time.Time
+}
+
+func newBucket(perMinute int, now time.Time) *bucket {
+    return &bucket{capacity: float64(perMinute), level: float64(perMinute), last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+    if elapsed := now.Sub(b.last); elapsed > 0 {
+        b.level = math.Min(b.capacity, b.level+b.capacity*elapsed.Minutes())
+        b.last = now
+    }
+}
+
+// delay returns how long to wait before n units are available. Requests
+// larger than the whole budget wait for a full bucket instead of forever.
+func (b *bucket) delay(n int, now time.Time) time.Duration {
+    if b == nil {
+        return 0
+    }
+    b.refill(now)
+    need := math.Min(float64(n), b.capacity) - b.level
+    if need <= 0 {
+        return 0
+    }
+    return time.Duration(math.Ceil(need / b.capacity * float64(time.Minute)))
+}
+
+func (b *bucket) take(n int) {
+    if b == nil {
+        return
+    }
+    b.level -= math.Min(float64(n), b.capacity)
+}
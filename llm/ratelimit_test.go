@@ -0,0 +1,119 @@
+package llm
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/internal/transport"
+)
+
+// newTestLimiter returns a limiter whose clock only advances when it
+// sleeps, and the list of the delays it slept for.
+func newTestLimiter(rpm, tpm int) (*rateLimiter, *[]time.Duration) {
+    now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+    var slept []time.Duration
+    l := newRateLimiter(rpm, tpm)
+    l.now = func() time.Time { return now }
+    l.sleep = func(_ context.Context, d time.Duration) error {
+        slept = append(slept, d)
+        now = now.Add(d)
+        return nil
+    }
+    l.requests, l.tokens = nil, nil
+    if rpm > 0 {
+        l.requests = newBucket(rpm, now)
+    }
+    if tpm > 0 {
+        l.tokens = newBucket(tpm, now)
+    }
+    return l, &slept
+}
+
+func TestRateLimiter_RequestsPerMinute(t *testing.T) {
+    l, slept := newTestLimiter(60, 0)
+
+    // The full budget is available as a burst...
+    for i := 0; i < 60; i++ {
+        if err := l.wait(context.Background(), 0); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+    }
+    if len(*slept) != 0 {
+        t.Fatalf("burst should not wait, slept %v", *slept)
+    }
+    // ...then requests are admitted at the refill rate.
+    if err := l.wait(context.Background(), 0); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if len(*slept) != 1 || (*slept)[0] != time.Second {
+        t.Fatalf("expected a one second wait, slept %v", *slept)
+    }
+}
+
+func TestRateLimiter_TokensPerMinute(t *testing.T) {
+    l, slept := newTestLimiter(0, 1000)
+
+    for _, tokens := range []int{800, 400} {
+        if err := l.wait(context.Background(), tokens); err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+    }
+    // 200 tokens were left, 200 more refill in 12s.
+    if len(*slept) != 1 || (*slept)[0] != 12*time.Second {
+        t.Fatalf("expected a 12s wait, slept %v", *slept)
+    }
+
+    // Requests larger than the budget wait for a full bucket.
+    *slept = nil
+    if err := l.wait(context.Background(), 5000); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if len(*slept) != 1 || (*slept)[0] != time.Minute {
+        t.Fatalf("expected a one minute wait, slept %v", *slept)
+    }
+}
+
+func TestRateLimiter_Cancelled(t *testing.T) {
+    l := newRateLimiter(1, 0)
+    if err := l.wait(context.Background(), 0); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if err := l.wait(ctx, 0); err != context.Canceled {
+        t.Fatalf("expected context.Canceled, got %v", err)
+    }
+}
+
+func TestLimiterFor_IsSharedPerProvider(t *testing.T) {
+    cfg := &config.RateLimitConfig{RequestsPerMinute: 7}
+    if limiterFor("openai", nil) != nil || limiterFor("openai", &config.RateLimitConfig{}) != nil {
+        t.Fatalf("expected no limiter without limits")
+    }
+    if limiterFor("openai", cfg) != limiterFor("OpenAI", &config.RateLimitConfig{RequestsPerMinute: 7}) {
+        t.Fatalf("expected calls to share the provider limiter")
+    }
+    if limiterFor("openai", cfg) == limiterFor("gemini", cfg) {
+        t.Fatalf("expected providers to have distinct limiters")
+    }
+}
+
+func TestRetryingProvider_ChargesEveryAttempt(t *testing.T) {
+    l, slept := newTestLimiter(0, 10)
+    // 40 bytes ≈ 10 tokens: the first attempt drains the bucket, and the
+    // retry after a 429 waits for it to refill.
+    inner := &flakyProvider{errs: []error{&transport.Error{StatusCode: http.StatusTooManyRequests, Err: errors.New("rate limited")}}}
+    p, _ := newTestRetrying(inner, 3)
+    p.limiter = l
+
+    if _, err := p.GetModuleContext(context.Background(), "0123456789", "012345678901234567890123456789"); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if inner.calls != 2 || len(*slept) != 1 || (*slept)[0] != time.Minute {
+        t.Fatalf("expected the retry to wait a minute for budget, got %d calls, slept %v", inner.calls, *slept)
+    }
+}
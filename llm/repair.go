@@ -0,0 +1,91 @@
+package llm
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/internal/validate"
+    "github.com/vybdev/vyb/llm/payload"
+)
+
+// SchemaError is returned when a provider keeps answering with output that
+// does not match the expected JSON schema. Its Violations name every
+// offending field, e.g. "proposals[0].file_name".
+type SchemaError = validate.Error
+
+// SchemaViolation is a single problem reported by a SchemaError.
+type SchemaViolation = validate.Violation
+
+// maxRepairAttempts bounds the follow-up requests sent to a provider whose
+// answer did not match the schema.
+const maxRepairAttempts = 1
+
+// repairingProvider re-issues a request whose response failed schema
+// validation, telling the model what was wrong with its previous answer.
+// Once maxRepairAttempts is exhausted the *SchemaError is returned.
+type repairingProvider struct {
+    inner Provider
+}
+
+func (p *repairingProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+    return withRepair(userMsg, func(userMsg string) (*payload.WorkspaceChangeProposal, error) {
+        return p.inner.GetWorkspaceChangeProposals(ctx, fam, sz, gen, sysMsg, userMsg)
+    })
+}
+
+func (p *repairingProvider) GetModuleContext(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    return withRepair(userMsg, func(userMsg string) (*payload.ModuleSelfContainedContext, error) {
+        return p.inner.GetModuleContext(ctx, sysMsg, userMsg)
+    })
+}
+
+func (p *repairingProvider) GetModuleExternalContexts(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
+    return withRepair(userMsg, func(userMsg string) (*payload.ModuleExternalContextResponse, error) {
+        return p.inner.GetModuleExternalContexts(ctx, sysMsg, userMsg)
+    })
+}
+
+// GetChatReply repairs the last user turn of history, leaving the rest of
+// the conversation untouched.
+func (p *repairingProvider) GetChatReply(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg string, history []payload.Message) (*payload.ChatReply, error) {
+    last := len(history) - 1
+    if last < 0 {
+        return p.inner.GetChatReply(ctx, fam, sz, gen, sysMsg, history)
+    }
+    return withRepair(history[last].Content, func(userMsg string) (*payload.ChatReply, error) {
+        h := append(append([]payload.Message(nil), history[:last]...), payload.Message{Role: history[last].Role, Content: userMsg})
+        return p.inner.GetChatReply(ctx, fam, sz, gen, sysMsg, h)
+    })
+}
+
+func withRepair[T any](userMsg string, call func(userMsg string) (*T, error)) (*T, error) {
+    out, err := call(userMsg)
+    for attempt := 0; attempt < maxRepairAttempts; attempt++ {
+        var schemaErr *SchemaError
+        if !errors.As(err, &schemaErr) {
+            break
+        }
+        fmt.Printf("LLM response rejected (%v), requesting a corrected answer\n", err)
+        out, err = call(repairMessage(userMsg, schemaErr))
+    }
+    return out, err
+}
+
+// repairMessage appends the validation errors of the previous answer to
+// the original user message.
+func repairMessage(userMsg string, schemaErr *SchemaError) string {
+    var b strings.Builder
+    b.WriteString(userMsg)
+    b.WriteString("\n\n# Correction\n")
+    b.WriteString("A previous answer to this request did not conform to the required JSON schema:\n")
+    for _, v := range schemaErr.Violations {
+        b.WriteString("- ")
+        b.WriteString(v.String())
+        b.WriteString("\n")
+    }
+    b.WriteString("Answer again with a complete response that fixes every problem listed above.\n")
+    return b.String()
+}
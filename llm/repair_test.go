@@ -0,0 +1,102 @@
+package llm
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/internal/validate"
+    "github.com/vybdev/vyb/llm/payload"
+)
+
+// invalidProvider fails schema validation for the first n calls.
+type invalidProvider struct {
+    fakeProvider
+    n         int
+    userMsgs  []string
+    histories [][]payload.Message
+}
+
+func (p *invalidProvider) GetModuleContext(_ context.Context, _, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    p.calls++
+    p.userMsgs = append(p.userMsgs, userMsg)
+    if p.calls <= p.n {
+        return nil, &validate.Error{Provider: "fake", Schema: validate.ModuleContext, Violations: []validate.Violation{{Field: "public_context", Problem: "is required"}}}
+    }
+    return &payload.ModuleSelfContainedContext{PublicContext: "ok"}, nil
+}
+
+func (p *invalidProvider) GetChatReply(_ context.Context, _ config.ModelFamily, _ config.ModelSize, _ config.GenerationParams, _ string, history []payload.Message) (*payload.ChatReply, error) {
+    p.calls++
+    p.histories = append(p.histories, history)
+    if p.calls <= p.n {
+        return nil, &validate.Error{Provider: "fake", Schema: validate.ChatReply, Violations: []validate.Violation{{Field: "answer", Problem: "is required"}}}
+    }
+    return &payload.ChatReply{Answer: "ok"}, nil
+}
+
+func TestRepair_SendsViolations(t *testing.T) {
+    inner := &invalidProvider{n: 1}
+    p := &repairingProvider{inner: inner}
+
+    got, err := p.GetModuleContext(context.Background(), "sys", "usr")
+    if err != nil || got.PublicContext != "ok" {
+        t.Fatalf("got %+v (err %v)", got, err)
+    }
+    if inner.calls != 2 {
+        t.Fatalf("provider called %d times, want 2", inner.calls)
+    }
+    repair := inner.userMsgs[1]
+    if !strings.HasPrefix(repair, "usr") || !strings.Contains(repair, "- public_context: is required") {
+        t.Fatalf("repair request does not carry the violations:\n%s", repair)
+    }
+}
+
+func TestRepair_IsBounded(t *testing.T) {
+    inner := &invalidProvider{n: 10}
+    p := &repairingProvider{inner: inner}
+
+    _, err := p.GetModuleContext(context.Background(), "sys", "usr")
+    var schemaErr *SchemaError
+    if !errors.As(err, &schemaErr) || schemaErr.Violations[0].Field != "public_context" {
+        t.Fatalf("expected a SchemaError naming the field, got %v", err)
+    }
+    if inner.calls != 1+maxRepairAttempts {
+        t.Fatalf("provider called %d times, want %d", inner.calls, 1+maxRepairAttempts)
+    }
+}
+
+func TestRepair_OtherErrorsPassThrough(t *testing.T) {
+    inner := &fakeProvider{err: errors.New("boom")}
+    p := &repairingProvider{inner: inner}
+
+    if _, err := p.GetModuleContext(context.Background(), "sys", "usr"); err == nil || inner.calls != 1 {
+        t.Fatalf("expected a single failed call, got %d calls (err %v)", inner.calls, err)
+    }
+}
+
+func TestRepair_ChatRewritesOnlyTheLastTurn(t *testing.T) {
+    inner := &invalidProvider{n: 1}
+    p := &repairingProvider{inner: inner}
+    history := []payload.Message{
+        {Role: payload.RoleUser, Content: "first"},
+        {Role: payload.RoleAssistant, Content: "{}"},
+        {Role: payload.RoleUser, Content: "second"},
+    }
+
+    if got, err := p.GetChatReply(context.Background(), config.ModelFamilyGPT, config.ModelSizeSmall, config.GenerationParams{}, "sys", history); err != nil || got.Answer != "ok" {
+        t.Fatalf("got %+v (err %v)", got, err)
+    }
+    repaired := inner.histories[1]
+    if len(repaired) != 3 || repaired[0] != history[0] || repaired[1] != history[1] {
+        t.Fatalf("earlier turns were modified: %+v", repaired)
+    }
+    if !strings.HasPrefix(repaired[2].Content, "second") || !strings.Contains(repaired[2].Content, "- answer: is required") {
+        t.Fatalf("repair turn does not carry the violations:\n%s", repaired[2].Content)
+    }
+    if history[2].Content != "second" {
+        t.Fatalf("caller's history was modified")
+    }
+}
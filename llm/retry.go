@@ -0,0 +1,159 @@
+package llm
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math/rand"
+    "net"
+    "net/http"
+    "time"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/internal/transport"
+    "github.com/vybdev/vyb/llm/payload"
+)
+
+// retryingProvider decorates a provider so every call that fails with a
+// transient error is retried with exponential backoff and full jitter.
+//
+// A failure is considered transient when the provider answered with HTTP
+// 429 or 5xx (unless the account ran out of quota), when a streamed
+// response broke off, or when the request timed out. Delays requested by the provider through Retry-After always
+// take precedence over the computed backoff.
+//
+// Every attempt runs under its own deadline, sized after the model being
+// called, so a hung connection is abandoned and retried instead of
+// blocking forever. Cancelling the caller's context stops both the
+// in-flight request and any pending retry.
+//
+// When the provider has rate limits, every attempt waits for its budget
+// first. The wait is not part of the attempt's deadline.
+type retryingProvider struct {
+    inner   Provider
+    policy  config.RetryConfig
+    timeout func(config.ModelSize) time.Duration
+    // limiter is nil when the provider has no rate limits.
+    limiter *rateLimiter
+    // sleep is replaced in tests to avoid real waits.
+    sleep func(context.Context, time.Duration) error
+}
+
+func newRetryingProvider(inner Provider, policy *config.RetryConfig, timeout func(config.ModelSize) time.Duration, limiter *rateLimiter) *retryingProvider {
+    return &retryingProvider{inner: inner, policy: policy.WithDefaults(), timeout: timeout, limiter: limiter, sleep: sleep}
+}
+
+func (p *retryingProvider) GetWorkspaceChangeProposals(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg, userMsg string) (*payload.WorkspaceChangeProposal, error) {
+    return withRetry(ctx, p, sz, estimateTokens(sysMsg, userMsg), func(ctx context.Context) (*payload.WorkspaceChangeProposal, error) {
+        return p.inner.GetWorkspaceChangeProposals(ctx, fam, sz, gen, sysMsg, userMsg)
+    })
+}
+
+func (p *retryingProvider) GetModuleContext(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    return withRetry(ctx, p, annotationSize, estimateTokens(sysMsg, userMsg), func(ctx context.Context) (*payload.ModuleSelfContainedContext, error) {
+        return p.inner.GetModuleContext(ctx, sysMsg, userMsg)
+    })
+}
+
+func (p *retryingProvider) GetModuleExternalContexts(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleExternalContextResponse, error) {
+    return withRetry(ctx, p, annotationSize, estimateTokens(sysMsg, userMsg), func(ctx context.Context) (*payload.ModuleExternalContextResponse, error) {
+        return p.inner.GetModuleExternalContexts(ctx, sysMsg, userMsg)
+    })
+}
+
+func (p *retryingProvider) GetChatReply(ctx context.Context, fam config.ModelFamily, sz config.ModelSize, gen config.GenerationParams, sysMsg string, history []payload.Message) (*payload.ChatReply, error) {
+    return withRetry(ctx, p, sz, historyTokens(sysMsg, history), func(ctx context.Context) (*payload.ChatReply, error) {
+        return p.inner.GetChatReply(ctx, fam, sz, gen, sysMsg, history)
+    })
+}
+
+func withRetry[T any](ctx context.Context, p *retryingProvider, sz config.ModelSize, tokens int, call func(context.Context) (*T, error)) (*T, error) {
+    for attempt := 1; ; attempt++ {
+        if err := p.limiter.wait(ctx, tokens); err != nil {
+            return nil, err
+        }
+        out, err := attemptWithTimeout(ctx, p.timeout(sz), call)
+        if err == nil {
+            return out, nil
+        }
+        // A cancelled or expired parent context is final, whatever the
+        // provider made of it.
+        if ctx.Err() != nil {
+            return nil, err
+        }
+        retryable, retryAfter := classify(err)
+        if !retryable || attempt >= p.policy.MaxAttempts {
+            return nil, err
+        }
+        delay := retryAfter
+        if delay == 0 {
+            delay = backoff(p.policy, attempt)
+        }
+        fmt.Printf("LLM call failed (%v), retrying in %s (attempt %d/%d)\n", err, delay.Round(time.Millisecond), attempt+1, p.policy.MaxAttempts)
+        if sErr := p.sleep(ctx, delay); sErr != nil {
+            return nil, err
+        }
+    }
+}
+
+// attemptWithTimeout runs a single attempt under a deadline of d.
+func attemptWithTimeout[T any](ctx context.Context, d time.Duration, call func(context.Context) (*T, error)) (*T, error) {
+    if d <= 0 {
+        return call(ctx)
+    }
+    attemptCtx, cancel := context.WithTimeout(ctx, d)
+    defer cancel()
+    out, err := call(attemptCtx)
+    if err != nil && attemptCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+        return nil, fmt.Errorf("LLM request timed out after %s: %w", d, err)
+    }
+    return out, err
+}
+
+// sleep waits for d or until ctx is done, whichever happens first.
+func sleep(ctx context.Context, d time.Duration) error {
+    t := time.NewTimer(d)
+    defer t.Stop()
+    select {
+    case <-t.C:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// classify reports whether err is worth retrying and the delay requested
+// by the provider, if any.
+func classify(err error) (bool, time.Duration) {
+    var apiErr *transport.Error
+    if errors.As(err, &apiErr) {
+        if apiErr.QuotaExhausted {
+            return false, 0
+        }
+        if apiErr.Interrupted || apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500 {
+            return true, apiErr.RetryAfter
+        }
+        return false, 0
+    }
+    if errors.Is(err, context.DeadlineExceeded) {
+        return true, 0
+    }
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() {
+        return true, 0
+    }
+    return false, 0
+}
+
+// backoff computes the delay before attempt+1 using exponential backoff
+// with full jitter: a random duration in [0, min(max, initial*2^(attempt-1))].
+func backoff(policy config.RetryConfig, attempt int) time.Duration {
+    ceiling := policy.InitialBackoff
+    for i := 1; i < attempt && ceiling < policy.MaxBackoff; i++ {
+        ceiling *= 2
+    }
+    if ceiling > policy.MaxBackoff {
+        ceiling = policy.MaxBackoff
+    }
+    return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
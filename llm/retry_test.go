@@ -0,0 +1,160 @@
+package llm
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/vybdev/vyb/config"
+    "github.com/vybdev/vyb/llm/internal/transport"
+    "github.com/vybdev/vyb/llm/payload"
+)
+
+// flakyProvider fails with the queued errors before succeeding.
+type flakyProvider struct {
+    fakeProvider
+    errs []error
+    // hang makes every call block until its context is done.
+    hang bool
+}
+
+func (f *flakyProvider) GetModuleContext(ctx context.Context, sysMsg, userMsg string) (*payload.ModuleSelfContainedContext, error) {
+    f.calls++
+    if f.hang {
+        <-ctx.Done()
+        return nil, ctx.Err()
+    }
+    if len(f.errs) > 0 {
+        err := f.errs[0]
+        f.errs = f.errs[1:]
+        return nil, err
+    }
+    return &payload.ModuleSelfContainedContext{PublicContext: "ok"}, nil
+}
+
+// timeoutErr satisfies net.Error.
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func newTestRetrying(inner Provider, maxAttempts int) (*retryingProvider, *[]time.Duration) {
+    var slept []time.Duration
+    p := newRetryingProvider(inner, &config.RetryConfig{MaxAttempts: maxAttempts, InitialBackoff: time.Second, MaxBackoff: 4 * time.Second}, (&config.Config{}).RequestTimeout, nil)
+    p.sleep = func(ctx context.Context, d time.Duration) error {
+        slept = append(slept, d)
+        return ctx.Err()
+    }
+    return p, &slept
+}
+
+func TestRetry_TransientErrors(t *testing.T) {
+    inner := &flakyProvider{errs: []error{
+        &transport.Error{StatusCode: http.StatusTooManyRequests, RetryAfter: 7 * time.Second, Err: errors.New("rate limited")},
+        &transport.Error{StatusCode: http.StatusServiceUnavailable, Err: errors.New("unavailable")},
+        timeoutErr{},
+    }}
+    p, slept := newTestRetrying(inner, 5)
+
+    got, err := p.GetModuleContext(context.Background(), "sys", "usr")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if got.PublicContext != "ok" || inner.calls != 4 {
+        t.Fatalf("got %+v after %d calls, want success after 4", got, inner.calls)
+    }
+    if len(*slept) != 3 {
+        t.Fatalf("slept %d times, want 3", len(*slept))
+    }
+    if (*slept)[0] != 7*time.Second {
+        t.Fatalf("Retry-After not honoured: slept %s", (*slept)[0])
+    }
+    if (*slept)[1] > 2*time.Second || (*slept)[2] > 4*time.Second {
+        t.Fatalf("backoff exceeded its ceiling: %v", *slept)
+    }
+}
+
+func TestRetry_GivesUpAfterMaxAttempts(t *testing.T) {
+    lastErr := &transport.Error{StatusCode: http.StatusInternalServerError, Err: errors.New("boom")}
+    inner := &flakyProvider{errs: []error{lastErr, lastErr, lastErr, lastErr}}
+    p, _ := newTestRetrying(inner, 3)
+
+    if _, err := p.GetModuleContext(context.Background(), "sys", "usr"); !errors.Is(err, lastErr) {
+        t.Fatalf("expected last error to be returned, got %v", err)
+    }
+    if inner.calls != 3 {
+        t.Fatalf("provider called %d times, want 3", inner.calls)
+    }
+}
+
+func TestRetry_PermanentErrors(t *testing.T) {
+    cases := []error{
+        &transport.Error{StatusCode: http.StatusBadRequest, Err: errors.New("bad request")},
+        &transport.Error{StatusCode: http.StatusUnauthorized, Err: errors.New("bad key")},
+        &transport.Error{StatusCode: http.StatusTooManyRequests, QuotaExhausted: true, Err: errors.New("no credit")},
+        errors.New("gemini: empty response"),
+    }
+    for _, c := range cases {
+        inner := &flakyProvider{errs: []error{c}}
+        p, _ := newTestRetrying(inner, 5)
+        if _, err := p.GetModuleContext(context.Background(), "sys", "usr"); err == nil || inner.calls != 1 {
+            t.Fatalf("%v: expected a single failing call, got %d calls (err %v)", c, inner.calls, err)
+        }
+    }
+}
+
+func TestRetry_PerAttemptTimeout(t *testing.T) {
+    inner := &flakyProvider{hang: true}
+    p, slept := newTestRetrying(inner, 3)
+    p.timeout = func(config.ModelSize) time.Duration { return 10 * time.Millisecond }
+
+    _, err := p.GetModuleContext(context.Background(), "sys", "usr")
+    if !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("expected a deadline error, got %v", err)
+    }
+    if inner.calls != 3 || len(*slept) != 2 {
+        t.Fatalf("got %d calls and %d sleeps, want timed out attempts to be retried", inner.calls, len(*slept))
+    }
+}
+
+func TestRetry_StopsWhenCancelled(t *testing.T) {
+    inner := &flakyProvider{errs: []error{
+        &transport.Error{StatusCode: http.StatusServiceUnavailable, Err: errors.New("unavailable")},
+    }}
+    p, _ := newTestRetrying(inner, 5)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if _, err := p.GetModuleContext(ctx, "sys", "usr"); err == nil {
+        t.Fatalf("expected an error once the context is cancelled")
+    }
+    if inner.calls != 1 {
+        t.Fatalf("provider called %d times after cancellation, want 1", inner.calls)
+    }
+}
+
+func TestSleep_Cancelled(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if err := sleep(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+        t.Fatalf("sleep returned %v, want context.Canceled", err)
+    }
+}
+
+func TestBackoff(t *testing.T) {
+    policy := config.RetryConfig{MaxAttempts: 10, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+    for attempt := 1; attempt < 10; attempt++ {
+        for i := 0; i < 50; i++ {
+            d := backoff(policy, attempt)
+            if d < 0 || d > policy.MaxBackoff {
+                t.Fatalf("backoff(%d) = %s, outside [0, %s]", attempt, d, policy.MaxBackoff)
+            }
+            if attempt == 1 && d > time.Second {
+                t.Fatalf("backoff(1) = %s, want <= 1s", d)
+            }
+        }
+    }
+}
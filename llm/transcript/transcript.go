@@ -0,0 +1,230 @@
+// Package transcript writes debug transcripts of the requests sent to the
+// LLM providers and of the responses they returned.
+//
+// Transcripts are opt-in: commands attach a Logger to the context they
+// pass to the llm package only when logging is enabled in
+// .vyb/config.yaml, and providers call Write after every request. Every
+// transcript is redacted before it reaches the disk, and the oldest ones
+// are deleted once the configured number of files is exceeded.
+package transcript
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vybdev/vyb/config"
+)
+
+// Dir returns the transcript directory of the project rooted at
+// projectRoot: the one configured in cfg, or .vyb/logs.
+func Dir(projectRoot string, cfg *config.LogsConfig) string {
+	if cfg != nil && cfg.Dir != "" {
+		return cfg.Dir
+	}
+	return filepath.Join(projectRoot, ".vyb", "logs")
+}
+
+// Entry is the content of a single transcript file.
+type Entry struct {
+	Time     time.Time       `json:"time"`
+	Provider string          `json:"provider"`
+	URL      string          `json:"url,omitempty"`
+	Status   int             `json:"status,omitempty"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Body converts a request or response body into a value that can be
+// stored in an Entry: JSON is kept as is, anything else is stored as a
+// JSON string.
+func Body(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return nil
+	}
+	if json.Valid(b) {
+		return b
+	}
+	s, _ := json.Marshal(string(b))
+	return s
+}
+
+// Logger writes transcripts to a directory. It is safe for concurrent use.
+type Logger struct {
+	mu       sync.Mutex
+	dir      string
+	maxFiles int
+	now      func() time.Time
+}
+
+// NewLogger returns a logger writing to dir and keeping at most maxFiles
+// transcripts.
+func NewLogger(dir string, maxFiles int) *Logger {
+	return &Logger{dir: dir, maxFiles: maxFiles, now: time.Now}
+}
+
+// ForProject returns the logger configured by cfg for the project rooted at
+// projectRoot, or nil when transcripts are disabled.
+func ForProject(projectRoot string, cfg *config.LogsConfig) *Logger {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+	return NewLogger(Dir(projectRoot, cfg), cfg.WithDefaults().MaxFiles)
+}
+
+type ctxKey struct{}
+
+// WithLogger returns a copy of ctx whose LLM calls are logged by l. A nil
+// l leaves ctx untouched.
+func WithLogger(ctx context.Context, l *Logger) context.Context {
+	if l == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// Write redacts e, masking secrets as well as anything that looks like a
+// credential, and stores it with the logger attached to ctx. It is a no-op
+// when no logger is attached. Failures are printed but never fail the
+// call being logged.
+func Write(ctx context.Context, e Entry, secrets ...string) {
+	l, _ := ctx.Value(ctxKey{}).(*Logger)
+	if l == nil {
+		return
+	}
+	if err := l.write(e, secrets); err != nil {
+		fmt.Printf("warning: failed to write LLM transcript: %v\n", err)
+	}
+}
+
+func (l *Logger) write(e Entry, secrets []string) error {
+	if e.Time.IsZero() {
+		e.Time = l.now()
+	}
+	e.URL = Redact(e.URL, secrets...)
+	e.Error = Redact(e.Error, secrets...)
+	e.Request = json.RawMessage(Redact(string(e.Request), secrets...))
+	e.Response = json.RawMessage(Redact(string(e.Response), secrets...))
+
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(l.dir, 0700); err != nil {
+		return err
+	}
+	id := e.Time.UTC().Format("20060102T150405.000000000") + "-" + e.Provider
+	if err := os.WriteFile(filepath.Join(l.dir, id+".json"), data, 0600); err != nil {
+		return err
+	}
+	return l.rotate()
+}
+
+// rotate deletes the oldest transcripts beyond maxFiles.
+func (l *Logger) rotate() error {
+	if l.maxFiles <= 0 {
+		return nil
+	}
+	infos, err := List(l.dir)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(infos)-l.maxFiles; i++ {
+		if err := os.Remove(filepath.Join(l.dir, infos[i].ID+".json")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+const redacted = "[REDACTED]"
+
+// credentialPatterns match credentials regardless of whether the caller
+// knew about them: key query parameters, bearer tokens and the key
+// formats of the supported providers.
+var credentialPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)([?&](?:key|api_key|apikey|access_token)=)[^&\s"]+`), "${1}" + redacted},
+	{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`), "${1}" + redacted},
+	{regexp.MustCompile(`sk-[A-Za-z0-9_-]{16,}`), redacted},
+	{regexp.MustCompile(`AIza[0-9A-Za-z_-]{35}`), redacted},
+}
+
+// Redact masks every occurrence of secrets in s, as well as anything that
+// looks like a credential.
+func Redact(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+	for _, p := range credentialPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// Info describes a transcript file.
+type Info struct {
+	// ID identifies the transcript for Read. IDs sort chronologically.
+	ID       string
+	Time     time.Time
+	Provider string
+	Size     int64
+}
+
+// List returns the transcripts found in dir, oldest first. A missing
+// directory holds no transcripts.
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []Info
+	for _, de := range entries {
+		name := de.Name()
+		if de.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".json")
+		stamp, provider, ok := strings.Cut(id, "-")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse("20060102T150405.000000000", stamp)
+		if err != nil {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Info{ID: id, Time: t, Provider: provider, Size: info.Size()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// Read returns the content of the transcript identified by id.
+func Read(dir, id string) ([]byte, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("invalid transcript id %q", id)
+	}
+	return os.ReadFile(filepath.Join(dir, id+".json"))
+}
@@ -0,0 +1,86 @@
+package transcript
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vybdev/vyb/config"
+)
+
+func TestRedact(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"https://host/models/m:generateContent?key=abc123&alt=sse", "https://host/models/m:generateContent?key=[REDACTED]&alt=sse"},
+		{`"Authorization": "Bearer tok.en-1"`, `"Authorization": "Bearer [REDACTED]"`},
+		{"key sk-abcdefghijklmnopqrstuvwx leaked", "key [REDACTED] leaked"},
+		{"AIza" + strings.Repeat("x", 35), "[REDACTED]"},
+		{"my secret is hunter2", "my secret is [REDACTED]"},
+	}
+	for _, c := range cases {
+		if got := Redact(c.in, "hunter2"); got != c.want {
+			t.Errorf("Redact(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestWrite_RedactsAndRotates(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLogger(dir, 2)
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	ctx := WithLogger(context.Background(), l)
+
+	for i := 0; i < 3; i++ {
+		Write(ctx, Entry{
+			Provider: "gemini",
+			URL:      "https://host/m?key=s3cr3t",
+			Request:  json.RawMessage(`{"auth":"s3cr3t"}`),
+			Response: Body([]byte("not json")),
+		}, "s3cr3t")
+		now = now.Add(time.Second)
+	}
+
+	infos, err := List(dir)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected rotation to keep 2 transcripts, got %d", len(infos))
+	}
+	if infos[0].Provider != "gemini" || !infos[0].Time.Equal(time.Date(2025, 6, 1, 12, 0, 1, 0, time.UTC)) {
+		t.Fatalf("expected the oldest transcript to be deleted, got %+v", infos)
+	}
+
+	data, err := Read(dir, infos[1].ID)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if strings.Contains(string(data), "s3cr3t") {
+		t.Fatalf("transcript leaks the secret:\n%s", data)
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatalf("transcript is not valid JSON: %v", err)
+	}
+	if string(e.Response) != `"not json"` {
+		t.Errorf("unexpected response %s", e.Response)
+	}
+}
+
+func TestWrite_NoLogger(t *testing.T) {
+	// Must not panic nor write anything.
+	Write(context.Background(), Entry{Provider: "openai"})
+	if l := ForProject(t.TempDir(), &config.LogsConfig{}); l != nil {
+		t.Fatalf("expected no logger when transcripts are disabled")
+	}
+}
+
+func TestRead_RejectsPaths(t *testing.T) {
+	if _, err := Read(t.TempDir(), "../config"); err == nil {
+		t.Fatalf("expected an error for an id containing a path")
+	}
+}
@@ -0,0 +1,274 @@
+// Package usage keeps a ledger of the tokens consumed by every LLM call and
+// of their estimated cost.
+//
+// Commands attach a Ledger (and labels such as the command and module
+// being processed) to the context they pass to the llm package. Providers
+// call Report once they have parsed the usage section of a response; the
+// call is a no-op when no ledger is attached.
+package usage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vybdev/vyb/config"
+)
+
+// Record is a single line of .vyb/usage.jsonl.
+type Record struct {
+	Time     time.Time `json:"time"`
+	Command  string    `json:"command,omitempty"`
+	Module   string    `json:"module,omitempty"`
+	Provider string    `json:"provider"`
+	// FallbackFrom lists the providers that failed before Provider
+	// answered, when a fallback chain is configured.
+	FallbackFrom []string `json:"fallbackFrom,omitempty"`
+	Model        string   `json:"model"`
+	InputTokens  int      `json:"inputTokens"`
+	OutputTokens int      `json:"outputTokens"`
+	// Cost is the estimated cost of the call in USD. It is zero when the
+	// model is missing from the price table.
+	Cost float64 `json:"cost"`
+}
+
+// DefaultPrices lists the USD price per million tokens of the models vyb
+// maps to by default. Keys are matched as model prefixes, so
+// "gemini-2.5-pro" also prices "gemini-2.5-pro-preview-06-05".
+var DefaultPrices = map[string]config.ModelPrice{
+	"gpt-4.1":           {Input: 2, Output: 8},
+	"gpt-4.1-mini":      {Input: 0.4, Output: 1.6},
+	"o3":                {Input: 2, Output: 8},
+	"o4-mini":           {Input: 1.1, Output: 4.4},
+	"gemini-2.5-pro":    {Input: 1.25, Output: 10},
+	"gemini-2.5-flash":  {Input: 0.3, Output: 2.5},
+	"claude-opus-4-1":   {Input: 15, Output: 75},
+	"claude-sonnet-4-5": {Input: 3, Output: 15},
+	"claude-haiku-4-5":  {Input: 1, Output: 5},
+}
+
+// Path returns the location of the ledger of the project rooted at
+// projectRoot.
+func Path(projectRoot string) string {
+	return filepath.Join(projectRoot, ".vyb", "usage.jsonl")
+}
+
+// Ledger appends usage records to a JSON Lines file. It is safe for
+// concurrent use.
+type Ledger struct {
+	mu     sync.Mutex
+	path   string
+	prices map[string]config.ModelPrice
+	now    func() time.Time
+}
+
+// NewLedger returns a ledger writing to the usage file of projectRoot.
+// prices override DefaultPrices.
+func NewLedger(projectRoot string, prices map[string]config.ModelPrice) *Ledger {
+	merged := make(map[string]config.ModelPrice, len(DefaultPrices)+len(prices))
+	for k, v := range DefaultPrices {
+		merged[strings.ToLower(k)] = v
+	}
+	for k, v := range prices {
+		merged[strings.ToLower(k)] = v
+	}
+	return &Ledger{path: Path(projectRoot), prices: merged, now: time.Now}
+}
+
+// Cost estimates the cost in USD of a call to model. The longest matching
+// prefix in the price table wins; unknown models cost nothing.
+func (l *Ledger) Cost(model string, inputTokens, outputTokens int) float64 {
+	model = strings.ToLower(model)
+	var price config.ModelPrice
+	best := -1
+	for k, p := range l.prices {
+		if strings.HasPrefix(model, k) && len(k) > best {
+			price, best = p, len(k)
+		}
+	}
+	return (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1e6
+}
+
+// Add timestamps r, estimates its cost and appends it to the ledger.
+func (l *Ledger) Add(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = l.now()
+	}
+	r.Cost = l.Cost(r.Model, r.InputTokens, r.OutputTokens)
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type ctxKey int
+
+const (
+	ledgerKey ctxKey = iota
+	commandKey
+	moduleKey
+	fallbackKey
+)
+
+// WithLedger returns a copy of ctx that records usage to l.
+func WithLedger(ctx context.Context, l *Ledger) context.Context {
+	return context.WithValue(ctx, ledgerKey, l)
+}
+
+// WithCommand labels the usage recorded under ctx with the vyb command
+// being executed.
+func WithCommand(ctx context.Context, command string) context.Context {
+	return context.WithValue(ctx, commandKey, command)
+}
+
+// WithModule labels the usage recorded under ctx with the module being
+// processed.
+func WithModule(ctx context.Context, module string) context.Context {
+	return context.WithValue(ctx, moduleKey, module)
+}
+
+// WithFallbackFrom records that the providers in failed were given up on
+// before the call made under ctx.
+func WithFallbackFrom(ctx context.Context, failed []string) context.Context {
+	return context.WithValue(ctx, fallbackKey, append([]string(nil), failed...))
+}
+
+// Report records the tokens consumed by a successful call. Failures to
+// write the ledger are printed but never fail the call itself.
+func Report(ctx context.Context, provider, model string, inputTokens, outputTokens int) {
+	l, _ := ctx.Value(ledgerKey).(*Ledger)
+	if l == nil {
+		return
+	}
+	command, _ := ctx.Value(commandKey).(string)
+	module, _ := ctx.Value(moduleKey).(string)
+	fallbackFrom, _ := ctx.Value(fallbackKey).([]string)
+	err := l.Add(Record{
+		Command:      command,
+		Module:       module,
+		Provider:     provider,
+		FallbackFrom: fallbackFrom,
+		Model:        model,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+	})
+	if err != nil {
+		fmt.Printf("warning: failed to record usage: %v\n", err)
+	}
+}
+
+// Load reads every record of the ledger at path. A missing ledger holds no
+// records.
+func Load(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, r)
+	}
+	return records, sc.Err()
+}
+
+// Dimensions records can be grouped by.
+const (
+	ByDay     = "day"
+	ByCommand = "command"
+	ByModule  = "module"
+	ByModel   = "model"
+)
+
+// Total aggregates the records sharing the same Key.
+type Total struct {
+	// Key holds one value per grouping dimension, in the requested order.
+	Key          []string
+	Calls        int
+	InputTokens  int
+	OutputTokens int
+	Cost         float64
+}
+
+// Aggregate groups records by the given dimensions and returns the totals
+// sorted by key. Days are expressed in the local time zone.
+func Aggregate(records []Record, by []string) ([]Total, error) {
+	for _, dim := range by {
+		switch dim {
+		case ByDay, ByCommand, ByModule, ByModel:
+		default:
+			return nil, fmt.Errorf("usage: unknown dimension %q (want %s, %s, %s or %s)", dim, ByDay, ByCommand, ByModule, ByModel)
+		}
+	}
+
+	totals := map[string]*Total{}
+	for _, r := range records {
+		key := make([]string, len(by))
+		for i, dim := range by {
+			switch dim {
+			case ByDay:
+				key[i] = r.Time.Local().Format("2006-01-02")
+			case ByCommand:
+				key[i] = r.Command
+			case ByModule:
+				key[i] = r.Module
+			case ByModel:
+				key[i] = r.Model
+			}
+		}
+		id := strings.Join(key, "\x00")
+		t, ok := totals[id]
+		if !ok {
+			t = &Total{Key: key}
+			totals[id] = t
+		}
+		t.Calls++
+		t.InputTokens += r.InputTokens
+		t.OutputTokens += r.OutputTokens
+		t.Cost += r.Cost
+	}
+
+	out := make([]Total, 0, len(totals))
+	for _, t := range totals {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Join(out[i].Key, "\x00") < strings.Join(out[j].Key, "\x00")
+	})
+	return out, nil
+}
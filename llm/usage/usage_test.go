@@ -0,0 +1,106 @@
+package usage
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vybdev/vyb/config"
+)
+
+func TestLedgerCost(t *testing.T) {
+	l := NewLedger(t.TempDir(), map[string]config.ModelPrice{
+		"qwen3": {Input: 1, Output: 2},
+		"o3":    {Input: 10, Output: 40},
+	})
+
+	cases := []struct {
+		model string
+		want  float64
+	}{
+		{"gemini-2.5-pro-preview-06-05", 1.25 + 10},
+		{"GPT-4.1-mini", 0.4 + 1.6}, // longest prefix wins over gpt-4.1
+		{"qwen3:8b", 1 + 2},
+		{"o3", 10 + 40}, // configuration overrides the defaults
+		{"unknown", 0},
+	}
+	for _, c := range cases {
+		if got := l.Cost(c.model, 1_000_000, 1_000_000); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("Cost(%q) = %v, want %v", c.model, got, c.want)
+		}
+	}
+}
+
+func TestReportAndLoad(t *testing.T) {
+	root := t.TempDir()
+	l := NewLedger(root, nil)
+	l.now = func() time.Time { return time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local) }
+
+	// Without a ledger in the context nothing is recorded.
+	Report(context.Background(), "openai", "o3", 1, 1)
+
+	ctx := WithCommand(WithLedger(context.Background(), l), "update")
+	Report(WithModule(ctx, "a"), "openai", "o4-mini", 1000, 500)
+	Report(WithModule(ctx, "b"), "openai", "o4-mini", 2000, 0)
+
+	records, err := Load(Path(root))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	want := Record{
+		Time:         l.now(),
+		Command:      "update",
+		Module:       "a",
+		Provider:     "openai",
+		Model:        "o4-mini",
+		InputTokens:  1000,
+		OutputTokens: 500,
+		Cost:         (1000*1.1 + 500*4.4) / 1e6,
+	}
+	records[0].Time = records[0].Time.Local()
+	if !records[0].Time.Equal(want.Time) {
+		t.Fatalf("time = %v, want %v", records[0].Time, want.Time)
+	}
+	records[0].Time = want.Time
+	if !reflect.DeepEqual(records[0], want) {
+		t.Fatalf("record = %+v, want %+v", records[0], want)
+	}
+}
+
+func TestLoad_Missing(t *testing.T) {
+	records, err := Load(Path(t.TempDir()))
+	if err != nil || records != nil {
+		t.Fatalf("Load on a missing ledger = %v, %v; want nil, nil", records, err)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	day1 := time.Date(2025, 6, 1, 10, 0, 0, 0, time.Local)
+	day2 := day1.Add(24 * time.Hour)
+	records := []Record{
+		{Time: day1, Command: "init", Module: "a", InputTokens: 10, OutputTokens: 1, Cost: 1},
+		{Time: day1, Command: "init", Module: "b", InputTokens: 20, OutputTokens: 2, Cost: 2},
+		{Time: day2, Command: "refactor", Module: "a", InputTokens: 30, OutputTokens: 3, Cost: 3},
+	}
+
+	got, err := Aggregate(records, []string{ByDay, ByCommand})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Total{
+		{Key: []string{"2025-06-01", "init"}, Calls: 2, InputTokens: 30, OutputTokens: 3, Cost: 3},
+		{Key: []string{"2025-06-02", "refactor"}, Calls: 1, InputTokens: 30, OutputTokens: 3, Cost: 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Aggregate = %+v, want %+v", got, want)
+	}
+
+	if _, err := Aggregate(records, []string{"week"}); err == nil {
+		t.Fatalf("expected an error for an unknown dimension")
+	}
+}
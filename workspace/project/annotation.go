@@ -1,12 +1,15 @@
 package project
 
 import (
+	"context"
 	"fmt"
 	"github.com/vybdev/vyb/config"
 	"github.com/vybdev/vyb/llm"
 	"github.com/vybdev/vyb/llm/payload"
+	"github.com/vybdev/vyb/llm/usage"
 	"io/fs"
 	"strings"
+	"sync"
 )
 
 // Annotation holds context and summary for a Module.
@@ -21,69 +24,130 @@ type Annotation struct {
 
 // annotate navigates the modules graph, starting from the leaf-most
 // modules back to the root. For each module that has no Annotation, it calls
-// addOrUpdateSelfContainedContext for it after all its submodules are annotated. The creation of
-// annotations is performed in parallel using goroutines.
-func annotate(cfg *config.Config, metadata *Metadata, sysfs fs.FS) error {
+// addOrUpdateSelfContainedContext for it after all its submodules are
+// annotated. Up to the configured number of modules are annotated in
+// parallel.
+//
+// The first failure, or cancellation of ctx, stops every pending
+// annotation: in-flight LLM requests are aborted and modules that did not
+// start yet are never sent.
+func annotate(ctx context.Context, cfg *config.Config, metadata *Metadata, sysfs fs.FS) error {
 	if metadata == nil || metadata.Modules == nil {
 		return nil
 	}
 
-	// Collect modules in post-order so children come before parents.
-	modules := collectModulesInPostOrder(metadata.Modules)
-	// Channel to collect errors from annotation goroutines.
-	errCh := make(chan error, len(modules))
-	// Create a done channel for each module to signal completion of annotation.
-	dones := make(map[*Module]chan struct{})
-	for _, m := range modules {
-		dones[m] = make(chan struct{})
-	}
-	// Pre-close done channels for modules already annotated.
-	for _, m := range modules {
-		if m.Annotation != nil {
-			close(dones[m])
-		}
+	root := metadata.Modules
+	concurrency := cfg.Annotation.WithDefaults().Concurrency
+	err := annotateModules(ctx, root, concurrency, func(ctx context.Context, m *Module) error {
+		return addOrUpdateSelfContainedContext(ctx, cfg, m, sysfs)
+	})
+	if err != nil {
+		return err
 	}
 
-	// Launch annotation tasks.
-	for _, m := range modules {
+	// Add all external context annotations in a single shot
+	// In the future, we should make this take into consideration
+	// the token count of the annotations and possibly split the calls.
+	return addOrUpdateExternalContext(ctx, cfg, root)
+}
+
+// annotateModules runs annotateOne for every module of the tree rooted at
+// root that has no Annotation, children before parents, using at most
+// concurrency workers.
+//
+// Every worker exits before annotateModules returns, whether it succeeds
+// or not, so no goroutine is left waiting on a sibling after a failure.
+func annotateModules(ctx context.Context, root *Module, concurrency int, annotateOne func(context.Context, *Module) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Collect modules in post-order so children come before parents.
+	var todo []*Module
+	parents := make(map[*Module]*Module)
+	// pending counts, per module, the submodules still to be annotated.
+	pending := make(map[*Module]int)
+	scheduled := make(map[*Module]bool)
+	for _, m := range collectModulesInPostOrder(root) {
+		for _, sub := range m.Modules {
+			parents[sub] = m
+		}
 		if m.Annotation != nil {
 			fmt.Printf("module %q already has an annotation, skipping...\n", m.Name)
 			continue
 		}
 		fmt.Printf("module %q doesn't have annotation\n", m.Name)
-		// Capture m for the goroutine.
-		go func(mod *Module) {
-			// Wait for all submodules to complete.
-			for _, sub := range mod.Modules {
-				<-dones[sub]
-			}
-			err := addOrUpdateSelfContainedContext(cfg, mod, sysfs)
-			if err != nil {
-				errCh <- fmt.Errorf("failed to create annotation for module %q: %w", mod.Name, err)
-				// Signal done to avoid blocking parents.
-				close(dones[mod])
-				return
-			}
-			close(dones[mod])
-		}(m)
+		todo = append(todo, m)
+		scheduled[m] = true
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+	for _, m := range todo {
+		if p, ok := parents[m]; ok {
+			pending[p]++
+		}
 	}
 
-	// Wait for root module to finish annotation.
-	root := metadata.Modules
-	<-dones[root]
-	close(errCh)
-
-	// Check for errors.
-	for err := range errCh {
-		if err != nil {
-			return err
+	// Both channels are large enough to never block their senders.
+	ready := make(chan *Module, len(todo))
+	type result struct {
+		mod *Module
+		err error
+	}
+	results := make(chan result, len(todo))
+	for _, m := range todo {
+		if pending[m] == 0 {
+			ready <- m
 		}
 	}
 
-	// Add all external context annotations in a single shot
-	// In the future, we should make this take into consideration
-	// the token count of the annotations and possibly split the calls.
-	return addOrUpdateExternalContext(cfg, root)
+	if concurrency <= 0 || concurrency > len(todo) {
+		concurrency = len(todo)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range ready {
+				if err := ctx.Err(); err != nil {
+					results <- result{m, err}
+					continue
+				}
+				if err := annotateOne(ctx, m); err != nil {
+					results <- result{m, fmt.Errorf("failed to create annotation for module %q: %w", m.Name, err)}
+					continue
+				}
+				results <- result{m, nil}
+			}
+		}()
+	}
+
+	// Only this goroutine schedules modules, so it alone closes ready: on
+	// the first failure, or once everything was annotated.
+	var firstErr error
+	for done := 0; done < len(todo) && firstErr == nil; done++ {
+		var r result
+		select {
+		case r = <-results:
+		case <-ctx.Done():
+			r.err = ctx.Err()
+		}
+		if r.err != nil {
+			firstErr = r.err
+			break
+		}
+		fmt.Printf("annotated module %q (%d/%d done, %d remaining)\n", r.mod.Name, done+1, len(todo), len(todo)-done-1)
+		if p, ok := parents[r.mod]; ok && scheduled[p] {
+			if pending[p]--; pending[p] == 0 {
+				ready <- p
+			}
+		}
+	}
+	cancel()
+	close(ready)
+	wg.Wait()
+	return firstErr
 }
 
 // collectModulesInPostOrder gathers modules in a post-order traversal (children first).
@@ -135,7 +199,7 @@ func buildModuleContextRequest(m *Module) *payload.ModuleSelfContainedContextReq
 }
 
 // addOrUpdateSelfContainedContext calls OpenAI to construct the internal and public context of a given module.
-func addOrUpdateSelfContainedContext(cfg *config.Config, m *Module, sysfs fs.FS) error {
+func addOrUpdateSelfContainedContext(ctx context.Context, cfg *config.Config, m *Module, sysfs fs.FS) error {
 	// Build the ModuleSelfContainedContextRequest tree starting from this module.
 	req := buildModuleContextRequest(m)
 
@@ -172,7 +236,7 @@ you included in the Internal Context, but also all the Public Context informatio
 
 Each type of context should be as descriptive as possible, using around one thousand LLM tokens, each.`
 
-	context, err := llm.GetModuleContext(cfg, systemMessage, userMsg)
+	moduleCtx, err := llm.GetModuleContext(usage.WithModule(ctx, m.Name), cfg, systemMessage, userMsg)
 
 	fmt.Printf("  Got response for module %q\n", m.Name)
 
@@ -184,21 +248,21 @@ Each type of context should be as descriptive as possible, using around one thou
 		m.Annotation = &Annotation{}
 	}
 
-	if context.InternalContext != "" {
+	if moduleCtx.InternalContext != "" {
 		if m.Annotation.InternalContext != "" {
 			fmt.Printf("  Overriding field `InternalContext` of module %q.\n", m.Name)
 		} else {
 			fmt.Printf("  Creating field `InternalContext` of module %q.\n", m.Name)
 		}
-		m.Annotation.InternalContext = context.InternalContext
+		m.Annotation.InternalContext = moduleCtx.InternalContext
 	}
-	if context.PublicContext != "" {
+	if moduleCtx.PublicContext != "" {
 		if m.Annotation.PublicContext != "" {
 			fmt.Printf("  Overriding field `PublicContext` of module %q.\n", m.Name)
 		} else {
 			fmt.Printf("  Creating field `PublicContext` of module %q.\n", m.Name)
 		}
-		m.Annotation.PublicContext = context.PublicContext
+		m.Annotation.PublicContext = moduleCtx.PublicContext
 	}
 	return nil
 }
@@ -217,7 +281,7 @@ Each type of context should be as descriptive as possible, using around one thou
 //     corresponding module, creating annotation objects when necessary.
 //
 // If the LLM call fails the error is propagated to the caller.
-func addOrUpdateExternalContext(cfg *config.Config, m *Module) error {
+func addOrUpdateExternalContext(ctx context.Context, cfg *config.Config, m *Module) error {
 	if m == nil {
 		return nil
 	}
@@ -286,7 +350,7 @@ concise explanation of where the module lives in the hierarchy and what lives
 
 Return your answer as JSON following the schema you have been provided.`
 
-	resp, err := llm.GetModuleExternalContexts(cfg, sysPrompt, userMsg)
+	resp, err := llm.GetModuleExternalContexts(usage.WithModule(ctx, m.Name), cfg, sysPrompt, userMsg)
 	if err != nil {
 		return err
 	}
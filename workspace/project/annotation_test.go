@@ -0,0 +1,104 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testTree returns a root with three children, the first of which has
+// two children of its own. Module "b" is already annotated.
+func testTree() *Module {
+	return &Module{Name: ".", Modules: []*Module{
+		{Name: "a", Modules: []*Module{{Name: "a/x"}, {Name: "a/y"}}},
+		{Name: "b", Annotation: &Annotation{PublicContext: "b"}},
+		{Name: "c"},
+	}}
+}
+
+func Test_annotateModules(t *testing.T) {
+	root := testTree()
+
+	var mu sync.Mutex
+	var order []string
+	var inFlight, maxInFlight int32
+	err := annotateModules(context.Background(), root, 2, func(_ context.Context, m *Module) error {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			cur := atomic.LoadInt32(&maxInFlight)
+			if n <= cur || atomic.CompareAndSwapInt32(&maxInFlight, cur, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, sub := range m.Modules {
+			if sub.Annotation == nil {
+				t.Errorf("module %q annotated before its submodule %q", m.Name, sub.Name)
+			}
+		}
+		order = append(order, m.Name)
+		m.Annotation = &Annotation{PublicContext: m.Name}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("annotateModules returned error: %v", err)
+	}
+	if len(order) != 5 || order[len(order)-1] != "." {
+		t.Fatalf("unexpected annotation order %v", order)
+	}
+	for _, name := range order {
+		if name == "b" {
+			t.Fatalf("module b was already annotated, got %v", order)
+		}
+	}
+	if maxInFlight > 2 {
+		t.Fatalf("up to %d modules were annotated at once, want at most 2", maxInFlight)
+	}
+}
+
+func Test_annotateModules_FailureStopsSiblings(t *testing.T) {
+	root := testTree()
+	boom := errors.New("boom")
+
+	var calls int32
+	err := annotateModules(context.Background(), root, 1, func(ctx context.Context, m *Module) error {
+		atomic.AddInt32(&calls, 1)
+		if m.Name == "a/x" {
+			return boom
+		}
+		m.Annotation = &Annotation{}
+		return nil
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected the module failure, got %v", err)
+	}
+	// annotateModules only returns once every worker is gone.
+	n := atomic.LoadInt32(&calls)
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt32(&calls) != n {
+		t.Fatalf("modules were annotated after annotateModules returned")
+	}
+	if root.Annotation != nil || root.Modules[0].Annotation != nil {
+		t.Fatalf("parents of a failed module must not be annotated")
+	}
+}
+
+func Test_annotateModules_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := annotateModules(ctx, testTree(), 4, func(ctx context.Context, m *Module) error {
+		t.Errorf("module %q annotated after cancellation", m.Name)
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -151,6 +151,12 @@ func collapseModules(m *Module) {
 	}
 }
 
+// TokenCount returns the number of tokens of text, counted like the files
+// recorded in the metadata.
+func TokenCount(text string) (int, error) {
+	return getFileTokenCount([]byte(text))
+}
+
 // getFileTokenCount uses the tiktoken-go library to determine the token count.
 func getFileTokenCount(content []byte) (int, error) {
 	enc, err := tokenizer.Get(tokenizer.Cl100kBase)
@@ -1,6 +1,7 @@
 package project
 
 import (
+	stdcontext "context"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
@@ -131,7 +132,9 @@ var systemExclusionPatterns = []string{
 // Returns an error if the metadata cannot be created, or if it already
 // exists.  If a ".vyb" folder exists in the root directory or any of its
 // subdirectories, this function returns an error.
-func Create(projectRoot string, provider string) error {
+//
+// Cancelling ctx aborts the annotation of the project's modules.
+func Create(ctx stdcontext.Context, projectRoot string, provider string) error {
 
 	if provider == "" {
 		provider = config.Default().Provider
@@ -176,7 +179,7 @@ func Create(projectRoot string, provider string) error {
 		return fmt.Errorf("failed to build metadata: %w", err)
 	}
 
-	err = annotate(cfg, metadata, rootFS)
+	err = annotate(ctx, cfg, metadata, rootFS)
 	if err != nil {
 		return fmt.Errorf("failed to annotate metadata: %w", err)
 	}
@@ -1,6 +1,7 @@
 package project
 
 import (
+	"context"
 	"fmt"
 	"github.com/vybdev/vyb/config"
 	"os"
@@ -79,7 +80,10 @@ func mergeAnnotations(fresh *Module, oldMap map[string]*Module) {
 //  3. Patch the stored metadata with the fresh snapshot.
 //  4. Run annotate so missing/invalid annotations are regenerated.
 //  5. Persist the updated metadata back to disk.
-func Update(projectRoot string) error {
+//
+// Cancelling ctx aborts the annotation step; the stored metadata is left
+// untouched in that case.
+func Update(ctx context.Context, projectRoot string) error {
 	// Ensure we have an absolute project root path.
 	absRoot, err := filepath.Abs(projectRoot)
 	if err != nil {
@@ -108,7 +112,7 @@ func Update(projectRoot string) error {
 		return err
 	}
 	// (re)annotate modules missing or with invalid annotations.
-	if err := annotate(cfg, stored, rootFS); err != nil {
+	if err := annotate(ctx, cfg, stored, rootFS); err != nil {
 		return err
 	}
 